@@ -37,6 +37,15 @@ type Client interface {
 	// ReadLongCharacteristic reads a characteristic value which is longer than the MTU. [Vol 3, Part G, 4.8.3]
 	ReadLongCharacteristic(c *Characteristic) ([]byte, error)
 
+	// ReadMultipleCharacteristics reads the values of two or more characteristics,
+	// which are concatenated. All but the last value have to be of fixed lengths
+	// known to the caller. [Vol 3, Part G, 4.8.4]
+	ReadMultipleCharacteristics(cs []*Characteristic) ([]byte, error)
+
+	// ReadMultipleVariableCharacteristics reads the values of two or more
+	// characteristics of variable lengths. [Vol 3, Part G, 4.8.5]
+	ReadMultipleVariableCharacteristics(cs []*Characteristic) ([][]byte, error)
+
 	// WriteCharacteristic writes a characteristic value to a server. [Vol 3, Part G, 4.9.3]
 	WriteCharacteristic(c *Characteristic, value []byte, noRsp bool) error
 
@@ -66,4 +75,19 @@ type Client interface {
 
 	// Disconnected returns a receiving channel, which is closed when the client disconnects.
 	Disconnected() <-chan struct{}
+
+	// DisconnectReason returns the platform specific reason the client was
+	// disconnected for, or nil if it's still connected.
+	DisconnectReason() error
+
+	// SecurityLevel returns the security level of the link. [Vol 3, Part C, 10.2.1]
+	SecurityLevel() SecurityLevel
+
+	// KeySize returns the size in octets of the key the link is encrypted
+	// with, or 0 if it isn't encrypted.
+	KeySize() int
+
+	// RaiseSecurity raises the security level of the link to at least level,
+	// by encrypting it with the keys of a previous bonding, or pairing.
+	RaiseSecurity(level SecurityLevel) error
 }
@@ -1,6 +1,7 @@
 package ble
 
 import (
+	"fmt"
 	"io"
 
 	"golang.org/x/net/context"
@@ -36,4 +37,45 @@ type Conn interface {
 
 	// Disconnected returns a receiving channel, which is closed when the connection disconnects.
 	Disconnected() <-chan struct{}
+
+	// DisconnectReason returns the platform specific reason the connection was
+	// disconnected for, or nil if it's still connected.
+	DisconnectReason() error
+
+	// SecurityLevel returns the security level of the link.
+	SecurityLevel() SecurityLevel
+
+	// KeySize returns the size in octets of the key the link is encrypted
+	// with, or 0 if it isn't encrypted.
+	KeySize() int
+
+	// RaiseSecurity raises the security level of the link to at least level,
+	// by encrypting it with the keys of a previous bonding, or pairing.
+	RaiseSecurity(ctx context.Context, level SecurityLevel) error
+}
+
+// SecurityLevel is the security level of an LE connection in LE security mode 1.
+// [Vol 3, Part C, 10.2.1]
+type SecurityLevel int
+
+// Security levels of LE security mode 1 [Vol 3, Part C, 10.2.1]
+const (
+	SecurityNone              SecurityLevel = 1 // No security (no authentication and no encryption)
+	SecurityUnauthenticated   SecurityLevel = 2 // Unauthenticated pairing with encryption
+	SecurityAuthenticated     SecurityLevel = 3 // Authenticated pairing with encryption
+	SecuritySecureConnections SecurityLevel = 4 // Authenticated LE Secure Connections pairing with 128-bit encryption
+)
+
+func (l SecurityLevel) String() string {
+	switch l {
+	case SecurityNone:
+		return "none"
+	case SecurityUnauthenticated:
+		return "unauthenticated"
+	case SecurityAuthenticated:
+		return "authenticated"
+	case SecuritySecureConnections:
+		return "secure connections"
+	}
+	return fmt.Sprintf("level %d", int(l))
 }
@@ -166,6 +166,16 @@ func (cln *Client) ReadLongCharacteristic(c *ble.Characteristic) ([]byte, error)
 	return nil, ble.ErrNotImplemented
 }
 
+// ReadMultipleCharacteristics reads the values of two or more characteristics. [Vol 3, Part G, 4.8.4]
+func (cln *Client) ReadMultipleCharacteristics(cs []*ble.Characteristic) ([]byte, error) {
+	return nil, ble.ErrNotImplemented
+}
+
+// ReadMultipleVariableCharacteristics reads the values of two or more characteristics of variable lengths. [Vol 3, Part G, 4.8.5]
+func (cln *Client) ReadMultipleVariableCharacteristics(cs []*ble.Characteristic) ([][]byte, error) {
+	return nil, ble.ErrNotImplemented
+}
+
 // WriteCharacteristic writes a characteristic value to a server. [Vol 3, Part G, 4.9.3]
 func (cln *Client) WriteCharacteristic(c *ble.Characteristic, b []byte, noRsp bool) error {
 	args := xpc.Dict{
@@ -276,6 +286,30 @@ func (cln *Client) Disconnected() <-chan struct{} {
 	return cln.conn.Disconnected()
 }
 
+// DisconnectReason returns the reason the client was disconnected for, or nil
+// if it's still connected.
+func (cln *Client) DisconnectReason() error {
+	return cln.conn.DisconnectReason()
+}
+
+// SecurityLevel returns the security level of the link, which CoreBluetooth
+// doesn't report.
+func (cln *Client) SecurityLevel() ble.SecurityLevel {
+	return cln.conn.SecurityLevel()
+}
+
+// KeySize returns the size of the encryption key, which CoreBluetooth doesn't
+// report.
+func (cln *Client) KeySize() int {
+	return cln.conn.KeySize()
+}
+
+// RaiseSecurity isn't supported. CoreBluetooth encrypts the link, or pairs,
+// when the remote device requires it.
+func (cln *Client) RaiseSecurity(level ble.SecurityLevel) error {
+	return cln.conn.RaiseSecurity(cln.conn.Context(), level)
+}
+
 type sub struct {
 	fn   ble.NotificationHandler
 	char *ble.Characteristic
@@ -1,6 +1,7 @@
 package darwin
 
 import (
+	"errors"
 	"sync"
 
 	"golang.org/x/net/context"
@@ -9,6 +10,10 @@ import (
 	"github.com/raff/goble/xpc"
 )
 
+// errDisconnected is the reason of all disconnections, which CoreBluetooth
+// doesn't report.
+var errDisconnected = errors.New("disconnected")
+
 func newConn(d *Device, a ble.Addr) *conn {
 	return &conn{
 		dev:   d,
@@ -96,6 +101,35 @@ func (c *conn) Disconnected() <-chan struct{} {
 	return c.done
 }
 
+// DisconnectReason returns the reason the connection was disconnected for, or
+// nil if it's still connected. CoreBluetooth doesn't tell the reason.
+func (c *conn) DisconnectReason() error {
+	select {
+	case <-c.done:
+		return errDisconnected
+	default:
+		return nil
+	}
+}
+
+// SecurityLevel returns the security level of the link. CoreBluetooth doesn't
+// report it, nor the encryption of the link.
+func (c *conn) SecurityLevel() ble.SecurityLevel {
+	return ble.SecurityNone
+}
+
+// KeySize returns the size of the encryption key, which CoreBluetooth doesn't
+// report.
+func (c *conn) KeySize() int {
+	return 0
+}
+
+// RaiseSecurity isn't supported. CoreBluetooth encrypts the link, or pairs,
+// when the remote device requires it.
+func (c *conn) RaiseSecurity(ctx context.Context, level ble.SecurityLevel) error {
+	return ble.ErrNotImplemented
+}
+
 // server (peripheral)
 func (c *conn) subscribed(char *ble.Characteristic) {
 	h := char.Handle
@@ -252,9 +252,11 @@ func (d *Device) AddService(s *ble.Service) error {
 	for _, c := range s.Characteristics {
 		props := 0
 		perm := 0
+		readEnc := c.Permission&(ble.PermReadEncrypted|ble.PermReadAuthenticated) != 0 || c.MinKeySize != 0
+		writeEnc := c.Permission&(ble.PermWriteEncrypted|ble.PermWriteAuthenticated) != 0 || c.MinKeySize != 0
 		if c.Property&ble.CharRead != 0 {
 			props |= 0x02
-			if ble.CharRead&c.Secure != 0 {
+			if ble.CharRead&c.Secure != 0 || readEnc {
 				perm |= 0x04
 			} else {
 				perm |= 0x01
@@ -262,7 +264,7 @@ func (d *Device) AddService(s *ble.Service) error {
 		}
 		if c.Property&ble.CharWriteNR != 0 {
 			props |= 0x04
-			if c.Secure&ble.CharWriteNR != 0 {
+			if c.Secure&ble.CharWriteNR != 0 || writeEnc {
 				perm |= 0x08
 			} else {
 				perm |= 0x02
@@ -270,7 +272,7 @@ func (d *Device) AddService(s *ble.Service) error {
 		}
 		if c.Property&ble.CharWrite != 0 {
 			props |= 0x08
-			if c.Secure&ble.CharWrite != 0 {
+			if c.Secure&ble.CharWrite != 0 || writeEnc {
 				perm |= 0x08
 			} else {
 				perm |= 0x02
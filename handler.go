@@ -33,6 +33,15 @@ func (f WriteHandlerFunc) ServeWrite(req Request, rsp ResponseWriter) {
 	f(req, rsp)
 }
 
+// A WriteValidator is a WriteHandler, which can check the value of a write
+// without serving it. The server checks all the values of an Execute Write
+// Request before it serves any, so a value refused leaves all the attributes
+// unwritten.
+type WriteValidator interface {
+	WriteHandler
+	ValidateWrite(req Request) ATTError
+}
+
 // A NotifyHandler handles GATT requests.
 type NotifyHandler interface {
 	ServeNotify(req Request, n Notifier)
@@ -46,6 +55,19 @@ func (f NotifyHandlerFunc) ServeNotify(req Request, n Notifier) {
 	f(req, n)
 }
 
+// An AuthorizeHandler authorizes GATT requests to attributes requiring authorization.
+type AuthorizeHandler interface {
+	Authorize(req Request, write bool) bool
+}
+
+// AuthorizeHandlerFunc is an adapter to allow the use of ordinary functions as Handlers.
+type AuthorizeHandlerFunc func(req Request, write bool) bool
+
+// Authorize returns f(req, write).
+func (f AuthorizeHandlerFunc) Authorize(req Request, write bool) bool {
+	return f(req, write)
+}
+
 // Request ...
 type Request interface {
 	Conn() Conn
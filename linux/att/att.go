@@ -1,6 +1,10 @@
 package att
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/currantlabs/ble"
+)
 
 var (
 	// ErrInvalidArgument means one or more of the arguments are invalid.
@@ -12,19 +16,38 @@ var (
 	// ErrSeqProtoTimeout means the request hasn't been acknowledged in 30 seconds.
 	// [Vol 3, Part F, 3.3.3]
 	ErrSeqProtoTimeout = errors.New("req timeout")
+
+	// ErrSigningNotSupp means the connection doesn't support data signing.
+	ErrSigningNotSupp = errors.New("data signing not supported")
 )
 
+// A signer is a connection supporting data signing, which signs and verifies
+// the Signed Write Commands with the CSRKs of the devices. [Vol 3, Part C, 10.4]
+type signer interface {
+	// SignData returns the signature of the data signed by the local device.
+	SignData(data []byte) ([12]byte, error)
+
+	// VerifyData verifies the signature of the data signed by the remote
+	// device, and returns the security level of its CSRK.
+	VerifyData(data []byte, sig [12]byte) (ble.SecurityLevel, error)
+}
+
+// commandFlag is the Command Flag of the Attribute Opcode, which is set in the
+// PDUs not answered by a response. [Vol 3, Part F, 3.3.1]
+const commandFlag = 0x40
+
 var rspOfReq = map[byte]byte{
-	ExchangeMTURequestCode:     ExchangeMTUResponseCode,
-	FindInformationRequestCode: FindInformationResponseCode,
-	FindByTypeValueRequestCode: FindByTypeValueResponseCode,
-	ReadByTypeRequestCode:      ReadByTypeResponseCode,
-	ReadRequestCode:            ReadResponseCode,
-	ReadBlobRequestCode:        ReadBlobResponseCode,
-	ReadMultipleRequestCode:    ReadMultipleResponseCode,
-	ReadByGroupTypeRequestCode: ReadByGroupTypeResponseCode,
-	WriteRequestCode:           WriteResponseCode,
-	PrepareWriteRequestCode:    PrepareWriteResponseCode,
-	ExecuteWriteRequestCode:    ExecuteWriteResponseCode,
-	HandleValueIndicationCode:  HandleValueConfirmationCode,
+	ExchangeMTURequestCode:          ExchangeMTUResponseCode,
+	FindInformationRequestCode:      FindInformationResponseCode,
+	FindByTypeValueRequestCode:      FindByTypeValueResponseCode,
+	ReadByTypeRequestCode:           ReadByTypeResponseCode,
+	ReadRequestCode:                 ReadResponseCode,
+	ReadBlobRequestCode:             ReadBlobResponseCode,
+	ReadMultipleRequestCode:         ReadMultipleResponseCode,
+	ReadMultipleVariableRequestCode: ReadMultipleVariableResponseCode,
+	ReadByGroupTypeRequestCode:      ReadByGroupTypeResponseCode,
+	WriteRequestCode:                WriteResponseCode,
+	PrepareWriteRequestCode:         PrepareWriteResponseCode,
+	ExecuteWriteRequestCode:         ExecuteWriteResponseCode,
+	HandleValueIndicationCode:       HandleValueConfirmationCode,
 }
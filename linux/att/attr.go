@@ -11,4 +11,9 @@ type attr struct {
 	v  []byte
 	rh ble.ReadHandler
 	wh ble.WriteHandler
+
+	// Security requirements of accessing the attribute.
+	perm       ble.Permission
+	minKeySize int
+	ah         ble.AuthorizeHandler
 }
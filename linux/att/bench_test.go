@@ -0,0 +1,41 @@
+package att
+
+import (
+	"testing"
+
+	"github.com/currantlabs/ble"
+)
+
+// memConn is an in-memory ble.Conn, which discards the written PDUs.
+type memConn struct {
+	ble.Conn
+	txMTU int
+}
+
+func (c *memConn) Write(b []byte) (int, error) { return len(b), nil }
+func (c *memConn) RxMTU() int                  { return ble.MaxMTU }
+func (c *memConn) TxMTU() int                  { return c.txMTU }
+func (c *memConn) SetTxMTU(mtu int)            { c.txMTU = mtu }
+
+func newBenchServer(b *testing.B) *Server {
+	svc := ble.NewService(ble.UUID16(0x180F))
+	svc.NewCharacteristic(ble.UUID16(0x2A19)).SetValue([]byte{100})
+	s, err := NewServer(NewDB([]*ble.Service{svc}, 1), &memConn{txMTU: ble.DefaultMTU})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+func BenchmarkServerNotify(b *testing.B) {
+	s := newBenchServer(b)
+	data := make([]byte, 20)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.notify(0x0003, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
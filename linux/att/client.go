@@ -291,6 +291,60 @@ func (c *Client) ReadMultiple(handles []uint16) ([]byte, error) {
 	return rsp.SetOfValues(), nil
 }
 
+// ReadMultipleVariable requests the server to read two or more values of a
+// set of attributes, which have variable lengths, and return their values in a
+// Read Multiple Variable Response. Since the response is truncated to ATT_MTU - 1
+// octets, only the values received in whole are returned, which may be fewer
+// than the handles. [Vol 3, Part F, 3.4.4.11 & 3.4.4.12]
+func (c *Client) ReadMultipleVariable(handles []uint16) ([][]byte, error) {
+	// Should request to read two or more values.
+	if len(handles) < 2 || len(handles)*2 > c.l2c.TxMTU()-1 {
+		return nil, ErrInvalidArgument
+	}
+
+	// Acquire and reuse the txBuf, and release it after usage.
+	txBuf := <-c.chTxBuf
+	defer func() { c.chTxBuf <- txBuf }()
+
+	req := ReadMultipleVariableRequest(txBuf[:1+len(handles)*2])
+	req.SetAttributeOpcode()
+	p := req.SetOfHandles()
+	for _, h := range handles {
+		binary.LittleEndian.PutUint16(p, h)
+		p = p[2:]
+	}
+
+	b, err := c.sendReq(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert and validate the response.
+	rsp := ReadMultipleVariableResponse(b)
+	switch {
+	case rsp[0] == ErrorResponseCode && len(rsp) == 5:
+		return nil, ble.ATTError(rsp[4])
+	case rsp[0] == ErrorResponseCode && len(rsp) != 5:
+		fallthrough
+	case rsp[0] != rsp.AttributeOpcode():
+		fallthrough
+	case len(rsp) < 1:
+		return nil, ErrInvalidResponse
+	}
+
+	var vs [][]byte
+	for l := rsp.LengthValueTupleList(); len(l) >= 2 && len(vs) < len(handles); {
+		n := int(binary.LittleEndian.Uint16(l))
+		l = l[2:]
+		if n > len(l) {
+			break
+		}
+		vs = append(vs, append([]byte(nil), l[:n]...))
+		l = l[n:]
+	}
+	return vs, nil
+}
+
 // ReadByGroupType obtains the values of attributes where the attribute type is known,
 // the type of a grouping attribute as defined by a higher layer specification, but
 // the handle is not known. [Vol 3, Part F, 3.4.4.9 & 3.4.4.10]
@@ -386,11 +440,16 @@ func (c *Client) WriteCommand(handle uint16, value []byte) error {
 }
 
 // SignedWrite requests the server to write the value of an attribute with an authentication
-// signature, typically into a control-point attribute. [Vol 3, Part F, 3.4.5.4]
-func (c *Client) SignedWrite(handle uint16, value []byte, signature [12]byte) error {
+// signature, typically into a control-point attribute. The command is signed with the CSRK
+// of the connection. [Vol 3, Part F, 3.4.5.4]
+func (c *Client) SignedWrite(handle uint16, value []byte) error {
 	if len(value) > c.l2c.TxMTU()-15 {
 		return ErrInvalidArgument
 	}
+	sg, ok := c.l2c.(signer)
+	if !ok {
+		return ErrSigningNotSupp
+	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
 	txBuf := <-c.chTxBuf
@@ -400,7 +459,14 @@ func (c *Client) SignedWrite(handle uint16, value []byte, signature [12]byte) er
 	req.SetAttributeOpcode()
 	req.SetAttributeHandle(handle)
 	req.SetAttributeValue(value)
-	req.SetAuthenticationSignature(signature)
+
+	// The signature follows the variable-length value, and signs the rest of
+	// the command.
+	sig, err := sg.SignData(req[:3+len(value)])
+	if err != nil {
+		return errors.Wrap(err, "can't sign command")
+	}
+	copy(req[3+len(value):], sig[:])
 
 	return c.sendCmd(req)
 }
@@ -111,6 +111,10 @@ func genCharAttr(c *ble.Characteristic, h uint16) (uint16, []*attr) {
 		v:   c.Value,
 		rh:  c.ReadHandler,
 		wh:  c.WriteHandler,
+
+		perm:       c.Permission,
+		minKeySize: c.MinKeySize,
+		ah:         c.AuthorizeHandler,
 	}
 
 	c.Handle = h
@@ -139,6 +143,10 @@ func genDescAttr(d *ble.Descriptor, h uint16) *attr {
 		v:   d.Value,
 		rh:  d.ReadHandler,
 		wh:  d.WriteHandler,
+
+		perm:       d.Permission,
+		minKeySize: d.MinKeySize,
+		ah:         d.AuthorizeHandler,
 	}
 }
 
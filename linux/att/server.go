@@ -18,6 +18,17 @@ type conn struct {
 	in   map[uint16]ble.Notifier
 }
 
+// DefaultPrepQueueLimit is the default number of Prepare Write Requests a
+// client can queue on a connection.
+const DefaultPrepQueueLimit = 64
+
+// prepWrite is a part of a value queued by a Prepare Write Request.
+type prepWrite struct {
+	h      uint16
+	offset int
+	value  []byte
+}
+
 // Server implementas an ATT (Attribute Protocol) server.
 type Server struct {
 	conn *conn
@@ -31,6 +42,12 @@ type Server struct {
 	chIndBuf  chan []byte
 	chConfirm chan bool
 
+	// prepQueue is the queue of the Prepare Write Requests of the client,
+	// which are executed or cancelled by the Execute Write Request.
+	// [Vol 3, Part F, 3.4.6]
+	prepQueue []prepWrite
+	prepLimit int
+
 	dummyRspWriter ble.ResponseWriter
 }
 
@@ -57,6 +74,7 @@ func NewServer(db *DB, l2c ble.Conn) (*Server, error) {
 		chNotBuf:  make(chan []byte, 1),
 		chIndBuf:  make(chan []byte, 1),
 		chConfirm: make(chan bool),
+		prepLimit: DefaultPrepQueueLimit,
 
 		dummyRspWriter: ble.NewResponseWriter(nil),
 	}
@@ -66,6 +84,13 @@ func NewServer(db *DB, l2c ble.Conn) (*Server, error) {
 	return s, nil
 }
 
+// SetPrepQueueLimit sets the number of Prepare Write Requests the client can
+// queue, beyond which they fail with ErrPrepQueueFull. It has to be set before
+// the server loops.
+func (s *Server) SetPrepQueueLimit(n int) {
+	s.prepLimit = n
+}
+
 // notify sends notification to remote central.
 func (s *Server) notify(h uint16, data []byte) (int, error) {
 	// Acquire and reuse notifyBuffer. Release it after usage.
@@ -75,13 +100,8 @@ func (s *Server) notify(h uint16, data []byte) (int, error) {
 	rsp := HandleValueNotification(nBuf)
 	rsp.SetAttributeOpcode()
 	rsp.SetAttributeHandle(h)
-	buf := bytes.NewBuffer(rsp.AttributeValue())
-	buf.Reset()
-	if len(data) > buf.Cap() {
-		data = data[:buf.Cap()]
-	}
-	buf.Write(data)
-	return s.conn.Write(rsp[:3+buf.Len()])
+	n := copy(rsp.AttributeValue(), data)
+	return s.conn.Write(rsp[:3+n])
 }
 
 // indicate sends indication to remote central.
@@ -93,13 +113,8 @@ func (s *Server) indicate(h uint16, data []byte) (int, error) {
 	rsp := HandleValueIndication(iBuf)
 	rsp.SetAttributeOpcode()
 	rsp.SetAttributeHandle(h)
-	buf := bytes.NewBuffer(rsp.AttributeValue())
-	buf.Reset()
-	if len(data) > buf.Cap() {
-		data = data[:buf.Cap()]
-	}
-	buf.Write(data)
-	n, err := s.conn.Write(rsp[:3+buf.Len()])
+	vlen := copy(rsp.AttributeValue(), data)
+	n, err := s.conn.Write(rsp[:3+vlen])
 	if err != nil {
 		return n, err
 	}
@@ -191,12 +206,22 @@ func (s *Server) handleRequest(b []byte) []byte {
 		resp = s.handleWriteRequest(b)
 	case WriteCommandCode:
 		s.handleWriteCommand(b)
-	case ReadMultipleRequestCode,
-		PrepareWriteRequestCode,
-		ExecuteWriteRequestCode,
-		SignedWriteCommandCode:
-		fallthrough
+	case SignedWriteCommandCode:
+		s.handleSignedWriteCommand(b)
+	case PrepareWriteRequestCode:
+		resp = s.handlePrepareWriteRequest(b)
+	case ExecuteWriteRequestCode:
+		resp = s.handleExecuteWriteRequest(b)
+	case ReadMultipleRequestCode:
+		resp = s.handleReadMultipleRequest(b)
+	case ReadMultipleVariableRequestCode:
+		resp = s.handleReadMultipleVariableRequest(b)
 	default:
+		// Unsupported commands, which have the Command Flag set, are
+		// ignored. [Vol 3, Part F, 3.3.1]
+		if reqType&commandFlag != 0 {
+			return nil
+		}
 		resp = newErrorResponse(reqType, 0x0000, ble.ErrReqNotSupp)
 	}
 	logger.Debug("server", "rsp", fmt.Sprintf("% X", resp))
@@ -213,7 +238,11 @@ func (s *Server) handleExchangeMTURequest(r ExchangeMTURequest) []byte {
 		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
 	}
 
+	// The ATT_MTU is the minimum of the Client Rx MTU and the Server Rx MTU.
 	txMTU := int(r.ClientRxMTU())
+	if txMTU > s.rxMTU {
+		txMTU = s.rxMTU
+	}
 	s.conn.SetTxMTU(txMTU)
 
 	if txMTU != len(s.txBuf) {
@@ -295,25 +324,24 @@ func (s *Server) handleFindByTypeValueRequest(r FindByTypeValueRequest) []byte {
 	buf.Reset()
 
 	for _, a := range s.db.subrange(r.StartingHandle(), r.EndingHandle()) {
-		v, starth, endh := a.v, a.h, a.endh
 		if !(ble.UUID(a.typ).Equal(ble.UUID16(r.AttributeType()))) {
 			continue
 		}
-		if v == nil {
-			// The value shall not exceed ATT_MTU - 7 bytes.
-			// Since ResponseWriter caps the value at the capacity,
-			// we allocate one extra byte, and the written length.
-			buf2 := bytes.NewBuffer(make([]byte, 0, len(s.txBuf)-7+1))
-			e := handleATT(a, s.conn, r, ble.NewResponseWriter(buf2))
-			if e != ble.ErrSuccess || buf2.Len() > len(s.txBuf)-7 {
-				return newErrorResponse(r.AttributeOpcode(), r.StartingHandle(), ble.ErrInvalidHandle)
-			}
-			endh = a.h
-		}
-		if !(ble.UUID(v).Equal(ble.UUID(r.AttributeValue()))) {
+
+		// The value can't be longer than ATT_MTU - 7 octets, and attributes
+		// whose values can't be read don't match.
+		v, e := s.readValue(a.h, r, len(s.txBuf)-7)
+		if e != ble.ErrSuccess || !bytes.Equal(v, r.AttributeValue()) {
 			continue
 		}
 
+		// The group end handle is the found handle, if the attribute isn't
+		// a grouping one. [Vol 3, Part F, 3.4.3.4]
+		starth, endh := a.h, a.endh
+		if endh == 0 {
+			endh = a.h
+		}
+
 		if buf.Len()+4 > buf.Cap() {
 			break
 		}
@@ -350,12 +378,19 @@ func (s *Server) handleReadByTypeRequest(r ReadByTypeRequest) []byte {
 			continue
 		}
 		v := a.v
-		if v == nil {
+		if v != nil {
+			if e := checkPermission(a, ble.NewRequest(s.conn, nil, 0), false, ble.SecurityNone); e != ble.ErrSuccess {
+				if dlen == 0 {
+					return newErrorResponse(r.AttributeOpcode(), a.h, e)
+				}
+				break
+			}
+		} else {
 			buf2 := bytes.NewBuffer(make([]byte, 0, len(s.txBuf)-2))
 			if e := handleATT(a, s.conn, r, ble.NewResponseWriter(buf2)); e != ble.ErrSuccess {
 				// Return if the first value read cause an error.
 				if dlen == 0 {
-					return newErrorResponse(r.AttributeOpcode(), r.StartingHandle(), e)
+					return newErrorResponse(r.AttributeOpcode(), a.h, e)
 				}
 				// Otherwise, skip to the next one.
 				break
@@ -406,10 +441,14 @@ func (s *Server) handleReadRequest(r ReadRequest) []byte {
 		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrInvalidHandle)
 	}
 
-	// Simple case. Read-only, no-authorization, no-authentication.
+	// Simple case. Read-only static value, which is truncated to ATT_MTU - 1
+	// octets.
 	if a.v != nil {
-		binary.Write(buf, binary.LittleEndian, a.v)
-		return rsp[:1+buf.Len()]
+		if e := checkPermission(a, ble.NewRequest(s.conn, nil, 0), false, ble.SecurityNone); e != ble.ErrSuccess {
+			return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), e)
+		}
+		n := copy(rsp.AttributeValue(), a.v)
+		return rsp[:1+n]
 	}
 
 	// Pass the request to upper layer with the ResponseWriter, which caps
@@ -438,10 +477,17 @@ func (s *Server) handleReadBlobRequest(r ReadBlobRequest) []byte {
 	buf := bytes.NewBuffer(rsp.PartAttributeValue())
 	buf.Reset()
 
-	// Simple case. Read-only, no-authorization, no-authentication.
+	// Simple case. Read-only static value, which is read from the offset, and
+	// truncated to ATT_MTU - 1 octets.
 	if a.v != nil {
-		binary.Write(buf, binary.LittleEndian, a.v)
-		return rsp[:1+buf.Len()]
+		if e := checkPermission(a, ble.NewRequest(s.conn, nil, int(r.ValueOffset())), false, ble.SecurityNone); e != ble.ErrSuccess {
+			return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), e)
+		}
+		if int(r.ValueOffset()) > len(a.v) {
+			return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrInvalidOffset)
+		}
+		n := copy(rsp.PartAttributeValue(), a.v[r.ValueOffset():])
+		return rsp[:1+n]
 	}
 
 	// Pass the request to upper layer with the ResponseWriter, which caps
@@ -452,7 +498,82 @@ func (s *Server) handleReadBlobRequest(r ReadBlobRequest) []byte {
 	return rsp[:1+buf.Len()]
 }
 
-// handle Read Blob request. [Vol 3, Part F, 3.4.4.9 & 3.4.4.10]
+// handle Read Multiple request. [Vol 3, Part F, 3.4.4.7 & 3.4.4.8]
+func (s *Server) handleReadMultipleRequest(r ReadMultipleRequest) []byte {
+	// Validate the request.
+	switch {
+	case len(r) < 5 || len(r)%2 != 1:
+		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
+	}
+
+	rsp := ReadMultipleResponse(s.txBuf)
+	rsp.SetAttributeOpcode()
+	buf := bytes.NewBuffer(rsp.SetOfValues())
+	buf.Reset()
+
+	// The values are concatenated, and truncated to ATT_MTU - 1 octets.
+	for hs := r.SetOfHandles(); len(hs) > 0; hs = hs[2:] {
+		h := binary.LittleEndian.Uint16(hs)
+		v, e := s.readValue(h, r, buf.Cap()-buf.Len())
+		if e != ble.ErrSuccess {
+			return newErrorResponse(r.AttributeOpcode(), h, e)
+		}
+		buf.Write(v)
+	}
+	return rsp[:1+buf.Len()]
+}
+
+// handle Read Multiple Variable request. [Vol 3, Part F, 3.4.4.11 & 3.4.4.12]
+func (s *Server) handleReadMultipleVariableRequest(r ReadMultipleVariableRequest) []byte {
+	// Validate the request.
+	switch {
+	case len(r) < 5 || len(r)%2 != 1:
+		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
+	}
+
+	rsp := ReadMultipleVariableResponse(s.txBuf)
+	rsp.SetAttributeOpcode()
+	buf := bytes.NewBuffer(make([]byte, 0, (len(r)-1)/2*(2+ble.MaxMTU-3)))
+
+	// Each value follows its length, which is the one of the whole value,
+	// while the list is truncated to ATT_MTU - 1 octets.
+	for hs := r.SetOfHandles(); len(hs) > 0; hs = hs[2:] {
+		h := binary.LittleEndian.Uint16(hs)
+		v, e := s.readValue(h, r, ble.MaxMTU-3)
+		if e != ble.ErrSuccess {
+			return newErrorResponse(r.AttributeOpcode(), h, e)
+		}
+		binary.Write(buf, binary.LittleEndian, uint16(len(v)))
+		buf.Write(v)
+	}
+	n := copy(rsp.LengthValueTupleList(), buf.Bytes())
+	return rsp[:1+n]
+}
+
+// readValue reads the value of the attribute for the request, which is at most
+// n octets long.
+func (s *Server) readValue(h uint16, r []byte, n int) ([]byte, ble.ATTError) {
+	a, ok := s.db.at(h)
+	if !ok {
+		return nil, ble.ErrInvalidHandle
+	}
+	if a.v != nil {
+		if e := checkPermission(a, ble.NewRequest(s.conn, nil, 0), false, ble.SecurityNone); e != ble.ErrSuccess {
+			return nil, e
+		}
+		if len(a.v) > n {
+			return a.v[:n], ble.ErrSuccess
+		}
+		return a.v, ble.ErrSuccess
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, n))
+	if e := handleATT(a, s.conn, r, ble.NewResponseWriter(buf)); e != ble.ErrSuccess {
+		return nil, e
+	}
+	return buf.Bytes(), ble.ErrSuccess
+}
+
+// handle Read By Group Type request. [Vol 3, Part F, 3.4.4.9 & 3.4.4.10]
 func (s *Server) handleReadByGroupRequest(r ReadByGroupTypeRequest) []byte {
 	// Validate the request.
 	switch {
@@ -462,6 +583,13 @@ func (s *Server) handleReadByGroupRequest(r ReadByGroupTypeRequest) []byte {
 		return newErrorResponse(r.AttributeOpcode(), r.StartingHandle(), ble.ErrInvalidHandle)
 	}
 
+	// The services are the only grouping attributes of GATT, which can be
+	// read by group type. [Vol 3, Part G, 2.5.3]
+	typ := ble.UUID(r.AttributeGroupType())
+	if !typ.Equal(ble.PrimaryServiceUUID) && !typ.Equal(ble.SecondaryServiceUUID) {
+		return newErrorResponse(r.AttributeOpcode(), r.StartingHandle(), ble.ErrUnsuppGrpType)
+	}
+
 	rsp := ReadByGroupTypeResponse(s.txBuf)
 	rsp.SetAttributeOpcode()
 	buf := bytes.NewBuffer(rsp.AttributeDataList())
@@ -469,13 +597,16 @@ func (s *Server) handleReadByGroupRequest(r ReadByGroupTypeRequest) []byte {
 
 	dlen := 0
 	for _, a := range s.db.subrange(r.StartingHandle(), r.EndingHandle()) {
-		v := a.v
-		if v == nil {
-			buf2 := bytes.NewBuffer(make([]byte, buf.Cap()-buf.Len()-4))
-			if e := handleATT(a, s.conn, r, ble.NewResponseWriter(buf2)); e != ble.ErrSuccess {
-				return newErrorResponse(r.AttributeOpcode(), r.StartingHandle(), e)
+		if !a.typ.Equal(typ) {
+			continue
+		}
+		v, e := s.readValue(a.h, r, buf.Cap()-4)
+		if e != ble.ErrSuccess {
+			// Return if the first value read cause an error.
+			if dlen == 0 {
+				return newErrorResponse(r.AttributeOpcode(), a.h, e)
 			}
-			v = buf2.Bytes()
+			break
 		}
 		if dlen == 0 {
 			dlen = 4 + len(v)
@@ -530,7 +661,7 @@ func (s *Server) handleWriteRequest(r WriteRequest) []byte {
 func (s *Server) handleWriteCommand(r WriteCommand) []byte {
 	// Validate the request.
 	switch {
-	case len(r) <= 3:
+	case len(r) < 3:
 		return nil
 	}
 
@@ -549,6 +680,158 @@ func (s *Server) handleWriteCommand(r WriteCommand) []byte {
 	return nil
 }
 
+// handle Prepare Write request. [Vol 3, Part F, 3.4.6.1 & 3.4.6.2]
+func (s *Server) handlePrepareWriteRequest(r PrepareWriteRequest) []byte {
+	// Validate the request.
+	switch {
+	case len(r) < 5:
+		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
+	}
+
+	a, ok := s.db.at(r.AttributeHandle())
+	if !ok {
+		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrInvalidHandle)
+	}
+	if a.wh == nil {
+		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrWriteNotPerm)
+	}
+
+	// The permissions are checked when the value is queued, while the offset
+	// and the length of the value are checked when the queue is executed.
+	req := ble.NewRequest(s.conn, r.PartAttributeValue(), int(r.ValueOffset()))
+	if e := checkPermission(a, req, true, ble.SecurityNone); e != ble.ErrSuccess {
+		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), e)
+	}
+	if len(s.prepQueue) >= s.prepLimit {
+		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrPrepQueueFull)
+	}
+	s.prepQueue = append(s.prepQueue, prepWrite{
+		h:      r.AttributeHandle(),
+		offset: int(r.ValueOffset()),
+		value:  append([]byte(nil), r.PartAttributeValue()...),
+	})
+
+	// The response echoes the request, so the client can verify the value
+	// has been queued as sent.
+	rsp := PrepareWriteResponse(s.txBuf)
+	n := copy(rsp, r)
+	rsp.SetAttributeOpcode()
+	return rsp[:n]
+}
+
+// handle Execute Write request. [Vol 3, Part F, 3.4.6.3 & 3.4.6.4]
+func (s *Server) handleExecuteWriteRequest(r ExecuteWriteRequest) []byte {
+	// Validate the request.
+	switch {
+	case len(r) != 2 || r.Flags() > 0x01:
+		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
+	}
+
+	q := s.prepQueue
+	s.prepQueue = nil
+	if r.Flags() == 0x01 {
+		if h, e := s.executeWrites(q); e != ble.ErrSuccess {
+			return newErrorResponse(r.AttributeOpcode(), h, e)
+		}
+	}
+	return []byte{ExecuteWriteResponseCode}
+}
+
+// executeWrites assembles the values of the queued writes, and writes them in
+// the order their attributes were first queued. None is written if the offset
+// or the length of any value is invalid, or if a handler implementing
+// ble.WriteValidator refuses its value. A handler failing to serve its write
+// leaves the attributes written before it written.
+func (s *Server) executeWrites(q []prepWrite) (uint16, ble.ATTError) {
+	type write struct {
+		a      *attr
+		offset int
+		value  []byte
+	}
+	var ws []*write
+	byHandle := make(map[uint16]*write)
+	for _, p := range q {
+		w, ok := byHandle[p.h]
+		if !ok {
+			a, _ := s.db.at(p.h)
+			w = &write{a: a, offset: p.offset}
+			byHandle[p.h] = w
+			ws = append(ws, w)
+		}
+
+		// A part may overwrite the value assembled so far, or follow it,
+		// but can't leave a gap.
+		i := p.offset - w.offset
+		if i < 0 || i > len(w.value) {
+			return p.h, ble.ErrInvalidOffset
+		}
+		if end := i + len(p.value); end > len(w.value) {
+			w.value = append(w.value, make([]byte, end-len(w.value))...)
+		}
+		copy(w.value[i:], p.value)
+
+		// The maximum length of an attribute value shall be 512 octets.
+		// [Vol 3, Part F, 3.2.9]
+		if w.offset+len(w.value) > ble.MaxMTU-3 {
+			return p.h, ble.ErrInvalAttrValueLen
+		}
+	}
+	for _, w := range ws {
+		v, ok := w.a.wh.(ble.WriteValidator)
+		if !ok {
+			continue
+		}
+		if e := v.ValidateWrite(ble.NewRequest(s.conn, w.value, w.offset)); e != ble.ErrSuccess {
+			return w.a.h, e
+		}
+	}
+	for _, w := range ws {
+		rsp := ble.NewResponseWriter(nil)
+		rsp.SetStatus(ble.ErrSuccess)
+		w.a.wh.ServeWrite(ble.NewRequest(s.conn, w.value, w.offset), rsp)
+		if e := rsp.Status(); e != ble.ErrSuccess {
+			return w.a.h, e
+		}
+	}
+	return 0x0000, ble.ErrSuccess
+}
+
+// handle Signed Write command. [Vol 3, Part F, 3.4.5.4]
+func (s *Server) handleSignedWriteCommand(r SignedWriteCommand) []byte {
+	// Validate the request.
+	switch {
+	case len(r) < 15:
+		return nil
+	}
+
+	a, ok := s.db.at(r.AttributeHandle())
+	if !ok || a.wh == nil {
+		return nil
+	}
+	sg, ok := s.conn.Conn.(signer)
+	if !ok {
+		return nil
+	}
+
+	// The signature follows the variable-length value, and signs the rest of
+	// the command. The command is dropped if the signature is invalid.
+	n := len(r) - 12
+	var sig [12]byte
+	copy(sig[:], r[n:])
+	signed, err := sg.VerifyData(r[:n], sig)
+	if err != nil {
+		logger.Warn("server", "signed write", fmt.Sprintf("0x%04X: %s", r.AttributeHandle(), err))
+		return nil
+	}
+	req := ble.NewRequest(s.conn, r[3:n], 0)
+	if e := checkPermission(a, req, true, signed); e != ble.ErrSuccess {
+		logger.Warn("server", "signed write", fmt.Sprintf("0x%04X: %s", r.AttributeHandle(), e))
+		return nil
+	}
+	a.wh.ServeWrite(req, s.dummyRspWriter)
+	return nil
+}
+
 func newErrorResponse(op byte, h uint16, s ble.ATTError) []byte {
 	r := ErrorResponse(make([]byte, 5))
 	r.SetAttributeOpcode()
@@ -558,24 +841,56 @@ func newErrorResponse(op byte, h uint16, s ble.ATTError) []byte {
 	return r
 }
 
+// checkPermission returns the error of the request, if the link doesn't meet
+// the security requirements of the attribute. The signed is the security level
+// of the CSRK a Signed Write Command is signed with, which authenticates the
+// command without encryption. [Vol 3, Part F, 3.2.5] & [Vol 3, Part C, 10.2.2]
+func checkPermission(a *attr, req ble.Request, write bool, signed ble.SecurityLevel) ble.ATTError {
+	enc, authen, author := ble.PermReadEncrypted, ble.PermReadAuthenticated, ble.PermReadAuthorized
+	if write {
+		enc, authen, author = ble.PermWriteEncrypted, ble.PermWriteAuthenticated, ble.PermWriteAuthorized
+	}
+	level := req.Conn().SecurityLevel()
+	switch {
+	case a.perm&authen != 0 && level < ble.SecurityAuthenticated && signed < ble.SecurityAuthenticated:
+		return ble.ErrAuthentication
+	case (a.perm&enc != 0 || a.minKeySize != 0) && level < ble.SecurityUnauthenticated:
+		return ble.ErrInsuffEnc
+	case a.minKeySize != 0 && req.Conn().KeySize() < a.minKeySize:
+		return ble.ErrInsuffEncrKeySize
+	case a.perm&author != 0 && (a.ah == nil || !a.ah.Authorize(req, write)):
+		return ble.ErrAuthorization
+	}
+	return ble.ErrSuccess
+}
+
 func handleATT(a *attr, conn ble.Conn, req []byte, rsp ble.ResponseWriter) ble.ATTError {
 	rsp.SetStatus(ble.ErrSuccess)
 	var offset int
 	var data []byte
 	switch req[0] {
-	case ReadByTypeRequestCode:
+	case ReadByTypeRequestCode, ReadMultipleRequestCode, ReadMultipleVariableRequestCode,
+		FindByTypeValueRequestCode, ReadByGroupTypeRequestCode:
 		fallthrough
 	case ReadRequestCode:
 		if a.rh == nil {
 			return ble.ErrReadNotPerm
 		}
-		a.rh.ServeRead(ble.NewRequest(conn, data, offset), rsp)
+		r := ble.NewRequest(conn, data, offset)
+		if e := checkPermission(a, r, false, ble.SecurityNone); e != ble.ErrSuccess {
+			return e
+		}
+		a.rh.ServeRead(r, rsp)
 	case ReadBlobRequestCode:
 		if a.rh == nil {
 			return ble.ErrReadNotPerm
 		}
 		offset = int(ReadBlobRequest(req).ValueOffset())
-		a.rh.ServeRead(ble.NewRequest(conn, data, offset), rsp)
+		r := ble.NewRequest(conn, data, offset)
+		if e := checkPermission(a, r, false, ble.SecurityNone); e != ble.ErrSuccess {
+			return e
+		}
+		a.rh.ServeRead(r, rsp)
 	case WriteRequestCode:
 		fallthrough
 	case WriteCommandCode:
@@ -583,12 +898,11 @@ func handleATT(a *attr, conn ble.Conn, req []byte, rsp ble.ResponseWriter) ble.A
 			return ble.ErrWriteNotPerm
 		}
 		data = WriteRequest(req).AttributeValue()
-		a.wh.ServeWrite(ble.NewRequest(conn, data, offset), rsp)
-	// case PrepareWriteRequestCode:
-	// case ExecuteWriteRequestCode:
-	// case SignedWriteCommandCode:
-	// case ReadByGroupTypeRequestCode:
-	// case ReadMultipleRequestCode:
+		r := ble.NewRequest(conn, data, offset)
+		if e := checkPermission(a, r, true, ble.SecurityNone); e != ble.ErrSuccess {
+			return e
+		}
+		a.wh.ServeWrite(r, rsp)
 	default:
 		return ble.ErrReqNotSupp
 	}
@@ -0,0 +1,782 @@
+package att
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/currantlabs/ble"
+	"golang.org/x/net/context"
+)
+
+// bearer is an in-memory ble.Conn, which carries the PDUs between a test, as
+// the client, and the server.
+type bearer struct {
+	rxMTU   int
+	txMTU   int
+	level   ble.SecurityLevel
+	keySize int
+
+	in   chan []byte // PDUs to the server
+	out  chan []byte // PDUs from the server
+	done chan struct{}
+	once sync.Once
+	ctx  context.Context
+}
+
+func newBearer() *bearer {
+	return &bearer{
+		rxMTU: ble.DefaultMTU,
+		txMTU: ble.DefaultMTU,
+		level: ble.SecurityNone,
+		in:    make(chan []byte),
+		out:   make(chan []byte, 16),
+		done:  make(chan struct{}),
+		ctx:   context.Background(),
+	}
+}
+
+func (b *bearer) Read(p []byte) (int, error) {
+	pdu, ok := <-b.in
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(p, pdu), nil
+}
+
+func (b *bearer) Write(p []byte) (int, error) {
+	b.out <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func (b *bearer) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return nil
+}
+
+func (b *bearer) Context() context.Context         { return b.ctx }
+func (b *bearer) SetContext(ctx context.Context)   { b.ctx = ctx }
+func (b *bearer) LocalAddr() ble.Addr              { return nil }
+func (b *bearer) RemoteAddr() ble.Addr             { return nil }
+func (b *bearer) RxMTU() int                       { return b.rxMTU }
+func (b *bearer) SetRxMTU(mtu int)                 { b.rxMTU = mtu }
+func (b *bearer) TxMTU() int                       { return b.txMTU }
+func (b *bearer) SetTxMTU(mtu int)                 { b.txMTU = mtu }
+func (b *bearer) Disconnected() <-chan struct{}    { return b.done }
+func (b *bearer) DisconnectReason() error          { return nil }
+func (b *bearer) SecurityLevel() ble.SecurityLevel { return b.level }
+func (b *bearer) KeySize() int                     { return b.keySize }
+
+func (b *bearer) RaiseSecurity(ctx context.Context, level ble.SecurityLevel) error {
+	return ble.ErrNotImplemented
+}
+
+// mac is the MAC of the signatures of a signingBearer.
+var mac = pdu("5349474E45444D41")
+
+// signingBearer is a bearer supporting data signing. The signatures are the
+// SignCounter followed by mac, and are verified only once for each counter,
+// as if signed with an authenticated CSRK. [Vol 3, Part H, 2.4.5]
+type signingBearer struct {
+	*bearer
+	counter uint32 // The lowest SignCounter not used yet.
+}
+
+func (b *signingBearer) SignData(data []byte) ([12]byte, error) {
+	var sig [12]byte
+	binary.LittleEndian.PutUint32(sig[:], b.counter)
+	copy(sig[4:], mac)
+	b.counter++
+	return sig, nil
+}
+
+func (b *signingBearer) VerifyData(data []byte, sig [12]byte) (ble.SecurityLevel, error) {
+	counter := binary.LittleEndian.Uint32(sig[:])
+	switch {
+	case counter < b.counter:
+		return ble.SecurityNone, errors.New("SignCounter replayed")
+	case !bytes.Equal(sig[4:], mac):
+		return ble.SecurityNone, errors.New("invalid signature")
+	}
+	b.counter = counter + 1
+	return ble.SecurityAuthenticated, nil
+}
+
+// pdu returns the PDU of the hex string, in which spaces are ignored.
+func pdu(s string) []byte {
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// The attributes of the test database.
+//
+//	0x0001        Primary Service 0x1800
+//	0x0002-0x0003 Characteristic 0x2A00, static value "ble"
+//	0x0004        Primary Service 0x180F
+//	0x0005-0x0006 Characteristic 0x2A19, read, notify and indicate
+//	0x0007        Client Characteristic Configuration
+//	0x0008        Primary Service 12345678-1234-5678-1234-56789ABCDEF0
+//	0x0009-0x000A Characteristic 12345678-1234-5678-1234-56789ABCDEF1, read and write
+//	0x000B-0x000C Characteristic 0xFF01, static value, encryption required for reading
+//	0x000D-0x000E Characteristic 0xFF02, write only, authentication required for writing
+//	0x000F-0x0010 Characteristic 0xFF03, static value of 40 octets
+//	0x0011        Characteristic User Description "long"
+func newTestDB() *DB {
+	gap := ble.NewService(ble.UUID16(0x1800))
+	gap.NewCharacteristic(ble.UUID16(0x2A00)).SetValue([]byte("ble"))
+
+	bas := ble.NewService(ble.UUID16(0x180F))
+	bl := bas.NewCharacteristic(ble.UUID16(0x2A19))
+	bl.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		rsp.Write([]byte{100})
+	}))
+	bl.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {
+		n.Write([]byte{100})
+	}))
+	bl.HandleIndicate(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {
+		// The second indication isn't sent until the first is confirmed.
+		if _, err := n.Write([]byte{100}); err == nil {
+			n.Write([]byte{101})
+		}
+	}))
+
+	custom := ble.NewService(ble.MustParse("12345678-1234-5678-1234-56789ABCDEF0"))
+	var value []byte
+	rw := custom.NewCharacteristic(ble.MustParse("12345678-1234-5678-1234-56789ABCDEF1"))
+	rw.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		if req.Offset() > len(value) {
+			rsp.SetStatus(ble.ErrInvalidOffset)
+			return
+		}
+		v := value[req.Offset():]
+		if len(v) > rsp.Cap() {
+			v = v[:rsp.Cap()]
+		}
+		rsp.Write(v)
+	}))
+	rw.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		value = append(value[:req.Offset()], req.Data()...)
+	}))
+	enc := custom.NewCharacteristic(ble.UUID16(0xFF01))
+	enc.SetValue([]byte{0x01})
+	enc.Permission = ble.PermReadEncrypted
+	wo := custom.NewCharacteristic(ble.UUID16(0xFF02))
+	wo.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {}))
+	wo.Permission = ble.PermWriteAuthenticated
+	long := custom.NewCharacteristic(ble.UUID16(0xFF03))
+	v := make([]byte, 40)
+	for i := range v {
+		v[i] = byte(i)
+	}
+	long.SetValue(v)
+	long.NewDescriptor(ble.UUID16(0x2901)).SetValue([]byte("long"))
+
+	return NewDB([]*ble.Service{gap, bas, custom}, 1)
+}
+
+// storeValue makes the value of the characteristic readable and writable.
+func storeValue(c *ble.Characteristic) {
+	var value []byte
+	c.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		rsp.Write(value)
+	}))
+	c.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		value = append([]byte(nil), req.Data()...)
+	}))
+}
+
+// The attributes of the test database of the security requirements.
+//
+//	0x0001        Primary Service 0xFFF0
+//	0x0002-0x0003 Characteristic 0xFF04, read and write, authorization required and granted
+//	0x0004-0x0005 Characteristic 0xFF05, read and write, authorization required and refused
+//	0x0006-0x0007 Characteristic 0xFF06, static value, encryption key of 16 octets required
+//	0x0008-0x0009 Characteristic 0xFF07, read and write, authentication required for writing
+func newSecurityDB() *DB {
+	svc := ble.NewService(ble.UUID16(0xFFF0))
+	granted := svc.NewCharacteristic(ble.UUID16(0xFF04))
+	storeValue(granted)
+	granted.Permission = ble.PermReadAuthorized | ble.PermWriteAuthorized
+	granted.AuthorizeHandler = ble.AuthorizeHandlerFunc(func(req ble.Request, write bool) bool { return true })
+	refused := svc.NewCharacteristic(ble.UUID16(0xFF05))
+	storeValue(refused)
+	refused.Permission = ble.PermReadAuthorized | ble.PermWriteAuthorized
+	refused.AuthorizeHandler = ble.AuthorizeHandlerFunc(func(req ble.Request, write bool) bool { return false })
+	keySize := svc.NewCharacteristic(ble.UUID16(0xFF06))
+	keySize.SetValue([]byte{0x01})
+	keySize.MinKeySize = 16
+	authen := svc.NewCharacteristic(ble.UUID16(0xFF07))
+	storeValue(authen)
+	authen.Permission = ble.PermWriteAuthenticated
+
+	return NewDB([]*ble.Service{svc}, 1)
+}
+
+// validatedValue is a readable and writable value, which refuses 0xFF with an
+// application error.
+type validatedValue struct{ value []byte }
+
+func (v *validatedValue) ServeRead(req ble.Request, rsp ble.ResponseWriter) {
+	rsp.Write(v.value)
+}
+
+func (v *validatedValue) ValidateWrite(req ble.Request) ble.ATTError {
+	if bytes.Equal(req.Data(), []byte{0xFF}) {
+		return ble.ATTError(0x80)
+	}
+	return ble.ErrSuccess
+}
+
+func (v *validatedValue) ServeWrite(req ble.Request, rsp ble.ResponseWriter) {
+	if e := v.ValidateWrite(req); e != ble.ErrSuccess {
+		rsp.SetStatus(e)
+		return
+	}
+	v.value = append([]byte(nil), req.Data()...)
+}
+
+// The attributes of the test database of the write handlers.
+//
+//	0x0001        Primary Service 0xFFF1
+//	0x0002-0x0003 Characteristic 0xFF10, read and write
+//	0x0004-0x0005 Characteristic 0xFF11, read and write, the handler fails writes of 0xFF
+//	0x0006-0x0007 Characteristic 0xFF12, read and write, the validator refuses writes of 0xFF
+func newWriteDB() *DB {
+	svc := ble.NewService(ble.UUID16(0xFFF1))
+	storeValue(svc.NewCharacteristic(ble.UUID16(0xFF10)))
+	failing := svc.NewCharacteristic(ble.UUID16(0xFF11))
+	var value []byte
+	failing.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		rsp.Write(value)
+	}))
+	failing.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		if bytes.Equal(req.Data(), []byte{0xFF}) {
+			rsp.SetStatus(ble.ATTError(0x80))
+			return
+		}
+		value = append([]byte(nil), req.Data()...)
+	}))
+	validated := svc.NewCharacteristic(ble.UUID16(0xFF12))
+	v := &validatedValue{}
+	validated.HandleRead(v)
+	validated.HandleWrite(v)
+
+	return NewDB([]*ble.Service{svc}, 1)
+}
+
+// step is a PDU sent to the server, and the PDUs expected in response, in any
+// order. Commands expect none.
+type step struct {
+	req string
+	rsp []string
+}
+
+func TestServerConformance(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		db      func() *DB // newTestDB, if nil.
+		sign    bool       // The bearer supports data signing.
+		level   ble.SecurityLevel
+		keySize int
+		rxMTU   int
+		limit   int
+		steps   []step
+	}{
+		// Exchange MTU [Vol 3, Part F, 3.4.2]
+		{name: "exchange mtu", rxMTU: 100, steps: []step{
+			{"02 00 02", []string{"03 64 00"}},
+			// The ATT_MTU is the smaller Rx MTU, 100.
+			{"0A 10 00", []string{"0B 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F 10 11 12 13 14 15 16 17 18 19 1A 1B 1C 1D 1E 1F 20 21 22 23 24 25 26 27"}},
+		}},
+		{name: "exchange mtu of default size", steps: []step{
+			{"02 17 00", []string{"03 17 00"}},
+		}},
+		{name: "exchange mtu smaller than default", steps: []step{
+			{"02 16 00", []string{"01 02 00 00 04"}},
+		}},
+		{name: "exchange mtu invalid pdu", steps: []step{
+			{"02 17", []string{"01 02 00 00 04"}},
+		}},
+
+		// Find Information [Vol 3, Part F, 3.4.3.1]
+		{name: "find information", steps: []step{
+			{"04 01 00 FF FF", []string{"05 01 0100 0028 0200 0328 0300 002A 0400 0028 0500 0328"}},
+		}},
+		{name: "find information of 128-bit uuids", steps: []step{
+			{"04 0A 00 0A 00", []string{"05 02 0A00 F1DEBC9A78563412 7856341278563412"}},
+		}},
+		{name: "find information stops at another format", steps: []step{
+			{"04 07 00 0A 00", []string{"05 01 0700 0229 0800 0028 0900 0328"}},
+		}},
+		{name: "find information not found", steps: []step{
+			{"04 12 00 FF FF", []string{"01 04 12 00 0A"}},
+		}},
+		{name: "find information zero handle", steps: []step{
+			{"04 00 00 FF FF", []string{"01 04 00 00 01"}},
+		}},
+		{name: "find information reversed range", steps: []step{
+			{"04 05 00 04 00", []string{"01 04 05 00 01"}},
+		}},
+		{name: "find information invalid pdu", steps: []step{
+			{"04 01 00 FF", []string{"01 04 00 00 04"}},
+		}},
+
+		// Find By Type Value [Vol 3, Part F, 3.4.3.3]
+		{name: "find by type value", steps: []step{
+			{"06 01 00 FF FF 00 28 0F 18", []string{"07 0400 0700"}},
+		}},
+		{name: "find by type value of the last service", steps: []step{
+			{"06 01 00 FF FF 00 28 F0DEBC9A78563412 7856341278563412", []string{"07 0800 FFFF"}},
+		}},
+		{name: "find by type value of non-grouping attribute", steps: []step{
+			{"06 01 00 FF FF 00 2A 62 6C 65", []string{"07 0300 0300"}},
+		}},
+		{name: "find by type value not found", steps: []step{
+			{"06 01 00 FF FF 00 28 01 18", []string{"01 06 01 00 0A"}},
+		}},
+		{name: "find by type value out of range", steps: []step{
+			{"06 05 00 FF FF 00 28 0F 18", []string{"01 06 05 00 0A"}},
+		}},
+		{name: "find by type value zero handle", steps: []step{
+			{"06 00 00 FF FF 00 28 0F 18", []string{"01 06 00 00 01"}},
+		}},
+		{name: "find by type value invalid pdu", steps: []step{
+			{"06 01 00 FF FF 00", []string{"01 06 00 00 04"}},
+		}},
+
+		// Read By Type [Vol 3, Part F, 3.4.4.1]
+		{name: "read by type characteristics", steps: []step{
+			{"08 01 00 FF FF 03 28", []string{"09 07 0200 02 0300 002A 0500 32 0600 192A"}},
+		}},
+		{name: "read by type 128-bit characteristic", steps: []step{
+			{"08 09 00 09 00 03 28", []string{"09 15 0900 0E 0A00 F1DEBC9A78563412 7856341278563412"}},
+		}},
+		{name: "read by type value", steps: []step{
+			{"08 01 00 FF FF 19 2A", []string{"09 03 0600 64"}},
+		}},
+		{name: "read by type 128-bit type", steps: []step{
+			{"08 01 00 FF FF F1DEBC9A78563412 7856341278563412", []string{"09 02 0A00"}},
+		}},
+		{name: "read by type not found", steps: []step{
+			{"08 01 00 FF FF 34 12", []string{"01 08 01 00 0A"}},
+		}},
+		{name: "read by type not permitted", steps: []step{
+			{"08 01 00 FF FF 02 FF", []string{"01 08 0E 00 02"}},
+		}},
+		{name: "read by type insufficient encryption", steps: []step{
+			{"08 01 00 FF FF 01 FF", []string{"01 08 0C 00 0F"}},
+		}},
+		{name: "read by type encrypted", level: ble.SecurityUnauthenticated, keySize: 16, steps: []step{
+			{"08 01 00 FF FF 01 FF", []string{"09 03 0C00 01"}},
+		}},
+		{name: "read by type zero handle", steps: []step{
+			{"08 00 00 FF FF 03 28", []string{"01 08 00 00 01"}},
+		}},
+		{name: "read by type reversed range", steps: []step{
+			{"08 05 00 01 00 03 28", []string{"01 08 05 00 01"}},
+		}},
+		{name: "read by type invalid pdu", steps: []step{
+			{"08 01 00 FF FF 03", []string{"01 08 00 00 04"}},
+		}},
+
+		// Read [Vol 3, Part F, 3.4.4.3]
+		{name: "read static value", steps: []step{
+			{"0A 03 00", []string{"0B 62 6C 65"}},
+		}},
+		{name: "read dynamic value", steps: []step{
+			{"0A 06 00", []string{"0B 64"}},
+		}},
+		{name: "read long value truncated", steps: []step{
+			{"0A 10 00", []string{"0B 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F 10 11 12 13 14 15"}},
+		}},
+		{name: "read declaration", steps: []step{
+			{"0A 02 00", []string{"0B 02 0300 002A"}},
+		}},
+		{name: "read descriptor", steps: []step{
+			{"0A 11 00", []string{"0B 6C 6F 6E 67"}},
+		}},
+		{name: "read invalid handle", steps: []step{
+			{"0A 00 00", []string{"01 0A 00 00 01"}},
+			{"0A 12 00", []string{"01 0A 12 00 01"}},
+		}},
+		{name: "read not permitted", steps: []step{
+			{"0A 0E 00", []string{"01 0A 0E 00 02"}},
+		}},
+		{name: "read insufficient encryption", steps: []step{
+			{"0A 0C 00", []string{"01 0A 0C 00 0F"}},
+		}},
+		{name: "read encrypted", level: ble.SecurityUnauthenticated, keySize: 16, steps: []step{
+			{"0A 0C 00", []string{"0B 01"}},
+		}},
+		{name: "read invalid pdu", steps: []step{
+			{"0A 03", []string{"01 0A 00 00 04"}},
+		}},
+
+		// Read Blob [Vol 3, Part F, 3.4.4.5]
+		{name: "read blob static value", steps: []step{
+			{"0C 10 00 16 00", []string{"0D 16 17 18 19 1A 1B 1C 1D 1E 1F 20 21 22 23 24 25 26 27"}},
+		}},
+		{name: "read blob static value at the end", steps: []step{
+			{"0C 10 00 28 00", []string{"0D"}},
+		}},
+		{name: "read blob static value invalid offset", steps: []step{
+			{"0C 10 00 29 00", []string{"01 0C 10 00 07"}},
+		}},
+		{name: "read blob dynamic value", steps: []step{
+			{"12 0A 00 00 01 02 03 04", []string{"13"}},
+			{"0C 0A 00 02 00", []string{"0D 02 03 04"}},
+			{"0C 0A 00 05 00", []string{"0D"}},
+			{"0C 0A 00 06 00", []string{"01 0C 0A 00 07"}},
+		}},
+		{name: "read blob invalid handle", steps: []step{
+			{"0C 12 00 00 00", []string{"01 0C 12 00 01"}},
+		}},
+		{name: "read blob not permitted", steps: []step{
+			{"0C 0E 00 00 00", []string{"01 0C 0E 00 02"}},
+		}},
+		{name: "read blob insufficient encryption", steps: []step{
+			{"0C 0C 00 00 00", []string{"01 0C 0C 00 0F"}},
+		}},
+		{name: "read blob invalid pdu", steps: []step{
+			{"0C 10 00 00", []string{"01 0C 00 00 04"}},
+		}},
+
+		// Read Multiple [Vol 3, Part F, 3.4.4.7]
+		{name: "read multiple", steps: []step{
+			{"0E 06 00 03 00", []string{"0F 64 62 6C 65"}},
+		}},
+		{name: "read multiple truncated", steps: []step{
+			{"0E 03 00 10 00", []string{"0F 62 6C 65 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F 10 11 12"}},
+		}},
+		{name: "read multiple invalid handle", steps: []step{
+			{"0E 03 00 12 00", []string{"01 0E 12 00 01"}},
+		}},
+		{name: "read multiple not permitted", steps: []step{
+			{"0E 03 00 0E 00", []string{"01 0E 0E 00 02"}},
+		}},
+		{name: "read multiple insufficient encryption", steps: []step{
+			{"0E 0C 00 03 00", []string{"01 0E 0C 00 0F"}},
+		}},
+		{name: "read multiple single handle", steps: []step{
+			{"0E 03 00", []string{"01 0E 00 00 04"}},
+		}},
+
+		// Read Multiple Variable Length [Vol 3, Part F, 3.4.4.11]
+		{name: "read multiple variable", steps: []step{
+			{"20 06 00 03 00", []string{"21 0100 64 0300 62 6C 65"}},
+		}},
+		{name: "read multiple variable truncated", steps: []step{
+			{"20 03 00 10 00", []string{"21 0300 62 6C 65 2800 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E"}},
+		}},
+		{name: "read multiple variable first value truncated", steps: []step{
+			{"20 10 00 03 00", []string{"21 2800 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F 10 11 12 13"}},
+		}},
+		{name: "read multiple variable invalid handle", steps: []step{
+			{"20 03 00 12 00", []string{"01 20 12 00 01"}},
+		}},
+		{name: "read multiple variable invalid pdu", steps: []step{
+			{"20 03 00 10", []string{"01 20 00 00 04"}},
+		}},
+
+		// Read By Group Type [Vol 3, Part F, 3.4.4.9]
+		{name: "read by group type", steps: []step{
+			{"10 01 00 FF FF 00 28", []string{"11 06 0100 0300 0018 0400 0700 0F18"}},
+		}},
+		{name: "read by group type 128-bit service", steps: []step{
+			{"10 08 00 FF FF 00 28", []string{"11 14 0800 FFFF F0DEBC9A78563412 7856341278563412"}},
+		}},
+		{name: "read by group type in range", steps: []step{
+			{"10 02 00 05 00 00 28", []string{"11 06 0400 0700 0F18"}},
+		}},
+		{name: "read by group type not found", steps: []step{
+			{"10 01 00 FF FF 01 28", []string{"01 10 01 00 0A"}},
+		}},
+		{name: "read by group type unsupported group type", steps: []step{
+			{"10 01 00 FF FF 03 28", []string{"01 10 01 00 10"}},
+		}},
+		{name: "read by group type zero handle", steps: []step{
+			{"10 00 00 FF FF 00 28", []string{"01 10 00 00 01"}},
+		}},
+		{name: "read by group type reversed range", steps: []step{
+			{"10 05 00 04 00 00 28", []string{"01 10 05 00 01"}},
+		}},
+		{name: "read by group type invalid pdu", steps: []step{
+			{"10 01 00 FF FF 00", []string{"01 10 00 00 04"}},
+		}},
+
+		// Write [Vol 3, Part F, 3.4.5.1]
+		{name: "write", steps: []step{
+			{"12 0A 00 01 02", []string{"13"}},
+			{"0A 0A 00", []string{"0B 01 02"}},
+		}},
+		{name: "write empty value", steps: []step{
+			{"12 0A 00 01 02", []string{"13"}},
+			{"12 0A 00", []string{"13"}},
+			{"0A 0A 00", []string{"0B"}},
+		}},
+		{name: "write invalid handle", steps: []step{
+			{"12 12 00 01", []string{"01 12 12 00 01"}},
+		}},
+		{name: "write not permitted", steps: []step{
+			{"12 03 00 01", []string{"01 12 03 00 03"}},
+		}},
+		{name: "write insufficient authentication", level: ble.SecurityUnauthenticated, keySize: 16, steps: []step{
+			{"12 0E 00 01", []string{"01 12 0E 00 05"}},
+		}},
+		{name: "write authenticated", level: ble.SecurityAuthenticated, keySize: 16, steps: []step{
+			{"12 0E 00 01", []string{"13"}},
+		}},
+		{name: "write invalid pdu", steps: []step{
+			{"12 0A", []string{"01 12 00 00 04"}},
+		}},
+
+		// Write Command [Vol 3, Part F, 3.4.5.3]
+		{name: "write command", steps: []step{
+			{"52 0A 00 01 02", nil},
+			{"0A 0A 00", []string{"0B 01 02"}},
+		}},
+		{name: "write command empty value", steps: []step{
+			{"52 0A 00 01 02", nil},
+			{"52 0A 00", nil},
+			{"0A 0A 00", []string{"0B"}},
+		}},
+		{name: "write command not permitted", steps: []step{
+			{"52 03 00 01", nil},
+			{"0A 03 00", []string{"0B 62 6C 65"}},
+		}},
+		{name: "write command invalid handle", steps: []step{
+			{"52 12 00 01", nil},
+		}},
+
+		// Signed Write Command [Vol 3, Part F, 3.4.5.4]
+		{name: "signed write command without signing", steps: []step{
+			{"D2 0A 00 01 " + strings.Repeat("00", 12), nil},
+			{"0A 0A 00", []string{"0B"}},
+		}},
+		{name: "signed write command invalid pdu", steps: []step{
+			{"D2 0A 00 " + strings.Repeat("00", 11), nil},
+		}},
+		{name: "signed write command", db: newSecurityDB, sign: true, steps: []step{
+			// The signature authenticates the command without encryption.
+			{"D2 09 00 01 00000000 5349474E45444D41", nil},
+			{"0A 09 00", []string{"0B 01"}},
+			{"D2 09 00 02 05000000 5349474E45444D41", nil},
+			{"0A 09 00", []string{"0B 02"}},
+			{"12 09 00 03", []string{"01 12 09 00 05"}},
+		}},
+		{name: "signed write command replayed", db: newSecurityDB, sign: true, steps: []step{
+			{"D2 09 00 01 05000000 5349474E45444D41", nil},
+			{"D2 09 00 02 05000000 5349474E45444D41", nil},
+			{"D2 09 00 03 04000000 5349474E45444D41", nil},
+			{"0A 09 00", []string{"0B 01"}},
+		}},
+		{name: "signed write command invalid signature", db: newSecurityDB, sign: true, steps: []step{
+			{"D2 09 00 01 00000000 " + strings.Repeat("00", 8), nil},
+			{"0A 09 00", []string{"0B"}},
+		}},
+		{name: "write command insufficient authentication", db: newSecurityDB, sign: true, steps: []step{
+			{"52 09 00 01", nil},
+			{"0A 09 00", []string{"0B"}},
+		}},
+
+		// Authorization and encryption key size [Vol 3, Part F, 3.2.5]
+		{name: "authorization granted", db: newSecurityDB, steps: []step{
+			{"12 03 00 01", []string{"13"}},
+			{"0A 03 00", []string{"0B 01"}},
+		}},
+		{name: "authorization refused", db: newSecurityDB, steps: []step{
+			{"12 05 00 01", []string{"01 12 05 00 08"}},
+			{"0A 05 00", []string{"01 0A 05 00 08"}},
+			{"0C 05 00 00 00", []string{"01 0C 05 00 08"}},
+		}},
+		{name: "encryption key size unencrypted", db: newSecurityDB, steps: []step{
+			{"0A 07 00", []string{"01 0A 07 00 0F"}},
+		}},
+		{name: "encryption key size insufficient", db: newSecurityDB, level: ble.SecurityUnauthenticated, keySize: 7, steps: []step{
+			{"0A 07 00", []string{"01 0A 07 00 0C"}},
+			{"08 01 00 FF FF 06 FF", []string{"01 08 07 00 0C"}},
+		}},
+		{name: "encryption key size sufficient", db: newSecurityDB, level: ble.SecurityUnauthenticated, keySize: 16, steps: []step{
+			{"0A 07 00", []string{"0B 01"}},
+		}},
+
+		// Prepare Write and Execute Write [Vol 3, Part F, 3.4.6]
+		{name: "long write", steps: []step{
+			{"16 0A 00 00 00 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F 10 11", []string{"17 0A 00 00 00 00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F 10 11"}},
+			{"16 0A 00 12 00 12 13 14", []string{"17 0A 00 12 00 12 13 14"}},
+			// The value isn't written until the queue is executed.
+			{"0A 0A 00", []string{"0B"}},
+			{"18 01", []string{"19"}},
+			{"0C 0A 00 10 00", []string{"0D 10 11 12 13 14"}},
+		}},
+		{name: "reliable write", level: ble.SecurityAuthenticated, keySize: 16, steps: []step{
+			{"16 0A 00 00 00 01", []string{"17 0A 00 00 00 01"}},
+			{"16 0E 00 00 00 02", []string{"17 0E 00 00 00 02"}},
+			{"18 01", []string{"19"}},
+			{"0A 0A 00", []string{"0B 01"}},
+		}},
+		{name: "prepare write overwrites queued value", steps: []step{
+			{"16 0A 00 00 00 01 02 03", []string{"17 0A 00 00 00 01 02 03"}},
+			{"16 0A 00 01 00 09", []string{"17 0A 00 01 00 09"}},
+			{"18 01", []string{"19"}},
+			{"0A 0A 00", []string{"0B 01 09 03"}},
+		}},
+		{name: "execute write cancelled", steps: []step{
+			{"12 0A 00 01", []string{"13"}},
+			{"16 0A 00 00 00 02", []string{"17 0A 00 00 00 02"}},
+			{"18 00", []string{"19"}},
+			{"0A 0A 00", []string{"0B 01"}},
+			// The queue has been cleared.
+			{"18 01", []string{"19"}},
+			{"0A 0A 00", []string{"0B 01"}},
+		}},
+		{name: "execute write invalid offset", steps: []step{
+			{"12 0A 00 01", []string{"13"}},
+			{"16 0A 00 00 00 02", []string{"17 0A 00 00 00 02"}},
+			{"16 0A 00 05 00 03", []string{"17 0A 00 05 00 03"}},
+			{"18 01", []string{"01 18 0A 00 07"}},
+			// None of the queued values is written.
+			{"0A 0A 00", []string{"0B 01"}},
+		}},
+		{name: "execute write too long", rxMTU: ble.MaxMTU, steps: []step{
+			{"02 05 02", []string{"03 03 02"}},
+			{"16 0A 00 FF 01 " + strings.Repeat("00", 2), []string{"17 0A 00 FF 01 " + strings.Repeat("00", 2)}},
+			{"18 01", []string{"01 18 0A 00 0D"}},
+		}},
+		{name: "execute write second handler fails", db: newWriteDB, steps: []step{
+			{"16 03 00 00 00 01", []string{"17 03 00 00 00 01"}},
+			{"16 05 00 00 00 FF", []string{"17 05 00 00 00 FF"}},
+			{"18 01", []string{"01 18 05 00 80"}},
+			// The handlers run in order, so the first value has been written.
+			{"0A 03 00", []string{"0B 01"}},
+			{"0A 05 00", []string{"0B"}},
+			// The queue has been cleared.
+			{"18 01", []string{"19"}},
+			{"0A 05 00", []string{"0B"}},
+		}},
+		{name: "execute write second value refused", db: newWriteDB, steps: []step{
+			{"16 03 00 00 00 01", []string{"17 03 00 00 00 01"}},
+			{"16 07 00 00 00 FF", []string{"17 07 00 00 00 FF"}},
+			{"18 01", []string{"01 18 07 00 80"}},
+			// The values are validated before any is written.
+			{"0A 03 00", []string{"0B"}},
+			{"0A 07 00", []string{"0B"}},
+		}},
+		{name: "execute write validated", db: newWriteDB, steps: []step{
+			{"16 03 00 00 00 01", []string{"17 03 00 00 00 01"}},
+			{"16 07 00 00 00 02", []string{"17 07 00 00 00 02"}},
+			{"18 01", []string{"19"}},
+			{"0A 03 00", []string{"0B 01"}},
+			{"0A 07 00", []string{"0B 02"}},
+		}},
+		{name: "prepare write queue full", limit: 2, steps: []step{
+			{"16 0A 00 00 00 01", []string{"17 0A 00 00 00 01"}},
+			{"16 0A 00 01 00 02", []string{"17 0A 00 01 00 02"}},
+			{"16 0A 00 02 00 03", []string{"01 16 0A 00 09"}},
+			{"18 01", []string{"19"}},
+			{"0A 0A 00", []string{"0B 01 02"}},
+		}},
+		{name: "prepare write invalid handle", steps: []step{
+			{"16 12 00 00 00 01", []string{"01 16 12 00 01"}},
+		}},
+		{name: "prepare write not permitted", steps: []step{
+			{"16 03 00 00 00 01", []string{"01 16 03 00 03"}},
+		}},
+		{name: "prepare write insufficient authentication", steps: []step{
+			{"16 0E 00 00 00 01", []string{"01 16 0E 00 05"}},
+		}},
+		{name: "prepare write invalid pdu", steps: []step{
+			{"16 0A 00 00", []string{"01 16 00 00 04"}},
+		}},
+		{name: "execute write invalid flags", steps: []step{
+			{"18 02", []string{"01 18 00 00 04"}},
+		}},
+
+		// Handle Value Notification and Indication [Vol 3, Part F, 3.4.7]
+		{name: "notification", steps: []step{
+			{"12 07 00 01 00", []string{"13", "1B 0600 64"}},
+			{"0A 07 00", []string{"0B 01 00"}},
+		}},
+		{name: "indication", steps: []step{
+			{"12 07 00 02 00", []string{"13", "1D 0600 64"}},
+			{"1E", []string{"1D 0600 65"}},
+			{"1E", nil},
+			{"0A 07 00", []string{"0B 02 00"}},
+		}},
+
+		// Unsupported requests and commands [Vol 3, Part F, 3.3.1]
+		{name: "unsupported request", steps: []step{
+			{"1F 00", []string{"01 1F 00 00 06"}},
+		}},
+		{name: "unsupported command", steps: []step{
+			{"7F 00", nil},
+			{"0A 03 00", []string{"0B 62 6C 65"}},
+		}},
+	} {
+		b := newBearer()
+		b.level, b.keySize = tc.level, tc.keySize
+		if tc.level == 0 {
+			b.level = ble.SecurityNone
+		}
+		if tc.rxMTU != 0 {
+			b.rxMTU = tc.rxMTU
+		}
+		db := tc.db
+		if db == nil {
+			db = newTestDB
+		}
+		var conn ble.Conn = b
+		if tc.sign {
+			conn = &signingBearer{bearer: b}
+		}
+		s, err := NewServer(db(), conn)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if tc.limit != 0 {
+			s.SetPrepQueueLimit(tc.limit)
+		}
+		done := make(chan struct{})
+		go func() {
+			s.Loop()
+			close(done)
+		}()
+
+		for i, st := range tc.steps {
+			b.in <- pdu(st.req)
+			want := make(map[string]int)
+			for _, r := range st.rsp {
+				want[hex.EncodeToString(pdu(r))]++
+			}
+			for range st.rsp {
+				select {
+				case p := <-b.out:
+					k := hex.EncodeToString(p)
+					if want[k] == 0 {
+						t.Errorf("%s: step %d: got % X, want %s", tc.name, i, p, strings.Join(st.rsp, " or "))
+					}
+					want[k]--
+				case <-time.After(time.Second):
+					t.Errorf("%s: step %d: no response, want %s", tc.name, i, strings.Join(st.rsp, " and "))
+				}
+			}
+		}
+
+		close(b.in)
+		<-done
+		select {
+		case p := <-b.out:
+			t.Errorf("%s: unexpected PDU % X", tc.name, p)
+		default:
+		}
+	}
+}
@@ -2,6 +2,8 @@ package linux
 
 import (
 	"log"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
@@ -12,6 +14,9 @@ import (
 	"github.com/currantlabs/ble/linux/hci"
 )
 
+// stopTimeout is the time Stop waits for the connections to be disconnected.
+const stopTimeout = 5 * time.Second
+
 // NewDevice returns the default HCI device.
 func NewDevice() (*Device, error) {
 	dev, err := hci.NewHCI()
@@ -33,7 +38,10 @@ func NewDevice() (*Device, error) {
 		return nil, errors.Wrapf(err, "maximum ATT_MTU is %d", ble.MaxMTU)
 	}
 
+	d := &Device{HCI: dev, Server: s}
+	d.wg.Add(1)
 	go func() {
+		defer d.wg.Done()
 		for {
 			l2c, err := dev.Accept()
 			if err != nil {
@@ -53,16 +61,23 @@ func NewDevice() (*Device, error) {
 				continue
 
 			}
-			go as.Loop()
+			d.wg.Add(1)
+			go func() {
+				defer d.wg.Done()
+				as.Loop()
+			}()
 		}
 	}()
-	return &Device{HCI: dev, Server: s}, nil
+	return d, nil
 }
 
 // Device ...
 type Device struct {
 	HCI    *hci.HCI
 	Server *gatt.Server
+
+	// wg tracks the accepting loop, and the ATT servers of the connections.
+	wg sync.WaitGroup
 }
 
 // AddService adds a service to database.
@@ -81,9 +96,20 @@ func (d *Device) SetServices(svcs []*ble.Service) error {
 	return d.Server.SetServices(svcs)
 }
 
-// Stop stops gatt server.
+// Stop stops gatt server. It shuts down the device gracefully, giving up on
+// the connections not disconnected in stopTimeout.
 func (d *Device) Stop() error {
-	return d.HCI.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	return d.Shutdown(ctx)
+}
+
+// Shutdown gracefully shuts down the HCI device, and returns after the ATT
+// servers of the connections have exited. See hci.HCI.Shutdown for details.
+func (d *Device) Shutdown(ctx context.Context) error {
+	err := d.HCI.Shutdown(ctx)
+	d.wg.Wait()
+	return err
 }
 
 // AdvertiseNameAndServices advertises device name, and specified service UUIDs.
@@ -143,7 +169,11 @@ func (d *Device) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler) erro
 	if err := d.HCI.SetAdvHandler(h); err != nil {
 		return err
 	}
-	if err := d.HCI.Scan(allowDup); err != nil {
+	if err := d.HCI.ScanContext(ctx, allowDup); err != nil {
+		if err == ctx.Err() {
+			// The controller might have started scanning anyway.
+			d.HCI.StopScanning()
+		}
 		return err
 	}
 	<-ctx.Done()
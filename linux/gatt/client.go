@@ -208,25 +208,88 @@ func (p *Client) DiscoverDescriptors(filter []ble.UUID, c *ble.Characteristic) (
 func (p *Client) ReadCharacteristic(c *ble.Characteristic) ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
-	return p.ac.Read(c.ValueHandle)
+	var v []byte
+	err := p.secure(func() (err error) {
+		v, err = p.ac.Read(c.ValueHandle)
+		return err
+	})
+	return v, err
+}
+
+// ReadMultipleCharacteristics reads the values of two or more characteristics,
+// which are concatenated. All but the last value have to be of fixed lengths
+// known to the caller. [Vol 3, Part G, 4.8.4]
+func (p *Client) ReadMultipleCharacteristics(cs []*ble.Characteristic) ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	var v []byte
+	err := p.secure(func() (err error) {
+		v, err = p.ac.ReadMultiple(valueHandles(cs))
+		return err
+	})
+	return v, err
+}
+
+// ReadMultipleVariableCharacteristics reads the values of two or more
+// characteristics of variable lengths in a single request. The values which
+// don't fit in the response are read separately. [Vol 3, Part G, 4.8.5]
+func (p *Client) ReadMultipleVariableCharacteristics(cs []*ble.Characteristic) ([][]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	var vs [][]byte
+	err := p.secure(func() (err error) {
+		vs, err = p.ac.ReadMultipleVariable(valueHandles(cs))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The values which don't fit in the response are omitted, and may be
+	// longer than the MTU too.
+	for _, c := range cs[len(vs):] {
+		v, err := p.readLong(c.ValueHandle)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+func valueHandles(cs []*ble.Characteristic) []uint16 {
+	hs := make([]uint16, len(cs))
+	for i, c := range cs {
+		hs[i] = c.ValueHandle
+	}
+	return hs
 }
 
 // ReadLongCharacteristic reads a characteristic value which is longer than the MTU. [Vol 3, Part G, 4.8.3]
 func (p *Client) ReadLongCharacteristic(c *ble.Characteristic) ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
+	return p.readLong(c.ValueHandle)
+}
 
+// readLong reads the value of the handle, with Read Blob Requests for the parts
+// beyond the first response. [Vol 3, Part G, 4.8.3]
+func (p *Client) readLong(h uint16) ([]byte, error) {
 	// The maximum length of an attribute value shall be 512 octects [Vol 3, 3.2.9]
 	buffer := make([]byte, 0, 512)
 
-	read, err := p.ac.Read(c.ValueHandle)
+	var read []byte
+	err := p.secure(func() (err error) {
+		read, err = p.ac.Read(h)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	buffer = append(buffer, read...)
 
 	for len(read) >= p.conn.TxMTU()-1 {
-		if read, err = p.ac.ReadBlob(c.ValueHandle, uint16(len(buffer))); err != nil {
+		if read, err = p.ac.ReadBlob(h, uint16(len(buffer))); err != nil {
 			return nil, err
 		}
 		buffer = append(buffer, read...)
@@ -239,23 +302,36 @@ func (p *Client) WriteCharacteristic(c *ble.Characteristic, v []byte, noRsp bool
 	p.Lock()
 	defer p.Unlock()
 	if noRsp {
+		// The Signed Write Command authenticates the value on an unencrypted
+		// link. [Vol 3, Part G, 4.9.2]
+		if c.Property&ble.CharSignedWrite != 0 && p.conn.SecurityLevel() == ble.SecurityNone {
+			err := p.ac.SignedWrite(c.ValueHandle, v)
+			if err == nil || c.Property&ble.CharWriteNR == 0 {
+				return err
+			}
+		}
 		return p.ac.WriteCommand(c.ValueHandle, v)
 	}
-	return p.ac.Write(c.ValueHandle, v)
+	return p.secure(func() error { return p.ac.Write(c.ValueHandle, v) })
 }
 
 // ReadDescriptor reads a characteristic descriptor from a server. [Vol 3, Part G, 4.12.1]
 func (p *Client) ReadDescriptor(d *ble.Descriptor) ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
-	return p.ac.Read(d.Handle)
+	var v []byte
+	err := p.secure(func() (err error) {
+		v, err = p.ac.Read(d.Handle)
+		return err
+	})
+	return v, err
 }
 
 // WriteDescriptor writes a characteristic descriptor to a server. [Vol 3, Part G, 4.12.3]
 func (p *Client) WriteDescriptor(d *ble.Descriptor, v []byte) error {
 	p.Lock()
 	defer p.Unlock()
-	return p.ac.Write(d.Handle, v)
+	return p.secure(func() error { return p.ac.Write(d.Handle, v) })
 }
 
 // ReadRSSI retrieves the current RSSI value of remote peripheral. [Vol 2, Part E, 7.5.4]
@@ -326,7 +402,7 @@ func (p *Client) setHandlers(cccdh, vh, flag uint16, h ble.NotificationHandler)
 	} else {
 		s.iHandler = h
 	}
-	return p.ac.Write(s.cccdh, v)
+	return p.secure(func() error { return p.ac.Write(s.cccdh, v) })
 }
 
 // ClearSubscriptions clears all subscriptions to notifications and indications.
@@ -357,6 +433,57 @@ func (p *Client) Disconnected() <-chan struct{} {
 	return p.conn.Disconnected()
 }
 
+// DisconnectReason returns the reason the client was disconnected for, or nil
+// if it's still connected.
+func (p *Client) DisconnectReason() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.conn.DisconnectReason()
+}
+
+// SecurityLevel returns the security level of the link. [Vol 3, Part C, 10.2.1]
+func (p *Client) SecurityLevel() ble.SecurityLevel {
+	return p.conn.SecurityLevel()
+}
+
+// KeySize returns the size in octets of the key the link is encrypted with,
+// or 0 if it isn't encrypted.
+func (p *Client) KeySize() int {
+	return p.conn.KeySize()
+}
+
+// RaiseSecurity raises the security level of the link to at least level, by
+// encrypting it with the keys of a previous bonding, or pairing.
+func (p *Client) RaiseSecurity(level ble.SecurityLevel) error {
+	p.Lock()
+	defer p.Unlock()
+	return p.conn.RaiseSecurity(p.conn.Context(), level)
+}
+
+// secure sends the request, and if the server rejects it for the security
+// level of the link, raises the level and sends the request again.
+// [Vol 3, Part G, 8.1]
+func (p *Client) secure(req func() error) error {
+	err := req()
+	var level ble.SecurityLevel
+	switch err {
+	case ble.ErrInsuffEnc:
+		level = ble.SecurityUnauthenticated
+	case ble.ErrAuthentication:
+		level = ble.SecurityAuthenticated
+	default:
+		return err
+	}
+	if p.conn.SecurityLevel() >= level {
+		return err
+	}
+	if serr := p.conn.RaiseSecurity(p.conn.Context(), level); serr != nil {
+		log.Printf("can't raise security level to %s: %s", level, serr)
+		return err
+	}
+	return req()
+}
+
 // HandleNotification ...
 func (p *Client) HandleNotification(req []byte) {
 	p.Lock()
@@ -22,16 +22,38 @@ const (
 	evtTypScanRsp       = 0x04 // Scan Response (SCAN_RSP).
 )
 
+// advQueueLen is the number of advertisements queued for the handler.
+const advQueueLen = 256
+
+// advReport is an advertisement queued for its handler.
+type advReport struct {
+	h ble.AdvHandler
+	a ble.Advertisement
+}
+
+// newAdvertisement copies the i-th report out of e, which is only valid during
+// the event handling.
 func newAdvertisement(e evt.LEAdvertisingReport, i int) *Advertisement {
-	return &Advertisement{e: e, i: i}
+	a := &Advertisement{
+		evtType:  e.EventType(i),
+		addrType: e.AddressType(i),
+		addr:     e.Address(i),
+		rssi:     e.RSSI(i),
+	}
+	a.dlen = uint8(copy(a.data[:], e.Data(i)))
+	return a
 }
 
 // Advertisement implements ble.Advertisement and other functions that are only
 // available on Linux.
 type Advertisement struct {
-	e  evt.LEAdvertisingReport
-	i  int
-	sr *Advertisement
+	evtType  uint8
+	addrType uint8
+	addr     [6]byte
+	rssi     int8
+	dlen     uint8
+	data     [31]byte
+	sr       *Advertisement
 
 	// cached packets.
 	p *adv.Packet
@@ -94,14 +116,14 @@ func (a *Advertisement) Connectable() bool {
 
 // RSSI returns RSSI signal strength.
 func (a *Advertisement) RSSI() int {
-	return int(a.e.RSSI(a.i))
+	return int(a.rssi)
 }
 
 // Address returns the address of the remote peripheral.
 func (a *Advertisement) Address() ble.Addr {
-	b := a.e.Address(a.i)
+	b := a.addr
 	addr := net.HardwareAddr([]byte{b[5], b[4], b[3], b[2], b[1], b[0]})
-	if a.e.AddressType(a.i) == 1 {
+	if a.addrType == 1 {
 		return RandomAddress{addr}
 	}
 	return addr
@@ -110,19 +132,19 @@ func (a *Advertisement) Address() ble.Addr {
 // EventType returns the event type of Advertisement.
 // This is linux sepcific.
 func (a *Advertisement) EventType() uint8 {
-	return a.e.EventType(a.i)
+	return a.evtType
 }
 
 // AddressType returns the address type of the Advertisement.
 // This is linux sepcific.
 func (a *Advertisement) AddressType() uint8 {
-	return a.e.AddressType(a.i)
+	return a.addrType
 }
 
 // Data returns the advertising data of the packet.
 // This is linux sepcific.
 func (a *Advertisement) Data() []byte {
-	return a.e.Data(a.i)
+	return a.data[:a.dlen]
 }
 
 // ScanResponse returns the scan response of the packet, if it presents.
@@ -133,3 +155,240 @@ func (a *Advertisement) ScanResponse() []byte {
 	}
 	return a.sr.Data()
 }
+
+// Event type bits of the LE Extended Advertising Report [Vol 2, Part E, 7.7.65.13].
+const (
+	extEvtTypConnectable = 0x0001 // Connectable advertising.
+	extEvtTypScannable   = 0x0002 // Scannable advertising.
+	extEvtTypDirected    = 0x0004 // Directed advertising.
+	extEvtTypScanRsp     = 0x0008 // Scan response.
+	extEvtTypLegacy      = 0x0010 // Legacy advertising PDUs used.
+)
+
+// Data status of the LE Extended Advertising Report [Vol 2, Part E, 7.7.65.13].
+const (
+	extDataComplete   = 0x00 // Complete.
+	extDataIncomplete = 0x01 // Incomplete, more data to come.
+	extDataTruncated  = 0x02 // Incomplete, data truncated, no more to come.
+)
+
+// maxExtFrags limits the number of advertisers whose data is being reassembled.
+const maxExtFrags = 64
+
+// extAdvKey identifies the advertising data (or scan response) of an
+// advertising set of a remote device.
+type extAdvKey struct {
+	sr       bool
+	addrType uint8
+	addr     [6]byte
+	sid      uint8
+}
+
+func newExtendedAdvertisement(k extAdvKey, e evt.LEExtendedAdvertisingReport, i int) *ExtendedAdvertisement {
+	return &ExtendedAdvertisement{
+		k:            k,
+		evtType:      e.EventType(i),
+		primaryPHY:   e.PrimaryPHY(i),
+		secondaryPHY: e.SecondaryPHY(i),
+		txPower:      e.TxPower(i),
+		rssi:         e.RSSI(i),
+		interval:     e.PeriodicAdvertisingInterval(i),
+		data:         append([]byte(nil), e.Data(i)...),
+	}
+}
+
+// ExtendedAdvertisement implements ble.Advertisement for the advertising
+// reports received with extended scanning, and other functions that are only
+// available on Linux.
+type ExtendedAdvertisement struct {
+	k            extAdvKey
+	evtType      uint16
+	primaryPHY   uint8
+	secondaryPHY uint8
+	txPower      int8
+	rssi         int8
+	interval     uint16
+	data         []byte
+	truncated    bool
+	sr           *ExtendedAdvertisement
+
+	// cached packets.
+	p *adv.Packet
+}
+
+// setScanResponse associates the scan response to the existing advertisement.
+func (a *ExtendedAdvertisement) setScanResponse(sr *ExtendedAdvertisement) {
+	a.sr = sr
+	a.p = nil // clear the cached.
+}
+
+// packets returns the combined advertising packet and scan response (if presents)
+func (a *ExtendedAdvertisement) packets() *adv.Packet {
+	if a.p != nil {
+		return a.p
+	}
+	return adv.NewRawPacket(a.Data(), a.ScanResponse())
+}
+
+// LocalName returns the LocalName of the remote peripheral.
+func (a *ExtendedAdvertisement) LocalName() string {
+	return a.packets().LocalName()
+}
+
+// ManufacturerData returns the ManufacturerData of the advertisement.
+func (a *ExtendedAdvertisement) ManufacturerData() []byte {
+	return a.packets().ManufacturerData()
+}
+
+// ServiceData returns the service data of the advertisement.
+func (a *ExtendedAdvertisement) ServiceData() []ble.ServiceData {
+	return a.packets().ServiceData()
+}
+
+// Services returns the service UUIDs of the advertisement.
+func (a *ExtendedAdvertisement) Services() []ble.UUID {
+	return a.packets().UUIDs()
+}
+
+// OverflowService returns the UUIDs of overflowed service.
+func (a *ExtendedAdvertisement) OverflowService() []ble.UUID {
+	return a.packets().UUIDs()
+}
+
+// TxPowerLevel returns the tx power level of the remote peripheral.
+// If the advertising data doesn't carry one, the transmit power reported by
+// the controller is returned instead.
+func (a *ExtendedAdvertisement) TxPowerLevel() int {
+	if pwr, ok := a.packets().TxPower(); ok || a.txPower == 127 {
+		return pwr
+	}
+	return int(a.txPower)
+}
+
+// SolicitedService returns UUIDs of solicited services.
+func (a *ExtendedAdvertisement) SolicitedService() []ble.UUID {
+	return a.packets().ServiceSol()
+}
+
+// Connectable indicates weather the remote peripheral is connectable.
+func (a *ExtendedAdvertisement) Connectable() bool {
+	return a.evtType&extEvtTypConnectable != 0
+}
+
+// RSSI returns RSSI signal strength.
+func (a *ExtendedAdvertisement) RSSI() int {
+	return int(a.rssi)
+}
+
+// Address returns the address of the remote peripheral.
+func (a *ExtendedAdvertisement) Address() ble.Addr {
+	b := a.k.addr
+	addr := net.HardwareAddr([]byte{b[5], b[4], b[3], b[2], b[1], b[0]})
+	if a.k.addrType == 0x01 || a.k.addrType == 0x03 {
+		return RandomAddress{addr}
+	}
+	return addr
+}
+
+// EventType returns the event type of Advertisement.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) EventType() uint16 {
+	return a.evtType
+}
+
+// AddressType returns the address type of the Advertisement.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) AddressType() uint8 {
+	return a.k.addrType
+}
+
+// Data returns the advertising data of the packet.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) Data() []byte {
+	return a.data
+}
+
+// ScanResponse returns the scan response of the packet, if it presents.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) ScanResponse() []byte {
+	if a.sr == nil {
+		return nil
+	}
+	return a.sr.Data()
+}
+
+// Legacy reports whether the advertisement was sent with legacy PDUs.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) Legacy() bool {
+	return a.evtType&extEvtTypLegacy != 0
+}
+
+// Truncated reports whether the controller truncated the advertising data.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) Truncated() bool {
+	return a.truncated
+}
+
+// PrimaryPHY returns the PHY on which the advertisement was received on the
+// primary advertising channel. 0x01: LE 1M, 0x03: LE Coded.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) PrimaryPHY() uint8 {
+	return a.primaryPHY
+}
+
+// SecondaryPHY returns the PHY used on the secondary advertising channel.
+// 0x00: no packets on the secondary channel, 0x01: LE 1M, 0x02: LE 2M, 0x03: LE Coded.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) SecondaryPHY() uint8 {
+	return a.secondaryPHY
+}
+
+// SID returns the advertising set identifier. 0xFF: no ADI field in the PDU.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) SID() uint8 {
+	return a.k.sid
+}
+
+// TxPower returns the transmit power reported by the controller in dBm.
+// 127: information not available.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) TxPower() int8 {
+	return a.txPower
+}
+
+// PeriodicInterval returns the interval of the periodic advertising, in units
+// of 1.25 msec. 0: no periodic advertising.
+// This is linux sepcific.
+func (a *ExtendedAdvertisement) PeriodicInterval() uint16 {
+	return a.interval
+}
+
+// A PeriodicAdvHandler handles the periodic advertising received from a
+// synchronized periodic advertising train.
+type PeriodicAdvHandler func(a *PeriodicAdvertisement)
+
+// PeriodicAdvertisement is the periodic advertising data received from a
+// synchronized periodic advertising train.
+type PeriodicAdvertisement struct {
+	txPower   int8
+	rssi      int8
+	cteType   uint8
+	data      []byte
+	truncated bool
+}
+
+// TxPower returns the transmit power reported by the controller in dBm.
+// 127: information not available.
+func (a *PeriodicAdvertisement) TxPower() int8 { return a.txPower }
+
+// RSSI returns RSSI signal strength.
+func (a *PeriodicAdvertisement) RSSI() int { return int(a.rssi) }
+
+// CTEType returns the type of Constant Tone Extension. 0xFF: no CTE.
+func (a *PeriodicAdvertisement) CTEType() uint8 { return a.cteType }
+
+// Data returns the periodic advertising data.
+func (a *PeriodicAdvertisement) Data() []byte { return a.data }
+
+// Truncated reports whether the controller truncated the data.
+func (a *PeriodicAdvertisement) Truncated() bool { return a.truncated }
@@ -0,0 +1,69 @@
+package hci
+
+import "github.com/currantlabs/ble"
+
+// IOCapability is the input and output capability of a device, which selects
+// the pairing method. [Vol 3, Part H, 2.3.2]
+type IOCapability uint8
+
+// IO Capabilities [Vol 3, Part H, 3.5.1]
+const (
+	DisplayOnly     IOCapability = 0x00
+	DisplayYesNo    IOCapability = 0x01
+	KeyboardOnly    IOCapability = 0x02
+	NoInputNoOutput IOCapability = 0x03
+	KeyboardDisplay IOCapability = 0x04
+)
+
+// An Agent interacts with the user in pairing.
+//
+// Its methods are called on goroutines of their own, so they may block until
+// the user responds. The pairing fails if the user doesn't respond within the
+// 30-second SMP timeout, in which case the response is discarded. Shutdown
+// waits for them to return, so they should give up once the connection is
+// disconnected.
+type Agent interface {
+	// IOCapability returns the input and output capability of the device.
+	IOCapability() IOCapability
+
+	// AuthorizePairing is called when the remote device requests pairing.
+	// bonding is set if both devices would store the keys distributed. It
+	// returns whether to pair, and whether to bond with the remote device.
+	AuthorizePairing(c *Conn, bonding bool) (accept, bond bool)
+
+	// DisplayPasskey displays the passkey, which the user enters on the remote
+	// device.
+	DisplayPasskey(c *Conn, passkey uint32)
+
+	// RequestPasskey returns the passkey, which the user enters as displayed
+	// on the remote device, or false if the user cancels.
+	RequestPasskey(c *Conn) (passkey uint32, ok bool)
+
+	// ConfirmNumeric displays the value, from 0 to 999999, and returns
+	// whether the user confirms it matches the one displayed on the remote
+	// device.
+	ConfirmNumeric(c *Conn, value uint32) bool
+
+	// PairingComplete is called when the pairing ends, with the resulting
+	// security level of the link, or the error the pairing failed with.
+	PairingComplete(c *Conn, level ble.SecurityLevel, err error)
+}
+
+// securityLevel returns the security level of a link encrypted with the keys.
+func (k *Keys) securityLevel() ble.SecurityLevel {
+	switch {
+	case k.Authenticated && k.SecureConnections && k.KeySize == smpMaxKeySize:
+		return ble.SecuritySecureConnections
+	case k.Authenticated:
+		return ble.SecurityAuthenticated
+	}
+	return ble.SecurityUnauthenticated
+}
+
+// securityLevel returns the security level of the link. The caller holds smpMu.
+func (c *Conn) securityLevel() ble.SecurityLevel {
+	if !c.encrypted || c.encKeys == nil {
+		return ble.SecurityNone
+	}
+	return c.encKeys.securityLevel()
+}
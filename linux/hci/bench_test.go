@@ -0,0 +1,148 @@
+package hci
+
+import (
+	"io"
+	"testing"
+
+	"github.com/currantlabs/ble"
+	"github.com/currantlabs/ble/linux/hci/evt"
+)
+
+// memSkt is an in-memory HCI transport. Reads return the packets queued on in,
+// and writes are discarded.
+type memSkt struct {
+	in chan []byte
+}
+
+func (s *memSkt) Read(b []byte) (int, error) {
+	p, ok := <-s.in
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(b, p), nil
+}
+
+func (s *memSkt) Write(b []byte) (int, error) { return len(b), nil }
+func (s *memSkt) Close() error                { return nil }
+
+const benchHandle = 0x0040
+
+// newBenchHCI returns an HCI running on a memSkt, without a controller.
+func newBenchHCI(b *testing.B) (*HCI, *memSkt) {
+	h, err := NewHCI()
+	if err != nil {
+		b.Fatal(err)
+	}
+	skt := &memSkt{in: make(chan []byte)}
+	h.skt = skt
+	h.bufSize, h.bufCnt = 27, 8
+	h.pool = NewPool(1+4+h.bufSize, h.bufCnt)
+	h.evth[0x3E] = h.handleLEMeta
+	h.subh[evt.LEAdvertisingReportSubCode] = h.handleLEAdvertisingReport
+	h.spawn(h.sktLoop)
+	h.spawn(h.advLoop)
+	return h, skt
+}
+
+// newBenchConn returns a connection as a slave, with the handle benchHandle.
+func newBenchConn(h *HCI) *Conn {
+	e := evt.LEConnectionComplete{
+		evt.LEConnectionCompleteSubCode,
+		0x00,                                 // Status
+		benchHandle & 0xff, benchHandle >> 8, // Connection Handle
+		roleSlave,
+		0x00,                               // Peer Address Type
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, // Peer Address
+		0x18, 0x00, // Conn Interval
+		0x00, 0x00, // Conn Latency
+		0x48, 0x00, // Supervision Timeout
+		0x00, // Master Clock Accuracy
+	}
+	return h.addConn(e)
+}
+
+// aclPkt returns an HCI ACL data packet carrying an ATT PDU of n bytes.
+func aclPkt(n int) []byte {
+	p := []byte{
+		pktTypeACLData,
+		benchHandle & 0xff, benchHandle>>8 | pbfControllerToHostStart<<4,
+		uint8(4 + n), 0x00, // ACL data length
+		uint8(n), 0x00, // L2CAP PDU length
+		uint8(cidLEAtt), 0x00, // L2CAP CID
+	}
+	return append(p, make([]byte, n)...)
+}
+
+func BenchmarkConnRead(b *testing.B) {
+	h, skt := newBenchHCI(b)
+	defer close(skt.in)
+	c := newBenchConn(h)
+	pkt := aclPkt(20)
+	buf := make([]byte, ble.MaxMTU)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		skt.in <- pkt
+		if _, err := c.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnWrite(b *testing.B) {
+	benchmarkConnWrite(b, 20)
+}
+
+func BenchmarkConnWriteFragmented(b *testing.B) {
+	benchmarkConnWrite(b, 200)
+}
+
+func benchmarkConnWrite(b *testing.B, n int) {
+	h, skt := newBenchHCI(b)
+	defer close(skt.in)
+	c := newBenchConn(h)
+	c.SetTxMTU(ble.MaxMTU)
+	sdu := make([]byte, n)
+	frags := (4 + n + h.bufSize - 1) / h.bufSize
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Write(sdu); err != nil {
+			b.Fatal(err)
+		}
+		// Complete the packets, as the controller would.
+		for j := 0; j < frags; j++ {
+			c.txBuffer.Put()
+		}
+	}
+}
+
+func BenchmarkAdvertisingReport(b *testing.B) {
+	h, skt := newBenchHCI(b)
+	defer close(skt.in)
+	done := make(chan struct{})
+	h.advHandler = func(a ble.Advertisement) { done <- struct{}{} }
+	h.adHist = make([]*Advertisement, 128)
+
+	data := []byte{0x02, 0x01, 0x06, 0x05, 0x09, 'b', 'e', 'n', 'c'}
+	pkt := []byte{
+		pktTypeEvent, 0x3E, uint8(12 + len(data)),
+		evt.LEAdvertisingReportSubCode,
+		0x01,                               // Num Reports
+		evtTypAdvInd,                       // Event Type
+		0x00,                               // Address Type
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, // Address
+		uint8(len(data)),
+	}
+	pkt = append(pkt, data...)
+	pkt = append(pkt, 0xC4) // RSSI
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		skt.in <- pkt
+		<-done
+	}
+}
@@ -3,72 +3,259 @@ package hci
 import (
 	"bytes"
 	"sync"
+	"time"
 )
 
-// Pool ...
+// Pool manages the ACL data buffers of the controller, which are shared by all
+// connections [Vol 2, Part E, 4.1.1]. Each buffer is a credit, which a Client
+// holds from sending an ACL data packet until the controller reports it
+// completed.
+//
+// The credits are scheduled fairly across the Clients. A Client waiting for a
+// credit is queued behind the ones already waiting, so each waiting Client gets
+// a turn before any of them gets another one. A Client holds no more than its
+// fair share of the credits, so a stalled peer can't take them all. The share
+// is divided among the active Clients, which are waiting for or holding
+// credits, so a single busy Client can use all of them while the others are
+// idle. Clients with higher priority are served first, and a quota further
+// limits the credits a Client can hold.
 type Pool struct {
 	sync.Mutex
 
 	sz  int
 	cnt int
-	ch  chan *bytes.Buffer
+
+	free    int
+	clients map[*Client]struct{}
+	waiting []*Client
 }
 
-// NewPool ...
+// NewPool returns a pool of cnt buffers of size sz.
 func NewPool(sz int, cnt int) *Pool {
-	ch := make(chan *bytes.Buffer, cnt)
-	for len(ch) < cnt {
-		ch <- bytes.NewBuffer(make([]byte, sz))
+	return &Pool{
+		sz:      sz,
+		cnt:     cnt,
+		free:    cnt,
+		clients: make(map[*Client]struct{}),
 	}
-	return &Pool{sz: sz, cnt: cnt, ch: ch}
 }
 
-// Client ...
-type Client struct {
-	p    *Pool
-	sent chan *bytes.Buffer
+// share returns the number of credits each active Client is entitled to hold.
+// Must be called with the lock held.
+func (p *Pool) share() int {
+	n := 0
+	for c := range p.clients {
+		if c.inUse > 0 || c.waits > 0 {
+			n++
+		}
+	}
+	if n == 0 {
+		return p.cnt
+	}
+	return (p.cnt + n - 1) / n
 }
 
-// NewClient ...
-func NewClient(p *Pool) *Client {
-	return &Client{p: p, sent: make(chan *bytes.Buffer, p.cnt)}
+// schedule grants the free credits to the waiting Clients.
+// Must be called with the lock held.
+func (p *Pool) schedule() {
+	for p.free > 0 {
+		next := -1
+		for i, c := range p.waiting {
+			if c.inUse >= c.limit() {
+				continue
+			}
+			if next < 0 || c.priority > p.waiting[next].priority {
+				next = i
+			}
+		}
+		if next < 0 {
+			return
+		}
+		c := p.waiting[next]
+		p.waiting = append(p.waiting[:next], p.waiting[next+1:]...)
+		p.free--
+		c.waits--
+		c.inUse++
+		c.ready <- struct{}{}
+	}
+}
+
+// TxStats reports how a connection has been using the ACL data buffers of the
+// controller.
+type TxStats struct {
+	InUse    int           // Credits held by packets not yet completed by the controller.
+	Quota    int           // Maximum credits the connection can hold; 0 for no quota.
+	Priority int           // Scheduling priority of the connection.
+	Packets  uint64        // ACL data packets sent.
+	Waits    uint64        // Packets that had to wait for a credit.
+	WaitTime time.Duration // Total time spent waiting for credits.
+	MaxWait  time.Duration // Longest time spent waiting for a credit.
 }
 
-// LockPool ...
-func (c *Client) LockPool() {
-	c.p.Lock()
+// Client is a user of the Pool, typically a connection.
+type Client struct {
+	p   *Pool
+	buf *bytes.Buffer
+
+	// Guarded by the lock of the Pool.
+	inUse    int
+	waits    int // Entries of the Client in the waiting queue.
+	quota    int
+	priority int
+	closed   bool
+	stats    TxStats
+
+	ready chan struct{}
+	done  chan struct{}
 }
 
-// UnlockPool ...
-func (c *Client) UnlockPool() {
-	c.p.Unlock()
+// NewClient returns a Client of the pool p.
+func NewClient(p *Pool) *Client {
+	c := &Client{
+		p:     p,
+		buf:   bytes.NewBuffer(make([]byte, p.sz)),
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	p.Lock()
+	p.clients[c] = struct{}{}
+	p.Unlock()
+	return c
 }
 
-// Get returns a buffer from the shared buffer pool.
-func (c *Client) Get() *bytes.Buffer {
-	b := <-c.p.ch
-	b.Reset()
-	c.sent <- b
-	return b
+// limit returns the number of credits the Client can hold.
+// Must be called with the lock of the Pool held.
+func (c *Client) limit() int {
+	n := c.p.share()
+	if c.quota > 0 && c.quota < n {
+		n = c.quota
+	}
+	return n
 }
 
-// Put puts the oldest sent buffer back to the shared pool.
-func (c *Client) Put() {
+// Get waits for a credit, and returns the buffer of the Client to prepare the
+// ACL data packet in. The buffer can be reused once the packet has been
+// written to the controller. Get returns nil if the Client has been closed.
+func (c *Client) Get() *bytes.Buffer {
+	p := c.p
+	p.Lock()
+	if c.closed {
+		p.Unlock()
+		return nil
+	}
+	p.waiting = append(p.waiting, c)
+	c.waits++
+	p.schedule()
+	p.Unlock()
+
+	start := time.Now()
+	waited := false
 	select {
-	case b := <-c.sent:
-		c.p.ch <- b
+	case <-c.ready:
 	default:
+		waited = true
+		select {
+		case <-c.ready:
+		case <-c.done:
+			return nil
+		}
+	}
+
+	p.Lock()
+	c.stats.Packets++
+	if waited {
+		d := time.Since(start)
+		c.stats.Waits++
+		c.stats.WaitTime += d
+		if d > c.stats.MaxWait {
+			c.stats.MaxWait = d
+		}
+	}
+	p.Unlock()
+
+	c.buf.Reset()
+	return c.buf
+}
+
+// Put returns the credit of the oldest packet, which the controller has completed.
+func (c *Client) Put() {
+	p := c.p
+	p.Lock()
+	defer p.Unlock()
+	if c.inUse > 0 {
+		c.inUse--
+		p.free++
 	}
+	p.schedule()
 }
 
-// PutAll puts all the sent buffers back to the shared pool.
+// PutAll returns all the credits held by the Client.
 func (c *Client) PutAll() {
-	for {
-		select {
-		case b := <-c.sent:
-			c.p.ch <- b
-		default:
-			return
+	p := c.p
+	p.Lock()
+	defer p.Unlock()
+	p.free += c.inUse
+	c.inUse = 0
+	p.schedule()
+}
+
+// Close returns all the credits held by the Client, and removes it from the
+// pool. A pending Get returns nil.
+func (c *Client) Close() {
+	p := c.p
+	p.Lock()
+	defer p.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	waiting := p.waiting[:0]
+	for _, w := range p.waiting {
+		if w != c {
+			waiting = append(waiting, w)
 		}
 	}
+	p.waiting = waiting
+	c.waits = 0
+	select {
+	case <-c.ready:
+		// A credit was granted, but not taken.
+	default:
+	}
+	delete(p.clients, c)
+	p.free += c.inUse
+	c.inUse = 0
+	p.schedule()
+}
+
+// SetQuota limits the number of credits the Client can hold. 0 means no limit
+// other than its fair share.
+func (c *Client) SetQuota(n int) {
+	p := c.p
+	p.Lock()
+	defer p.Unlock()
+	c.quota = n
+	p.schedule()
+}
+
+// SetPriority sets the scheduling priority of the Client. Waiting Clients with
+// higher priority are granted credits first. The default priority is 0.
+func (c *Client) SetPriority(prio int) {
+	p := c.p
+	p.Lock()
+	defer p.Unlock()
+	c.priority = prio
+}
+
+// Stats returns the statistics of the Client.
+func (c *Client) Stats() TxStats {
+	p := c.p
+	p.Lock()
+	defer p.Unlock()
+	s := c.stats
+	s.InUse = c.inUse
+	s.Quota = c.quota
+	s.Priority = c.priority
+	return s
 }
@@ -0,0 +1,149 @@
+package hci
+
+import (
+	"testing"
+	"time"
+)
+
+// get starts n concurrent Gets on c, and returns a channel that receives once
+// for each Get returned.
+func get(c *Client, n int) <-chan struct{} {
+	ch := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			c.Get()
+			ch <- struct{}{}
+		}()
+	}
+	return ch
+}
+
+// granted reports whether a Get has returned in a short while.
+func granted(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func mustGet(t *testing.T, c *Client, n int) {
+	for i := 0; i < n; i++ {
+		if !granted(get(c, 1)) {
+			t.Fatalf("credit %d not granted", i)
+		}
+	}
+}
+
+// queued waits until c has n Gets queued.
+func queued(t *testing.T, c *Client, n int) {
+	for i := 0; i < 100; i++ {
+		c.p.Lock()
+		w := c.waits
+		c.p.Unlock()
+		if w == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d Gets not queued", n)
+}
+
+func inUse(c *Client) int {
+	c.p.Lock()
+	defer c.p.Unlock()
+	return c.inUse
+}
+
+func TestPoolWorkConserving(t *testing.T) {
+	p := NewPool(27, 8)
+	a := NewClient(p)
+	for i := 0; i < 3; i++ {
+		NewClient(p)
+	}
+
+	// The idle Clients don't reserve any credits.
+	mustGet(t, a, 8)
+	if granted(get(a, 1)) {
+		t.Fatal("credit granted from an empty pool")
+	}
+}
+
+func TestPoolFairness(t *testing.T) {
+	p := NewPool(27, 8)
+	a, b := NewClient(p), NewClient(p)
+	mustGet(t, a, 8)
+
+	// Both are waiting, but a holds more than its share of 4 credits, so
+	// the released credits go to b until a drops below its share.
+	wa := get(a, 1)
+	wb := get(b, 5)
+	queued(t, a, 1)
+	queued(t, b, 5)
+	for i := 0; i < 4; i++ {
+		a.Put()
+		if !granted(wb) {
+			t.Fatalf("credit %d not granted to b", i)
+		}
+	}
+	if n := inUse(b); n != 4 {
+		t.Fatalf("b holds %d credits, want 4", n)
+	}
+
+	// a is below its share now, and queued ahead of the last Get of b.
+	a.Put()
+	if !granted(wa) {
+		t.Fatal("credit not granted to a")
+	}
+	if granted(wb) {
+		t.Fatal("credit granted to b above its share")
+	}
+
+	// Once b goes idle, a can use the whole pool again.
+	b.Close()
+	for i := 0; i < 4; i++ {
+		a.Put()
+	}
+	mustGet(t, a, 8)
+}
+
+func TestPoolQuota(t *testing.T) {
+	p := NewPool(27, 8)
+	a := NewClient(p)
+	a.SetQuota(2)
+	mustGet(t, a, 2)
+	w := get(a, 1)
+	queued(t, a, 1)
+	if granted(w) {
+		t.Fatal("credit granted above the quota")
+	}
+	a.Put()
+	if !granted(w) {
+		t.Fatal("credit not granted below the quota")
+	}
+}
+
+func TestPoolPriority(t *testing.T) {
+	p := NewPool(27, 2)
+	a, lo, hi := NewClient(p), NewClient(p), NewClient(p)
+	hi.SetPriority(1)
+	mustGet(t, a, 2)
+
+	// lo is queued first, but hi is served first.
+	wlo := get(lo, 1)
+	queued(t, lo, 1)
+	whi := get(hi, 1)
+	queued(t, hi, 1)
+	a.Put()
+	if !granted(whi) {
+		t.Fatal("credit not granted to the higher priority")
+	}
+	if granted(wlo) {
+		t.Fatal("credit granted to the lower priority first")
+	}
+	a.Put()
+	if !granted(wlo) {
+		t.Fatal("credit not granted to the lower priority")
+	}
+}
@@ -29,3 +29,104 @@ func unmarshal(c commandRP, b []byte) error {
 	buf := bytes.NewBuffer(b)
 	return binary.Read(buf, binary.LittleEndian, c)
 }
+
+// Commands with variable-length parameters can't be marshalled with the
+// generic marshal(), and implement their own Len() and Marshal().
+
+// Len returns the length of the command.
+func (c *LESetExtendedAdvertisingData) Len() int { return 4 + int(c.AdvertisingDataLength) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetExtendedAdvertisingData) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0], b[1], b[2], b[3] = c.AdvertisingHandle, c.Operation, c.FragmentPreference, c.AdvertisingDataLength
+	copy(b[4:], c.AdvertisingData[:c.AdvertisingDataLength])
+	return nil
+}
+
+// Len returns the length of the command.
+func (c *LESetExtendedScanResponseData) Len() int { return 4 + int(c.ScanResponseDataLength) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetExtendedScanResponseData) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0], b[1], b[2], b[3] = c.AdvertisingHandle, c.Operation, c.FragmentPreference, c.ScanResponseDataLength
+	copy(b[4:], c.ScanResponseData[:c.ScanResponseDataLength])
+	return nil
+}
+
+// scanPHYs lists the PHYs, in the order of the parameter arrays, that can be
+// set in ScanningPHYs of LESetExtendedScanParameters. [Vol 2, Part E, 7.8.64]
+var scanPHYs = []uint8{
+	0x01, // LE 1M PHY
+	0x04, // LE Coded PHY
+}
+
+// Len returns the length of the command.
+func (c *LESetExtendedScanParameters) Len() int {
+	n := 3
+	for _, phy := range scanPHYs {
+		if c.ScanningPHYs&phy != 0 {
+			n += 5
+		}
+	}
+	return n
+}
+
+// Marshal serializes the command parameters into binary form.
+// Index 0 of the parameter arrays applies to the LE 1M PHY, and index 1 to the
+// LE Coded PHY. Only the parameters of PHYs set in ScanningPHYs are sent.
+func (c *LESetExtendedScanParameters) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0], b[1], b[2] = c.OwnAddressType, c.ScanningFilterPolicy, c.ScanningPHYs
+	b = b[3:]
+	for i, phy := range scanPHYs {
+		if c.ScanningPHYs&phy == 0 {
+			continue
+		}
+		b[0] = c.ScanType[i]
+		binary.LittleEndian.PutUint16(b[1:], c.ScanInterval[i])
+		binary.LittleEndian.PutUint16(b[3:], c.ScanWindow[i])
+		b = b[5:]
+	}
+	return nil
+}
+
+// Len returns the length of the command.
+func (c *LESetPeriodicAdvertisingData) Len() int { return 3 + int(c.AdvertisingDataLength) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetPeriodicAdvertisingData) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0], b[1], b[2] = c.AdvertisingHandle, c.Operation, c.AdvertisingDataLength
+	copy(b[3:], c.AdvertisingData[:c.AdvertisingDataLength])
+	return nil
+}
+
+// Len returns the length of the command.
+func (c *HostNumberOfCompletedPackets) Len() int { return 1 + 4*int(c.NumberOfHandles) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *HostNumberOfCompletedPackets) Marshal(b []byte) error {
+	n := int(c.NumberOfHandles)
+	if len(c.ConnectionHandle) < n || len(c.HostNumOfCompletedPackets) < n {
+		return io.ErrUnexpectedEOF
+	}
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0] = c.NumberOfHandles
+	for i := 0; i < n; i++ {
+		binary.LittleEndian.PutUint16(b[1+2*i:], c.ConnectionHandle[i])
+		binary.LittleEndian.PutUint16(b[1+2*n+2*i:], c.HostNumOfCompletedPackets[i])
+	}
+	return nil
+}
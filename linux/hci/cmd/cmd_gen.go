@@ -222,6 +222,36 @@ func (c *ReadTransmitPowerLevelRP) Unmarshal(b []byte) error {
 	return unmarshal(c, b)
 }
 
+// SetControllerToHostFlowControl implements Set Controller To Host Flow Control (0x03|0x0031) [Vol 2, Part E, 7.3.38]
+type SetControllerToHostFlowControl struct {
+	FlowControlEnable uint8
+}
+
+func (c *SetControllerToHostFlowControl) String() string {
+	return "Set Controller To Host Flow Control (0x03|0x0031)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *SetControllerToHostFlowControl) OpCode() int { return 0x03<<10 | 0x0031 }
+
+// Len returns the length of the command.
+func (c *SetControllerToHostFlowControl) Len() int { return 1 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *SetControllerToHostFlowControl) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// SetControllerToHostFlowControlRP returns the return parameter of Set Controller To Host Flow Control
+type SetControllerToHostFlowControlRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *SetControllerToHostFlowControlRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
 // HostBufferSize implements Host Buffer Size (0x03|0x0033) [Vol 2, Part E, 7.3.39]
 type HostBufferSize struct {
 	HostACLDataPacketLength            uint16
@@ -269,9 +299,6 @@ func (c *HostNumberOfCompletedPackets) String() string {
 // OpCode returns the opcode of the command.
 func (c *HostNumberOfCompletedPackets) OpCode() int { return 0x03<<10 | 0x0035 }
 
-// Len returns the length of the command.
-func (c *HostNumberOfCompletedPackets) Len() int { return -1 }
-
 // SetEventMaskPage2 implements Set Event Mask Page 2 (0x03|0x0063) [Vol 2, Part E, 7.3.69]
 type SetEventMaskPage2 struct {
 	EventMaskPage2 uint64
@@ -1560,3 +1587,431 @@ type LERemoteConnectionParameterRequestNegativeReplyRP struct {
 func (c *LERemoteConnectionParameterRequestNegativeReplyRP) Unmarshal(b []byte) error {
 	return unmarshal(c, b)
 }
+
+// LESetAdvertisingSetRandomAddress implements LE Set Advertising Set Random Address (0x08|0x0035) [Vol 2, Part E, 7.8.52]
+type LESetAdvertisingSetRandomAddress struct {
+	AdvertisingHandle uint8
+	RandomAddress     [6]byte
+}
+
+func (c *LESetAdvertisingSetRandomAddress) String() string {
+	return "LE Set Advertising Set Random Address (0x08|0x0035)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetAdvertisingSetRandomAddress) OpCode() int { return 0x08<<10 | 0x0035 }
+
+// Len returns the length of the command.
+func (c *LESetAdvertisingSetRandomAddress) Len() int { return 7 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetAdvertisingSetRandomAddress) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetAdvertisingSetRandomAddressRP returns the return parameter of LE Set Advertising Set Random Address
+type LESetAdvertisingSetRandomAddressRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetAdvertisingSetRandomAddressRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetExtendedAdvertisingParameters implements LE Set Extended Advertising Parameters (0x08|0x0036) [Vol 2, Part E, 7.8.53]
+type LESetExtendedAdvertisingParameters struct {
+	AdvertisingHandle             uint8
+	AdvertisingEventProperties    uint16
+	PrimaryAdvertisingIntervalMin [3]byte
+	PrimaryAdvertisingIntervalMax [3]byte
+	PrimaryAdvertisingChannelMap  uint8
+	OwnAddressType                uint8
+	PeerAddressType               uint8
+	PeerAddress                   [6]byte
+	AdvertisingFilterPolicy       uint8
+	AdvertisingTxPower            int8
+	PrimaryAdvertisingPHY         uint8
+	SecondaryAdvertisingMaxSkip   uint8
+	SecondaryAdvertisingPHY       uint8
+	AdvertisingSID                uint8
+	ScanRequestNotificationEnable uint8
+}
+
+func (c *LESetExtendedAdvertisingParameters) String() string {
+	return "LE Set Extended Advertising Parameters (0x08|0x0036)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetExtendedAdvertisingParameters) OpCode() int { return 0x08<<10 | 0x0036 }
+
+// Len returns the length of the command.
+func (c *LESetExtendedAdvertisingParameters) Len() int { return 25 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetExtendedAdvertisingParameters) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetExtendedAdvertisingParametersRP returns the return parameter of LE Set Extended Advertising Parameters
+type LESetExtendedAdvertisingParametersRP struct {
+	Status          uint8
+	SelectedTxPower int8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetExtendedAdvertisingParametersRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetExtendedAdvertisingData implements LE Set Extended Advertising Data (0x08|0x0037) [Vol 2, Part E, 7.8.54]
+type LESetExtendedAdvertisingData struct {
+	AdvertisingHandle     uint8
+	Operation             uint8
+	FragmentPreference    uint8
+	AdvertisingDataLength uint8
+	AdvertisingData       [251]byte
+}
+
+func (c *LESetExtendedAdvertisingData) String() string {
+	return "LE Set Extended Advertising Data (0x08|0x0037)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetExtendedAdvertisingData) OpCode() int { return 0x08<<10 | 0x0037 }
+
+// LESetExtendedAdvertisingDataRP returns the return parameter of LE Set Extended Advertising Data
+type LESetExtendedAdvertisingDataRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetExtendedAdvertisingDataRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetExtendedScanResponseData implements LE Set Extended Scan Response Data (0x08|0x0038) [Vol 2, Part E, 7.8.55]
+type LESetExtendedScanResponseData struct {
+	AdvertisingHandle      uint8
+	Operation              uint8
+	FragmentPreference     uint8
+	ScanResponseDataLength uint8
+	ScanResponseData       [251]byte
+}
+
+func (c *LESetExtendedScanResponseData) String() string {
+	return "LE Set Extended Scan Response Data (0x08|0x0038)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetExtendedScanResponseData) OpCode() int { return 0x08<<10 | 0x0038 }
+
+// LESetExtendedScanResponseDataRP returns the return parameter of LE Set Extended Scan Response Data
+type LESetExtendedScanResponseDataRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetExtendedScanResponseDataRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetExtendedAdvertisingEnable implements LE Set Extended Advertising Enable (0x08|0x0039) [Vol 2, Part E, 7.8.56]
+type LESetExtendedAdvertisingEnable struct {
+	Enable                       uint8
+	NumberOfSets                 uint8
+	AdvertisingHandle            uint8
+	Duration                     uint16
+	MaxExtendedAdvertisingEvents uint8
+}
+
+func (c *LESetExtendedAdvertisingEnable) String() string {
+	return "LE Set Extended Advertising Enable (0x08|0x0039)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetExtendedAdvertisingEnable) OpCode() int { return 0x08<<10 | 0x0039 }
+
+// Len returns the length of the command.
+func (c *LESetExtendedAdvertisingEnable) Len() int { return 6 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetExtendedAdvertisingEnable) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetExtendedAdvertisingEnableRP returns the return parameter of LE Set Extended Advertising Enable
+type LESetExtendedAdvertisingEnableRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetExtendedAdvertisingEnableRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetExtendedScanParameters implements LE Set Extended Scan Parameters (0x08|0x0041) [Vol 2, Part E, 7.8.64]
+type LESetExtendedScanParameters struct {
+	OwnAddressType       uint8
+	ScanningFilterPolicy uint8
+	ScanningPHYs         uint8
+	ScanType             [2]uint8
+	ScanInterval         [2]uint16
+	ScanWindow           [2]uint16
+}
+
+func (c *LESetExtendedScanParameters) String() string {
+	return "LE Set Extended Scan Parameters (0x08|0x0041)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetExtendedScanParameters) OpCode() int { return 0x08<<10 | 0x0041 }
+
+// LESetExtendedScanParametersRP returns the return parameter of LE Set Extended Scan Parameters
+type LESetExtendedScanParametersRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetExtendedScanParametersRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetExtendedScanEnable implements LE Set Extended Scan Enable (0x08|0x0042) [Vol 2, Part E, 7.8.65]
+type LESetExtendedScanEnable struct {
+	Enable           uint8
+	FilterDuplicates uint8
+	Duration         uint16
+	Period           uint16
+}
+
+func (c *LESetExtendedScanEnable) String() string {
+	return "LE Set Extended Scan Enable (0x08|0x0042)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetExtendedScanEnable) OpCode() int { return 0x08<<10 | 0x0042 }
+
+// Len returns the length of the command.
+func (c *LESetExtendedScanEnable) Len() int { return 6 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetExtendedScanEnable) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetExtendedScanEnableRP returns the return parameter of LE Set Extended Scan Enable
+type LESetExtendedScanEnableRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetExtendedScanEnableRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEExtendedCreateConnection implements LE Extended Create Connection (0x08|0x0043) [Vol 2, Part E, 7.8.66]
+type LEExtendedCreateConnection struct {
+	InitiatorFilterPolicy uint8
+	OwnAddressType        uint8
+	PeerAddressType       uint8
+	PeerAddress           [6]byte
+	InitiatingPHYs        uint8
+	ScanInterval          uint16
+	ScanWindow            uint16
+	ConnIntervalMin       uint16
+	ConnIntervalMax       uint16
+	ConnLatency           uint16
+	SupervisionTimeout    uint16
+	MinimumCELength       uint16
+	MaximumCELength       uint16
+}
+
+func (c *LEExtendedCreateConnection) String() string {
+	return "LE Extended Create Connection (0x08|0x0043)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEExtendedCreateConnection) OpCode() int { return 0x08<<10 | 0x0043 }
+
+// Len returns the length of the command.
+func (c *LEExtendedCreateConnection) Len() int { return 26 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEExtendedCreateConnection) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetPeriodicAdvertisingParameters implements LE Set Periodic Advertising Parameters (0x08|0x003E) [Vol 2, Part E, 7.8.61]
+type LESetPeriodicAdvertisingParameters struct {
+	AdvertisingHandle              uint8
+	PeriodicAdvertisingIntervalMin uint16
+	PeriodicAdvertisingIntervalMax uint16
+	PeriodicAdvertisingProperties  uint16
+}
+
+func (c *LESetPeriodicAdvertisingParameters) String() string {
+	return "LE Set Periodic Advertising Parameters (0x08|0x003E)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetPeriodicAdvertisingParameters) OpCode() int { return 0x08<<10 | 0x003E }
+
+// Len returns the length of the command.
+func (c *LESetPeriodicAdvertisingParameters) Len() int { return 7 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetPeriodicAdvertisingParameters) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetPeriodicAdvertisingParametersRP returns the return parameter of LE Set Periodic Advertising Parameters
+type LESetPeriodicAdvertisingParametersRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetPeriodicAdvertisingParametersRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetPeriodicAdvertisingData implements LE Set Periodic Advertising Data (0x08|0x003F) [Vol 2, Part E, 7.8.62]
+type LESetPeriodicAdvertisingData struct {
+	AdvertisingHandle     uint8
+	Operation             uint8
+	AdvertisingDataLength uint8
+	AdvertisingData       [252]byte
+}
+
+func (c *LESetPeriodicAdvertisingData) String() string {
+	return "LE Set Periodic Advertising Data (0x08|0x003F)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetPeriodicAdvertisingData) OpCode() int { return 0x08<<10 | 0x003F }
+
+// LESetPeriodicAdvertisingDataRP returns the return parameter of LE Set Periodic Advertising Data
+type LESetPeriodicAdvertisingDataRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetPeriodicAdvertisingDataRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetPeriodicAdvertisingEnable implements LE Set Periodic Advertising Enable (0x08|0x0040) [Vol 2, Part E, 7.8.63]
+type LESetPeriodicAdvertisingEnable struct {
+	Enable            uint8
+	AdvertisingHandle uint8
+}
+
+func (c *LESetPeriodicAdvertisingEnable) String() string {
+	return "LE Set Periodic Advertising Enable (0x08|0x0040)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetPeriodicAdvertisingEnable) OpCode() int { return 0x08<<10 | 0x0040 }
+
+// Len returns the length of the command.
+func (c *LESetPeriodicAdvertisingEnable) Len() int { return 2 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetPeriodicAdvertisingEnable) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetPeriodicAdvertisingEnableRP returns the return parameter of LE Set Periodic Advertising Enable
+type LESetPeriodicAdvertisingEnableRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetPeriodicAdvertisingEnableRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEPeriodicAdvertisingCreateSync implements LE Periodic Advertising Create Sync (0x08|0x0044) [Vol 2, Part E, 7.8.67]
+type LEPeriodicAdvertisingCreateSync struct {
+	Options               uint8
+	AdvertisingSID        uint8
+	AdvertiserAddressType uint8
+	AdvertiserAddress     [6]byte
+	Skip                  uint16
+	SyncTimeout           uint16
+	SyncCTEType           uint8
+}
+
+func (c *LEPeriodicAdvertisingCreateSync) String() string {
+	return "LE Periodic Advertising Create Sync (0x08|0x0044)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEPeriodicAdvertisingCreateSync) OpCode() int { return 0x08<<10 | 0x0044 }
+
+// Len returns the length of the command.
+func (c *LEPeriodicAdvertisingCreateSync) Len() int { return 14 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEPeriodicAdvertisingCreateSync) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEPeriodicAdvertisingCreateSyncCancel implements LE Periodic Advertising Create Sync Cancel (0x08|0x0045) [Vol 2, Part E, 7.8.68]
+type LEPeriodicAdvertisingCreateSyncCancel struct {
+}
+
+func (c *LEPeriodicAdvertisingCreateSyncCancel) String() string {
+	return "LE Periodic Advertising Create Sync Cancel (0x08|0x0045)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEPeriodicAdvertisingCreateSyncCancel) OpCode() int { return 0x08<<10 | 0x0045 }
+
+// Len returns the length of the command.
+func (c *LEPeriodicAdvertisingCreateSyncCancel) Len() int { return 0 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEPeriodicAdvertisingCreateSyncCancel) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEPeriodicAdvertisingCreateSyncCancelRP returns the return parameter of LE Periodic Advertising Create Sync Cancel
+type LEPeriodicAdvertisingCreateSyncCancelRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEPeriodicAdvertisingCreateSyncCancelRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEPeriodicAdvertisingTerminateSync implements LE Periodic Advertising Terminate Sync (0x08|0x0046) [Vol 2, Part E, 7.8.69]
+type LEPeriodicAdvertisingTerminateSync struct {
+	SyncHandle uint16
+}
+
+func (c *LEPeriodicAdvertisingTerminateSync) String() string {
+	return "LE Periodic Advertising Terminate Sync (0x08|0x0046)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEPeriodicAdvertisingTerminateSync) OpCode() int { return 0x08<<10 | 0x0046 }
+
+// Len returns the length of the command.
+func (c *LEPeriodicAdvertisingTerminateSync) Len() int { return 2 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEPeriodicAdvertisingTerminateSync) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEPeriodicAdvertisingTerminateSyncRP returns the return parameter of LE Periodic Advertising Terminate Sync
+type LEPeriodicAdvertisingTerminateSyncRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEPeriodicAdvertisingTerminateSyncRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
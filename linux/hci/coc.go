@@ -0,0 +1,733 @@
+package hci
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/currantlabs/ble"
+)
+
+// Parameters of the LE Credit Based channels [Vol 3, Part A, 4.22].
+const (
+	cocMinMTU  = 23    // Minimum MTU and MPS.
+	cocMaxMPS  = 65533 // Maximum MPS.
+	cocMaxCred = 65535 // Maximum credits a device can hold.
+
+	// cocRxMTU and cocRxMPS are the MTU and MPS we can receive. cocRxCredits is
+	// the number of K-frames the remote device can send before we've consumed
+	// any of them.
+	cocRxMTU     = 2048
+	cocRxMPS     = 512
+	cocRxCredits = 16
+
+	// cocAcceptQueueLen is the number of established channels a listener holds
+	// before they're accepted. Further requests are refused.
+	cocAcceptQueueLen = 8
+)
+
+// CoCAddr is the address of an endpoint of a LE Credit Based channel.
+type CoCAddr struct {
+	ble.Addr
+	PSM uint16
+}
+
+// Network returns the name of the network.
+func (a CoCAddr) Network() string { return "l2cap" }
+
+func (a CoCAddr) String() string { return fmt.Sprintf("%s/0x%04X", a.Addr, a.PSM) }
+
+// CoCListener accepts the LE Credit Based channels requested to a LE_PSM,
+// on any of the connections. It implements net.Listener.
+type CoCListener struct {
+	h   *HCI
+	psm uint16
+
+	// mu guards closed, and keeps a request from being accepted after the
+	// listener is closed.
+	mu       sync.Mutex
+	closed   bool
+	chAccept chan *CoC
+	chClosed chan struct{}
+}
+
+// ListenCoC registers a listener for the LE Credit Based channels requested to
+// the psm, which ranges from 0x0001 to 0x00FF. [Vol 3, Part A, 4.22]
+func (h *HCI) ListenCoC(psm uint16) (*CoCListener, error) {
+	if psm == 0 || psm > 0x00FF {
+		return nil, ErrInvalidPSM
+	}
+	h.muCoC.Lock()
+	defer h.muCoC.Unlock()
+	if _, ok := h.cocListeners[psm]; ok {
+		return nil, ErrPSMInUse
+	}
+	l := &CoCListener{
+		h:        h,
+		psm:      psm,
+		chAccept: make(chan *CoC, cocAcceptQueueLen),
+		chClosed: make(chan struct{}),
+	}
+	h.cocListeners[psm] = l
+	return l, nil
+}
+
+func (h *HCI) cocListener(psm uint16) *CoCListener {
+	h.muCoC.Lock()
+	defer h.muCoC.Unlock()
+	return h.cocListeners[psm]
+}
+
+// AcceptCoC waits for and returns the next channel established to the listener.
+func (l *CoCListener) AcceptCoC() (*CoC, error) {
+	select {
+	case ch := <-l.chAccept:
+		return ch, nil
+	case <-l.chClosed:
+		return nil, errors.Wrap(io.ErrClosedPipe, "listener closed")
+	case <-l.h.done:
+		return nil, errors.Wrap(io.ErrClosedPipe, "hci closed")
+	}
+}
+
+// Accept waits for and returns the next channel established to the listener.
+func (l *CoCListener) Accept() (net.Conn, error) {
+	ch, err := l.AcceptCoC()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Close unregisters the listener. Further requests to the LE_PSM are refused,
+// and the channels established but not yet accepted are disconnected.
+func (l *CoCListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.chClosed)
+
+	l.h.muCoC.Lock()
+	delete(l.h.cocListeners, l.psm)
+	l.h.muCoC.Unlock()
+
+	for {
+		select {
+		case ch := <-l.chAccept:
+			l.h.spawn(func() { ch.Close() })
+		default:
+			return nil
+		}
+	}
+}
+
+// Addr returns the local address of the listener.
+func (l *CoCListener) Addr() net.Addr { return CoCAddr{Addr: l.h.Addr(), PSM: l.psm} }
+
+// CoC is a LE Credit Based Connection-Oriented Channel, which carries a stream
+// of SDUs with credit based flow control in both directions. [Vol 3, Part A, 10.1]
+// It implements net.Conn.
+type CoC struct {
+	conn *Conn
+	psm  uint16
+	scid uint16 // CID of the local endpoint.
+	dcid uint16 // CID of the remote endpoint, guarded by conn.cocMu.
+
+	// ecred is set for the channels in Enhanced Credit Based Flow Control Mode,
+	// whose MTUs and MPSes can be reconfigured. [Vol 3, Part A, 3.4.3]
+	ecred bool
+
+	mu sync.Mutex
+
+	rxMTU int
+	rxMPS int
+	txMTU int
+	txMPS int
+
+	// txCredits is the number of K-frames we can send. chCredits notifies the
+	// blocked writer when the remote device gives us more credits.
+	txCredits int
+	chCredits chan struct{}
+
+	// rxCredits is the number of K-frames the remote device can send, and
+	// rxConsumed is the credits of the SDUs read, which are yet to be returned.
+	rxCredits  int
+	rxConsumed int
+
+	// SDU being reassembled, accessed by the recombine goroutine only.
+	sdu    []byte
+	slen   int
+	frames int
+
+	// Reassembled SDUs. Each one takes at least a credit, so the queue can
+	// hold as many SDUs as the credits we've given out.
+	chInSDU chan cocSDU
+
+	// rmu serializes the reads, and guards rbuf, the unread part of the SDU.
+	rmu  sync.Mutex
+	rbuf []byte
+
+	// wmu keeps the K-frames of SDUs from interleaving.
+	wmu sync.Mutex
+
+	rdl *deadline
+	wdl *deadline
+
+	// err is the reason the channel was closed, set before chDone is closed.
+	done   bool
+	err    error
+	chDone chan struct{}
+}
+
+type cocSDU struct {
+	b      []byte
+	frames int
+}
+
+// newCoC allocates a local CID, and registers a channel on it.
+func (c *Conn) newCoC(psm uint16, ecred bool) (*CoC, error) {
+	c.cocMu.Lock()
+	defer c.cocMu.Unlock()
+	for cid := cidDynamicFirst; cid <= cidDynamicLast; cid++ {
+		if _, ok := c.cocs[cid]; ok {
+			continue
+		}
+		ch := &CoC{
+			conn:      c,
+			psm:       psm,
+			scid:      cid,
+			ecred:     ecred,
+			rxMTU:     cocRxMTU,
+			rxMPS:     cocRxMPS,
+			rxCredits: cocRxCredits,
+			chCredits: make(chan struct{}, 1),
+			chInSDU:   make(chan cocSDU, cocRxCredits),
+			rdl:       newDeadline(),
+			wdl:       newDeadline(),
+			chDone:    make(chan struct{}),
+		}
+		c.cocs[cid] = ch
+		return ch, nil
+	}
+	return nil, ErrCoCNoResources
+}
+
+// coc returns the channel of the local CID, or nil if there isn't one.
+func (c *Conn) coc(cid uint16) *CoC {
+	c.cocMu.Lock()
+	defer c.cocMu.Unlock()
+	return c.cocs[cid]
+}
+
+// cocByDCID returns the channel of the remote CID, or nil if there isn't one.
+func (c *Conn) cocByDCID(dcid uint16) *CoC {
+	c.cocMu.Lock()
+	defer c.cocMu.Unlock()
+	for _, ch := range c.cocs {
+		if ch.dcid == dcid {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (c *Conn) removeCoC(ch *CoC) {
+	c.cocMu.Lock()
+	defer c.cocMu.Unlock()
+	if c.cocs[ch.scid] == ch {
+		delete(c.cocs, ch.scid)
+	}
+}
+
+// DialCoC requests a LE Credit Based channel to the psm of the remote device.
+// [Vol 3, Part A, 4.22]
+func (c *Conn) DialCoC(ctx context.Context, psm uint16) (*CoC, error) {
+	if psm == 0 || psm > 0x00FF {
+		return nil, ErrInvalidPSM
+	}
+	ch, err := c.newCoC(psm, false)
+	if err != nil {
+		return nil, err
+	}
+	var rsp LECreditBasedConnectionResponse
+	if err := c.signal(ctx, &LECreditBasedConnectionRequest{
+		LEPSM:          psm,
+		SourceCID:      ch.scid,
+		MTU:            cocRxMTU,
+		MPS:            cocRxMPS,
+		InitialCredits: cocRxCredits,
+	}, &rsp); err != nil {
+		c.removeCoC(ch)
+		return nil, errors.Wrap(err, "can't request channel")
+	}
+	if rsp.Result != 0x0000 {
+		c.removeCoC(ch)
+		return nil, CoCError(rsp.Result)
+	}
+
+	ch.establish(rsp.DestinationCID, rsp.MTU, rsp.MPS)
+
+	// The remote device considers the channel established. Disconnect it
+	// if the parameters are invalid.
+	if rsp.DestinationCID < cidDynamicFirst || rsp.DestinationCID > cidDynamicLast ||
+		rsp.MTU < cocMinMTU || rsp.MPS < cocMinMTU || rsp.MPS > cocMaxMPS {
+		ch.Close()
+		return nil, errors.New("invalid channel parameters")
+	}
+	ch.addCredits(int(rsp.InitialCredits))
+	return ch, nil
+}
+
+// LECreditBasedConnectionRequest implements LE Credit Based Connection Request (0x14) [Vol 3, Part A, 4.22].
+func (c *Conn) handleLECreditBasedConnectionRequest(s sigCmd) {
+	var req LECreditBasedConnectionRequest
+	if err := req.Unmarshal(s.data()); err != nil {
+		c.rejectCommand(s.id())
+		return
+	}
+	refuse := func(e CoCError) {
+		c.sendResponse(
+			SignalLECreditBasedConnectionResponse,
+			s.id(),
+			&LECreditBasedConnectionResponse{Result: uint16(e)})
+	}
+
+	l := c.hci.cocListener(req.LEPSM)
+	if l == nil {
+		refuse(ErrCoCPSMNotSupported)
+		return
+	}
+	if req.SourceCID < cidDynamicFirst || req.SourceCID > cidDynamicLast {
+		refuse(ErrCoCInvalidSourceCID)
+		return
+	}
+	if c.cocByDCID(req.SourceCID) != nil {
+		refuse(ErrCoCSourceCIDInUse)
+		return
+	}
+	if req.MTU < cocMinMTU || req.MPS < cocMinMTU || req.MPS > cocMaxMPS {
+		refuse(ErrCoCUnacceptableParams)
+		return
+	}
+
+	// Respond before passing the channel to the listener, so the remote device
+	// learns our CID before any K-frame we send on it.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || len(l.chAccept) == cap(l.chAccept) {
+		refuse(ErrCoCNoResources)
+		return
+	}
+	ch, err := c.newCoC(req.LEPSM, false)
+	if err != nil {
+		refuse(ErrCoCNoResources)
+		return
+	}
+	ch.establish(req.SourceCID, req.MTU, req.MPS)
+	ch.addCredits(int(req.InitialCredits))
+
+	c.sendResponse(
+		SignalLECreditBasedConnectionResponse,
+		s.id(),
+		&LECreditBasedConnectionResponse{
+			DestinationCID: ch.scid,
+			MTU:            cocRxMTU,
+			MPS:            cocRxMPS,
+			InitialCredits: cocRxCredits,
+			Result:         0x0000, // Connection successful.
+		})
+	l.chAccept <- ch
+}
+
+// LEFlowControlCredit implements LE Flow Control Credit (0x16) [Vol 3, Part A, 4.24].
+func (c *Conn) handleLEFlowControlCredit(s sigCmd) {
+	var req LEFlowControlCredit
+	if err := req.Unmarshal(s.data()); err != nil {
+		return
+	}
+
+	// The CID is the source CID of the remote device, i.e. the DCID of ours.
+	if ch := c.cocByDCID(req.CID); ch != nil {
+		ch.addCredits(int(req.Credits))
+	}
+}
+
+// handleCoCDisconnectRequest disconnects the channel on the remote device's request. [Vol 3, Part A, 4.6]
+func (c *Conn) handleCoCDisconnectRequest(id uint8, ch *CoC, req *DisconnectRequest) {
+	// Silently discard the request if SCID failed to find the same match.
+	c.cocMu.Lock()
+	dcid := ch.dcid
+	c.cocMu.Unlock()
+	if req.SourceCID != dcid {
+		return
+	}
+	ch.shut(io.EOF)
+	c.removeCoC(ch)
+	c.sendResponse(
+		SignalDisconnectResponse,
+		id,
+		&DisconnectResponse{
+			DestinationCID: req.DestinationCID,
+			SourceCID:      req.SourceCID,
+		})
+}
+
+// establish sets the remote endpoint of the channel, and its MTU and MPS.
+func (ch *CoC) establish(dcid, mtu, mps uint16) {
+	ch.conn.cocMu.Lock()
+	ch.dcid = dcid
+	ch.conn.cocMu.Unlock()
+	ch.mu.Lock()
+	ch.txMTU, ch.txMPS = int(mtu), int(mps)
+	ch.mu.Unlock()
+}
+
+// addCredits adds the credits the remote device has given us. The channel is
+// disconnected if the credits exceed 65535. [Vol 3, Part A, 10.1]
+func (ch *CoC) addCredits(n int) {
+	ch.mu.Lock()
+	if ch.txCredits+n > cocMaxCred {
+		ch.mu.Unlock()
+		ch.abort(errors.New("credit overflow"))
+		return
+	}
+	ch.txCredits += n
+	ch.mu.Unlock()
+	select {
+	case ch.chCredits <- struct{}{}:
+	default:
+	}
+}
+
+// handleFrame reassembles K-frames into SDUs. [Vol 3, Part A, 3.4.3]
+func (ch *CoC) handleFrame(p pdu) {
+	ch.mu.Lock()
+	if ch.done {
+		// Discard the data after disconnecting. [Vol 3, Part A, 4.6]
+		ch.mu.Unlock()
+		return
+	}
+	if ch.rxCredits == 0 {
+		ch.mu.Unlock()
+		ch.abort(errors.New("K-frame received without credits"))
+		return
+	}
+	ch.rxCredits--
+	mtu, mps := ch.rxMTU, ch.rxMPS
+	ch.mu.Unlock()
+
+	data := p.payload()
+	if len(data) > mps {
+		ch.abort(fmt.Errorf("K-frame size (%d) larger than MPS (%d)", len(data), mps))
+		return
+	}
+
+	// The first K-frame of an SDU carries the length of the SDU.
+	if ch.sdu == nil {
+		if len(data) < 2 {
+			ch.abort(errors.New("K-frame without SDU length"))
+			return
+		}
+		ch.slen = int(binary.LittleEndian.Uint16(data))
+		if ch.slen > mtu {
+			ch.abort(fmt.Errorf("SDU size (%d) larger than MTU (%d)", ch.slen, mtu))
+			return
+		}
+		ch.sdu = make([]byte, 0, ch.slen)
+		data = data[2:]
+	}
+	if len(ch.sdu)+len(data) > ch.slen {
+		ch.abort(fmt.Errorf("SDU size exceeds SDU length (%d)", ch.slen))
+		return
+	}
+	ch.sdu = append(ch.sdu, data...)
+	ch.frames++
+	if len(ch.sdu) < ch.slen {
+		return
+	}
+	select {
+	case ch.chInSDU <- cocSDU{b: ch.sdu, frames: ch.frames}:
+	default:
+		ch.abort(errors.New("SDU queue overflow"))
+	}
+	ch.sdu, ch.frames = nil, 0
+}
+
+// Read reads the data of the received SDUs. The SDUs are read as a stream, so
+// an SDU may be returned by multiple reads, but a read never spans two SDUs.
+func (ch *CoC) Read(b []byte) (int, error) {
+	ch.rmu.Lock()
+	defer ch.rmu.Unlock()
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for len(ch.rbuf) == 0 {
+		s, err := ch.recv()
+		if err != nil {
+			return 0, err
+		}
+		ch.rbuf = s.b
+		ch.consume(s.frames)
+	}
+	n := copy(b, ch.rbuf)
+	ch.rbuf = ch.rbuf[n:]
+	return n, nil
+}
+
+// recv returns the next SDU. The SDUs received before the channel was closed
+// are still returned.
+func (ch *CoC) recv() (cocSDU, error) {
+	select {
+	case s := <-ch.chInSDU:
+		return s, nil
+	default:
+	}
+	select {
+	case s := <-ch.chInSDU:
+		return s, nil
+	case <-ch.chDone:
+		return cocSDU{}, ch.err
+	case <-ch.conn.chDone:
+		return cocSDU{}, io.EOF
+	case <-ch.rdl.wait():
+		return cocSDU{}, timeoutError{}
+	}
+}
+
+// consume returns the credits of the read SDUs to the remote device, once half
+// of the credits are consumed, or no more SDUs are pending.
+func (ch *CoC) consume(frames int) {
+	ch.mu.Lock()
+	ch.rxConsumed += frames
+	if ch.done || (ch.rxConsumed < cocRxCredits/2 && len(ch.chInSDU) > 0) {
+		ch.mu.Unlock()
+		return
+	}
+	n := ch.rxConsumed
+	ch.rxCredits += n
+	ch.rxConsumed = 0
+	ch.mu.Unlock()
+	ch.conn.sendCommand(&LEFlowControlCredit{CID: ch.scid, Credits: uint16(n)})
+}
+
+// Write sends b as SDUs of up to the MTU of the remote device, each of which is
+// segmented into K-frames of up to its MPS. [Vol 3, Part A, 7.3]
+// It blocks while we have no credits left.
+func (ch *CoC) Write(b []byte) (int, error) {
+	ch.wmu.Lock()
+	defer ch.wmu.Unlock()
+	n := 0
+	for len(b) > 0 {
+		sdu := b
+		if mtu := ch.TxMTU(); len(sdu) > mtu {
+			sdu = sdu[:mtu]
+		}
+		var slen [2]byte
+		binary.LittleEndian.PutUint16(slen[:], uint16(len(sdu)))
+		hdr := slen[:]
+		for rest := sdu; len(rest) > 0; hdr = nil {
+			if err := ch.waitCredit(); err != nil {
+				return n, err
+			}
+			// The MPS may be reduced by the remote device in the middle of an SDU.
+			ch.mu.Lock()
+			m := ch.txMPS - len(hdr)
+			ch.mu.Unlock()
+			if m > len(rest) {
+				m = len(rest)
+			}
+			if _, err := ch.conn.writePDU(ch.dcid, hdr, rest[:m]); err != nil {
+				return n, err
+			}
+			n += m
+			rest = rest[m:]
+		}
+		b = b[len(sdu):]
+	}
+	return n, nil
+}
+
+// waitCredit takes a credit for sending a K-frame. The credits left are of
+// no use once the channel is closed.
+func (ch *CoC) waitCredit() error {
+	for {
+		ch.mu.Lock()
+		if ch.done {
+			ch.mu.Unlock()
+			return errors.Wrap(io.ErrClosedPipe, "channel closed")
+		}
+		if ch.txCredits > 0 {
+			ch.txCredits--
+			ch.mu.Unlock()
+			return nil
+		}
+		ch.mu.Unlock()
+		select {
+		case <-ch.chCredits:
+		case <-ch.chDone:
+			return errors.Wrap(io.ErrClosedPipe, "channel closed")
+		case <-ch.conn.chDone:
+			return errors.Wrap(io.ErrClosedPipe, "connection closed")
+		case <-ch.wdl.wait():
+			return timeoutError{}
+		}
+	}
+}
+
+// shut marks the channel closed for the reason err, and reports whether it
+// was open.
+func (ch *CoC) shut(err error) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.done {
+		return false
+	}
+	ch.done, ch.err = true, err
+	close(ch.chDone)
+	return true
+}
+
+// abort disconnects the channel on a violation of the remote device, which
+// is detected by the recombine goroutine, and mustn't wait for the response.
+func (ch *CoC) abort(err error) {
+	logger.Warn("coc", "disconnect", fmt.Sprintf("%04X: %s", ch.scid, err))
+	ch.conn.hci.spawn(func() { ch.disconnect(err) })
+}
+
+// disconnect sends a Disconnect Request, and removes the channel once the
+// remote device responds. [Vol 3, Part A, 4.6]
+func (ch *CoC) disconnect(reason error) error {
+	if !ch.shut(reason) {
+		return nil
+	}
+	defer ch.conn.removeCoC(ch)
+	select {
+	case <-ch.conn.chDone:
+		return nil
+	default:
+	}
+	ch.conn.cocMu.Lock()
+	dcid := ch.dcid
+	ch.conn.cocMu.Unlock()
+	return ch.conn.Signal(&DisconnectRequest{
+		DestinationCID: dcid,
+		SourceCID:      ch.scid,
+	}, &DisconnectResponse{})
+}
+
+// Close disconnects the channel.
+func (ch *CoC) Close() error {
+	return ch.disconnect(errors.Wrap(io.ErrClosedPipe, "channel closed"))
+}
+
+// Conn returns the connection the channel is on.
+func (ch *CoC) Conn() *Conn { return ch.conn }
+
+// LocalAddr returns the local address of the channel.
+func (ch *CoC) LocalAddr() net.Addr { return CoCAddr{Addr: ch.conn.LocalAddr(), PSM: ch.psm} }
+
+// RemoteAddr returns the remote address of the channel.
+func (ch *CoC) RemoteAddr() net.Addr { return CoCAddr{Addr: ch.conn.RemoteAddr(), PSM: ch.psm} }
+
+// RxMTU returns the maximum size of the SDUs we can receive.
+func (ch *CoC) RxMTU() int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.rxMTU
+}
+
+// TxMTU returns the maximum size of the SDUs the remote device can receive.
+func (ch *CoC) TxMTU() int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.txMTU
+}
+
+// SetDeadline sets the read and write deadlines of the channel.
+func (ch *CoC) SetDeadline(t time.Time) error {
+	ch.rdl.set(t)
+	ch.wdl.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline of the pending and future reads.
+func (ch *CoC) SetReadDeadline(t time.Time) error {
+	ch.rdl.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of the pending and future writes.
+func (ch *CoC) SetWriteDeadline(t time.Time) error {
+	ch.wdl.set(t)
+	return nil
+}
+
+// deadline is the read or write deadline of a channel. The channel returned by
+// wait is closed once the deadline has passed.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Wait for the expiring timer to close cancel, if it's too late to stop it.
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := false
+	select {
+	case <-d.cancel:
+		closed = true
+	default:
+	}
+
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// timeoutError is returned when a deadline of a channel has passed.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
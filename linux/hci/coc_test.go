@@ -0,0 +1,185 @@
+package hci
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const testPSM = 0x0080
+
+// newCoCPipe returns the master and the slave of an smpPipe, and a listener
+// on testPSM of the slave.
+func newCoCPipe(t *testing.T) (*smpPipe, *CoCListener) {
+	p := newSMPPipe(t, nil, nil, nil)
+	l, err := p.sc.hci.ListenCoC(testPSM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return p, l
+}
+
+// dialCoC establishes a LE Credit Based channel from the master to the slave.
+func dialCoC(t *testing.T, p *smpPipe, l *CoCListener) (mch, sch *CoC) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	mch, err := p.mc.DialCoC(ctx, testPSM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sch, err = l.AcceptCoC(); err != nil {
+		t.Fatal(err)
+	}
+	return mch, sch
+}
+
+// pattern returns n octets, which differ from their neighbours.
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestCoCSegmentation(t *testing.T) {
+	p, l := newCoCPipe(t)
+	mch, sch := dialCoC(t, p, l)
+	if mtu := mch.TxMTU(); mtu != cocRxMTU {
+		t.Fatalf("tx MTU = %d, want %d", mtu, cocRxMTU)
+	}
+
+	// The data is sent as SDUs of up to the MTU, each of which is segmented
+	// into K-frames of up to the MPS, and those into ACL fragments. It takes
+	// more K-frames than the initial credits, which are returned as the data
+	// is read.
+	for _, n := range []int{1, cocRxMPS - 2, cocRxMPS - 1, cocRxMTU, 10 * cocRxMTU} {
+		b := pattern(n)
+		errc := make(chan error, 1)
+		go func() {
+			_, err := mch.Write(b)
+			errc <- err
+		}()
+		got := make([]byte, n)
+		sch.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(sch, got); err != nil {
+			t.Fatalf("%d octets: %v", n, err)
+		}
+		if !bytes.Equal(got, b) {
+			t.Fatalf("%d octets: data differs", n)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("%d octets: %v", n, err)
+		}
+	}
+
+	// A read doesn't span two SDUs.
+	mch.Write([]byte{1, 2})
+	mch.Write([]byte{3})
+	got := make([]byte, 4)
+	if n, err := sch.Read(got); err != nil || n != 2 {
+		t.Fatalf("read %d octets, %v, want 2", n, err)
+	}
+}
+
+func TestCoCCredits(t *testing.T) {
+	p, l := newCoCPipe(t)
+	mch, sch := dialCoC(t, p, l)
+
+	// Each SDU takes a K-frame, and the credits run out while none is read.
+	for i := 0; i < cocRxCredits; i++ {
+		if _, err := mch.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mch.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := mch.Write([]byte{0xFF})
+	if err, ok := err.(net.Error); !ok || !err.Timeout() || n != 0 {
+		t.Fatalf("write without credits: %d, %v", n, err)
+	}
+
+	// The credits are returned once half of them are consumed.
+	mch.SetWriteDeadline(time.Time{})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := mch.Write([]byte{0xFF})
+		errc <- err
+	}()
+	b := make([]byte, 1)
+	for i := 0; i < cocRxCredits/2; i++ {
+		if _, err := sch.Read(b); err != nil || b[0] != byte(i) {
+			t.Fatalf("read %X, %v, want %X", b, err, i)
+		}
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("credits not returned")
+	}
+}
+
+func TestCoCCreditOverflow(t *testing.T) {
+	p, l := newCoCPipe(t)
+	mch, sch := dialCoC(t, p, l)
+
+	// The credits the slave gives would exceed 65535 on the master, which
+	// disconnects the channel.
+	p.sc.sendCommand(&LEFlowControlCredit{CID: sch.scid, Credits: cocMaxCred})
+	mch.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := mch.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "credit overflow") {
+		t.Fatalf("master read: %v, want credit overflow", err)
+	}
+	sch.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := sch.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("slave read: %v, want %v", err, io.EOF)
+	}
+	if p.sc.coc(sch.scid) != nil {
+		t.Error("channel not removed")
+	}
+}
+
+func TestCoCDisconnect(t *testing.T) {
+	p, l := newCoCPipe(t)
+	mch, sch := dialCoC(t, p, l)
+
+	// The SDUs received before the channel is disconnected are still read.
+	if _, err := mch.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mch.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	sch.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := sch.Read(b); err != nil || b[0] != 1 {
+		t.Fatalf("slave read %X, %v, want 01", b, err)
+	}
+	if _, err := sch.Read(b); err != io.EOF {
+		t.Fatalf("slave read: %v, want %v", err, io.EOF)
+	}
+	if _, err := mch.Read(b); err == nil {
+		t.Fatal("read on closed channel")
+	}
+	if _, err := mch.Write([]byte{2}); err == nil {
+		t.Fatal("write on closed channel")
+	}
+	if p.mc.coc(mch.scid) != nil || p.sc.coc(sch.scid) != nil {
+		t.Error("channel not removed")
+	}
+
+	// Requests to a LE_PSM without a listener are refused.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := p.mc.DialCoC(ctx, testPSM+1); err != ErrCoCPSMNotSupported {
+		t.Fatalf("dial: %v, want %v", err, ErrCoCPSMNotSupported)
+	}
+}
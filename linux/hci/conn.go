@@ -1,11 +1,11 @@
 package hci
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 
 	"golang.org/x/net/context"
 
@@ -22,6 +22,11 @@ type Conn struct {
 
 	param evt.LEConnectionComplete
 
+	// ownAddrType is the address type the local device established the
+	// connection with, which is the one of advertising for a slave, and of
+	// initiating for a master.
+	ownAddrType uint8
+
 	// While MTU is the maximum size of payload data that the upper layer (ATT)
 	// can accept, the MPS is the maximum PDU payload size this L2CAP implementation
 	// supports. When segmantation is not used, the MPS should be made to the same
@@ -52,19 +57,63 @@ type Conn struct {
 	// The requesting device sets this field and the responding device uses the
 	// same value in its response. Within each signalling channel a different
 	// Identifier shall be used for each successive command. [Vol 3, Part A, 4]
-	sigID uint8
-
-	sigSent chan []byte
-	smpSent chan []byte
+	// sigPending are the outstanding requests, indexed by their identifiers.
+	sigMu      sync.Mutex
+	sigID      uint8
+	sigPending map[uint8]*sigTxn
+
+	// pairing is the ongoing pairing, and keys are the keys of the last one.
+	// bond is the peer the keys are stored for in the key store, if they are.
+	// encrypted is set while the link is encrypted, and encKeys are the keys
+	// of the STK or LTK it's encrypted with, which determine its security
+	// level. encPending are the ones of the encryption being started, so the
+	// keys of a bond apply only once the link is encrypted with its LTK.
+	// smpTimedOut is set once a pairing has timed out, after which the SMP is
+	// disabled. [Vol 3, Part H, 3.4]
+	smpMu       sync.Mutex
+	pairing     *pairing
+	keys        *Keys
+	bond        *IdentityAddr
+	encrypted   bool
+	encKeys     *Keys
+	encPending  *Keys
+	smpTimedOut bool
 
 	chInPkt chan packet
-	chInPDU chan pdu
+	chInPDU chan rxPDU
+
+	// pduBufs is the free list of buffers for recombined PDUs.
+	pduBufs chan pdu
+
+	// rxHeld counts the ACL data packets queued or recombined into PDUs not
+	// yet consumed, and rxCompleted the consumed ones, which are yet to be
+	// returned to the controller. The host buffers are shared by all the
+	// connections, so a new PDU is dropped while the connection holds its
+	// share, and rxDropping is set until the next one. [Vol 2, Part E, 4.2]
+	rxMu        sync.Mutex
+	rxHeld      int
+	rxCompleted int
+	rxDropping  bool
 
 	// Host to Controller Data Flow Control pkt-based Data flow control for LE-U [Vol 2, Part E, 4.1.1]
-	// chSentBufs tracks the HCI buffer occupied by this connection.
+	// txBuffer tracks the HCI buffers occupied by this connection.
 	txBuffer *Client
 
+	// txMu keeps the fragments of a PDU from interleaving with other PDUs.
+	txMu sync.Mutex
+
+	// fixed are the handlers of the fixed channels registered on this Conn.
+	fixedMu sync.Mutex
+	fixed   map[uint16]FixedChannelHandler
+
+	// cocs are the LE Credit Based channels, indexed by local CIDs.
+	cocMu sync.Mutex
+	cocs  map[uint16]*CoC
+
 	chDone chan struct{}
+
+	// reason is the reason of disconnection, set before chDone is closed.
+	reason error
 }
 
 func newConn(h *HCI, param evt.LEConnectionComplete) *Conn {
@@ -81,15 +130,35 @@ func newConn(h *HCI, param evt.LEConnectionComplete) *Conn {
 		sigRxMTU: ble.MaxMTU,
 		sigTxMTU: ble.DefaultMTU,
 
+		sigPending: make(map[uint8]*sigTxn),
+
 		chInPkt: make(chan packet, 16),
-		chInPDU: make(chan pdu, 16),
+		chInPDU: make(chan rxPDU, 16),
+		pduBufs: make(chan pdu, 16+1),
 
 		txBuffer: NewClient(h.pool),
 
+		fixed: make(map[uint16]FixedChannelHandler),
+		cocs:  make(map[uint16]*CoC),
+
 		chDone: make(chan struct{}),
 	}
 
-	go func() {
+	h.params.RLock()
+	c.ownAddrType = h.params.advParams.OwnAddressType
+	if param.Role() == roleMaster {
+		c.ownAddrType = h.params.connParams.OwnAddressType
+	}
+	h.params.RUnlock()
+
+	// With Controller to Host flow control, the controller never sends more
+	// packets than the host can hold, so the sktLoop never blocks on a slow
+	// connection.
+	if h.hostFlow {
+		c.chInPkt = make(chan packet, hostACLDataCnt)
+	}
+
+	h.spawn(func() {
 		for {
 			if err := c.recombine(); err != nil {
 				if err != io.EOF {
@@ -101,7 +170,7 @@ func newConn(h *HCI, param evt.LEConnectionComplete) *Conn {
 				return
 			}
 		}
-	}()
+	})
 	return c
 }
 
@@ -117,10 +186,15 @@ func (c *Conn) SetContext(ctx context.Context) {
 
 // Read copies re-assembled L2CAP PDUs into sdu.
 func (c *Conn) Read(sdu []byte) (n int, err error) {
-	p, ok := <-c.chInPDU
+	rp, ok := <-c.chInPDU
 	if !ok {
 		return 0, errors.Wrap(io.ErrClosedPipe, "input channel closed")
 	}
+	p, pkts := rp.p, rp.pkts
+	defer func() {
+		c.putPDU(p)
+		c.consumed(pkts)
+	}()
 	if len(p) == 0 {
 		return 0, errors.Wrap(io.ErrUnexpectedEOF, "recieved empty packet")
 	}
@@ -136,12 +210,16 @@ func (c *Conn) Read(sdu []byte) (n int, err error) {
 	if cap(sdu) < slen {
 		return 0, errors.Wrapf(io.ErrShortBuffer, "payload recieved exceeds sdu buffer")
 	}
-	buf := bytes.NewBuffer(sdu)
-	buf.Reset()
-	buf.Write(data)
-	for buf.Len() < slen {
-		p := <-c.chInPDU
-		buf.Write(pdu(p).payload())
+	sdu = sdu[:cap(sdu)]
+	n = copy(sdu, data)
+	for n < slen {
+		rp, ok := <-c.chInPDU
+		if !ok {
+			return n, errors.Wrap(io.ErrUnexpectedEOF, "input channel closed")
+		}
+		n += copy(sdu[n:], rp.p.payload())
+		c.putPDU(rp.p)
+		pkts += rp.pkts
 	}
 	return slen, nil
 }
@@ -152,81 +230,103 @@ func (c *Conn) Write(sdu []byte) (int, error) {
 		return 0, errors.Wrap(io.ErrShortWrite, "payload exceeds mtu")
 	}
 
-	plen := len(sdu)
-	if plen > c.txMTU {
-		plen = c.txMTU
-	}
-	b := make([]byte, 4+plen)
-	binary.LittleEndian.PutUint16(b[0:2], uint16(len(sdu)))
-	binary.LittleEndian.PutUint16(b[2:4], cidLEAtt)
 	if c.leFrame {
-		binary.LittleEndian.PutUint16(b[4:6], uint16(len(sdu)))
-		copy(b[6:], sdu)
-	} else {
-		copy(b[4:], sdu)
-	}
-	sent, err := c.writePDU(b)
-	if err != nil {
-		return sent, err
-	}
-	sdu = sdu[plen:]
-
-	for len(sdu) > 0 {
-		plen := len(sdu)
-		if plen > c.txMTU {
-			plen = c.txMTU
-		}
-		n, err := c.writePDU(sdu[:plen])
-		sent += n
-		if err != nil {
-			return sent, err
+		var slen [2]byte
+		binary.LittleEndian.PutUint16(slen[:], uint16(len(sdu)))
+		n, err := c.writePDU(cidLEAtt, slen[:], sdu)
+		if n -= len(slen); n < 0 {
+			n = 0
 		}
-		sdu = sdu[plen:]
+		return n, err
 	}
-	return sent, nil
+	return c.writePDU(cidLEAtt, sdu)
 }
 
-// writePDU breaks down a L2CAP PDU into fragments if it's larger than the HCI buffer size. [Vol 3, Part A, 7.2.1]
-func (c *Conn) writePDU(pdu []byte) (int, error) {
+// writePDU sends a L2CAP PDU on channel cid, whose information payload is the
+// concatenation of data, and returns the number of payload bytes sent.
+// The PDU is broken down into fragments if it's larger than the HCI buffer size. [Vol 3, Part A, 7.2.1]
+func (c *Conn) writePDU(cid uint16, data ...[]byte) (int, error) {
+	// Basic L2CAP header [Vol 3, Part A, 3.1]
+	var hdr [4]byte
+	dlen := 0
+	for _, d := range data {
+		dlen += len(d)
+	}
+	binary.LittleEndian.PutUint16(hdr[0:2], uint16(dlen))
+	binary.LittleEndian.PutUint16(hdr[2:4], cid)
+
 	sent := 0
+	rest := len(hdr) + dlen
+	cur := hdr[:]                                  // Data being fragmented.
 	flags := uint16(pbfHostToControllerStart << 4) // ACL boundary flags
 
 	// All L2CAP fragments associated with an L2CAP PDU shall be processed for
 	// transmission by the Controller before any other L2CAP PDU for the same
 	// logical transport shall be processed.
-	c.txBuffer.LockPool()
-	defer c.txBuffer.UnlockPool()
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
 
-	for len(pdu) > 0 {
+	for rest > 0 {
 		// Get a buffer from our pre-allocated and flow-controlled pool.
 		pkt := c.txBuffer.Get() // ACL pkt
-		flen := len(pdu)        // fragment length
+		if pkt == nil {
+			return payloadSent(sent), io.ErrClosedPipe
+		}
+		flen := rest // fragment length
 		if flen > pkt.Cap()-1-4 {
 			flen = pkt.Cap() - 1 - 4
 		}
 
 		// Prepare the Headers
-		binary.Write(pkt, binary.LittleEndian, uint8(pktTypeACLData))                         // HCI Header: pkt Type
-		binary.Write(pkt, binary.LittleEndian, uint16(c.param.ConnectionHandle()|(flags<<8))) // ACL Header: handle and flags
-		binary.Write(pkt, binary.LittleEndian, uint16(flen))                                  // ACL Header: data len
-		binary.Write(pkt, binary.LittleEndian, pdu[:flen])                                    // Append payload
+		var ah [5]byte
+		ah[0] = pktTypeACLData                                                        // HCI Header: pkt Type
+		binary.LittleEndian.PutUint16(ah[1:3], c.param.ConnectionHandle()|(flags<<8)) // ACL Header: handle and flags
+		binary.LittleEndian.PutUint16(ah[3:5], uint16(flen))                          // ACL Header: data len
+		pkt.Write(ah[:])
+
+		// Append payload
+		for n := flen; n > 0; {
+			for len(cur) == 0 {
+				cur, data = data[0], data[1:]
+			}
+			m := n
+			if m > len(cur) {
+				m = len(cur)
+			}
+			pkt.Write(cur[:m])
+			cur, n = cur[m:], n-m
+		}
 
 		// Flush the pkt to HCI
 		select {
 		case <-c.chDone:
-			return 0, io.ErrClosedPipe
+			return payloadSent(sent), io.ErrClosedPipe
 		default:
 		}
 
 		if _, err := c.hci.skt.Write(pkt.Bytes()); err != nil {
-			return sent, err
+			return payloadSent(sent), err
 		}
 		sent += flen
+		rest -= flen
 
 		flags = (pbfContinuing << 4) // Set "continuing" in the boundary flags for the rest of fragments, if any.
-		pdu = pdu[flen:]             // Advence the point
 	}
-	return sent, nil
+	return payloadSent(sent), nil
+}
+
+// payloadSent returns the number of payload bytes in the sent bytes of a PDU.
+func payloadSent(sent int) int {
+	if sent < 4 {
+		return 0
+	}
+	return sent - 4
+}
+
+// rxPDU is a recombined PDU, and the number of ACL data packets it took.
+type rxPDU struct {
+	p    pdu
+	pkts int
 }
 
 // Recombines fragments into a L2CAP PDU. [Vol 3, Part A, 7.2.2]
@@ -235,6 +335,7 @@ func (c *Conn) recombine() error {
 	if !ok {
 		return io.EOF
 	}
+	pkts := 1
 
 	p := pdu(pkt.data())
 
@@ -242,49 +343,141 @@ func (c *Conn) recombine() error {
 	// re-combine them anyway, and discard them later when we dispatch the PDU
 	// according to CID.
 	if p.cid() == cidLEAtt && p.dlen() > c.rxMPS {
+		c.hci.putPkt(pkt)
+		c.consumed(pkts)
 		return fmt.Errorf("fragment size (%d) larger than rxMPS (%d)", p.dlen(), c.rxMPS)
 	}
 
-	// If this pkt is not a complete PDU, and we'll be receiving more
-	// fragments, re-allocate the whole PDU (including Header).
-	if len(p.payload()) < p.dlen() {
-		p = make([]byte, 0, 4+p.dlen())
-		p = append(p, pdu(pkt.data())...)
-	}
+	// Recombine the whole PDU (including Header) into a buffer of its own,
+	// so the packet buffers can be reused right away.
+	p = append(c.getPDU(4+p.dlen()), p...)
+	c.hci.putPkt(pkt)
 	for len(p) < 4+p.dlen() {
-		if pkt, ok = <-c.chInPkt; !ok || (pkt.pbf()&pbfContinuing) == 0 {
+		if pkt, ok = <-c.chInPkt; ok {
+			pkts++
+		}
+		if !ok || (pkt.pbf()&pbfContinuing) == 0 {
+			c.putPDU(p)
+			c.consumed(pkts)
 			return io.ErrUnexpectedEOF
 		}
 		p = append(p, pdu(pkt.data())...)
+		c.hci.putPkt(pkt)
 	}
 
-	// TODO: support dynamic or assigned channels for LE-Frames.
 	switch p.cid() {
 	case cidLEAtt:
-		c.chInPDU <- p
+		// Released and consumed by Read.
+		c.chInPDU <- rxPDU{p, pkts}
+		return nil
 	case cidLESignal:
 		c.handleSignal(p)
 	case cidSMP:
 		c.handleSMP(p)
 	default:
-		logger.Info("recombine()", "unrecognized CID", fmt.Sprintf("%04X, [%X]", p.cid(), p))
+		if ch := c.coc(p.cid()); ch != nil {
+			ch.handleFrame(p)
+		} else if fh := c.fixedHandler(p.cid()); fh != nil {
+			fh(c, p.payload())
+		} else {
+			logger.Info("recombine()", "unrecognized CID", fmt.Sprintf("%04X, [%X]", p.cid(), p))
+		}
 	}
+	c.putPDU(p)
+	c.consumed(pkts)
 	return nil
 }
 
+// getPDU returns an empty buffer with the capacity of n from the free list.
+func (c *Conn) getPDU(n int) pdu {
+	select {
+	case b := <-c.pduBufs:
+		if cap(b) >= n {
+			return b[:0]
+		}
+	default:
+	}
+	return make(pdu, 0, n)
+}
+
+// putPDU returns the buffer of a PDU to the free list.
+func (c *Conn) putPDU(p pdu) {
+	select {
+	case c.pduBufs <- p:
+	default:
+	}
+}
+
+// hold reports whether an ACL data packet received on the connection is to be
+// queued, in which case it holds a host buffer until the PDU it belongs to is
+// consumed. The packets of a PDU starting while the connection holds its share
+// of the host buffers are dropped, so the connection can't hold the buffers of
+// the others by more than the packets of a PDU. share is 0 if the buffers
+// aren't limited.
+func (c *Conn) hold(start bool, share int) bool {
+	c.rxMu.Lock()
+	defer c.rxMu.Unlock()
+	if start {
+		c.rxDropping = share > 0 && c.rxHeld >= share
+	}
+	if c.rxDropping {
+		return false
+	}
+	c.rxHeld++
+	return true
+}
+
+// consumed returns the host buffers of n consumed ACL data packets to the
+// controller, once the queues of the connection have drained, or in batches.
+func (c *Conn) consumed(n int) {
+	c.rxMu.Lock()
+	c.rxHeld -= n
+	c.rxCompleted += n
+	done := 0
+	if c.rxCompleted >= hostACLDataBatch || len(c.chInPkt) == 0 && len(c.chInPDU) == 0 {
+		done, c.rxCompleted = c.rxCompleted, 0
+	}
+	c.rxMu.Unlock()
+
+	select {
+	case <-c.chDone:
+		// The controller frees the buffers of a disconnected connection on its
+		// own. [Vol 2, Part E, 7.7.5]
+	default:
+		c.hci.completeACL(c.param.ConnectionHandle(), done)
+	}
+}
+
 // Disconnected returns a receiving channel, which is closed when the connection disconnects.
 func (c *Conn) Disconnected() <-chan struct{} {
 	return c.chDone
 }
 
+// DisconnectReason returns the reason the connection was disconnected for,
+// or nil if it's still connected. The reason is an ErrCommand, such as
+// ErrRemoteUser (terminated by the remote device), ErrConnTimeout (supervision
+// timeout), ErrLocalHost (closed locally) or ErrMIC (MIC failure).
+func (c *Conn) DisconnectReason() error {
+	select {
+	case <-c.chDone:
+		return c.reason
+	default:
+		return nil
+	}
+}
+
 // Close disconnects the connection by sending hci disconnect command to the device.
 func (c *Conn) Close() error {
+	return c.disconnect(context.Background())
+}
+
+func (c *Conn) disconnect(ctx context.Context) error {
 	select {
 	case <-c.chDone:
 		// Return if it's already closed.
 		return nil
 	default:
-		c.hci.Send(&cmd.Disconnect{
+		c.hci.SendContext(ctx, &cmd.Disconnect{
 			ConnectionHandle: c.param.ConnectionHandle(),
 			Reason:           0x13,
 		}, nil)
@@ -293,7 +486,28 @@ func (c *Conn) Close() error {
 }
 
 // LocalAddr returns local device's MAC address.
-func (c *Conn) LocalAddr() ble.Addr { return c.hci.Addr() }
+func (c *Conn) LocalAddr() ble.Addr {
+	typ, a := c.localAddr()
+	if typ == 0x01 {
+		return RandomAddress{net.HardwareAddr([]byte{a[5], a[4], a[3], a[2], a[1], a[0]})}
+	}
+	return c.hci.addr
+}
+
+// localAddr returns the address type and the address, in HCI byte order, the
+// local device uses on the connection. A random address is the one set by
+// OptRandomAddress, as the host doesn't resolve private addresses of its own.
+func (c *Conn) localAddr() (uint8, [6]byte) {
+	if c.ownAddrType&0x01 != 0 && c.hci.randAddr != nil {
+		return 0x01, *c.hci.randAddr
+	}
+	var a [6]byte
+	b := c.hci.addr
+	for i := 0; i < 6 && i < len(b); i++ {
+		a[i] = b[len(b)-1-i]
+	}
+	return 0x00, a
+}
 
 // RemoteAddr returns remote device's MAC address.
 func (c *Conn) RemoteAddr() ble.Addr {
@@ -313,6 +527,18 @@ func (c *Conn) TxMTU() int { return c.txMTU }
 // SetTxMTU sets the MTU which the remote device is capable of accepting.
 func (c *Conn) SetTxMTU(mtu int) { c.txMTU = mtu }
 
+// SetTxQuota limits the number of controller's ACL buffers the connection can
+// occupy at a time. 0 means no limit other than its fair share.
+func (c *Conn) SetTxQuota(n int) { c.txBuffer.SetQuota(n) }
+
+// SetTxPriority sets the priority of the connection for the controller's ACL
+// buffers. Connections with higher priority are served first. Default is 0.
+func (c *Conn) SetTxPriority(prio int) { c.txBuffer.SetPriority(prio) }
+
+// TxStats returns the statistics of the connection's use of the controller's
+// ACL buffers.
+func (c *Conn) TxStats() TxStats { return c.txBuffer.Stats() }
+
 // pkt implements HCI ACL Data Packet [Vol 2, Part E, 5.4.2]
 // Packet boundary flags , bit[5:6] of handle field's MSB
 // Broadcast flags. bit[7:8] of handle field's MSB
@@ -0,0 +1,118 @@
+package hci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/currantlabs/ble"
+	"github.com/currantlabs/ble/linux/hci/evt"
+)
+
+// newFlowConns returns the HCI with Controller to Host flow control, and its
+// connections of the handles.
+func newFlowConns(t *testing.T, handles ...uint16) (*pipeSkt, []*Conn) {
+	h, err := NewHCI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	skt := &pipeSkt{memSkt{in: make(chan []byte)}, make(chan []byte, hostACLDataCnt)}
+	h.skt = skt
+	h.bufSize, h.bufCnt = 27, 8
+	h.pool = NewPool(1+4+h.bufSize, h.bufCnt)
+	h.hostFlow = true
+	h.spawn(h.sktLoop)
+	t.Cleanup(func() { close(skt.in) })
+
+	var cs []*Conn
+	for _, hdl := range handles {
+		cs = append(cs, h.addConn(evt.LEConnectionComplete{
+			evt.LEConnectionCompleteSubCode,
+			0x00,                        // Status
+			uint8(hdl), uint8(hdl >> 8), // Connection Handle
+			roleSlave,
+			0x00,                                     // Peer Address Type
+			uint8(hdl), 0x02, 0x03, 0x04, 0x05, 0x06, // Peer Address
+			0x18, 0x00, // Conn Interval
+			0x00, 0x00, // Conn Latency
+			0x48, 0x00, // Supervision Timeout
+			0x00, // Master Clock Accuracy
+		}))
+	}
+	return skt, cs
+}
+
+// attPkt returns an HCI ACL data packet of the handle carrying an ATT PDU.
+func attPkt(handle uint16) []byte {
+	return []byte{
+		pktTypeACLData,
+		uint8(handle), uint8(handle>>8) | pbfControllerToHostStart<<4,
+		0x05, 0x00, // ACL data length
+		0x01, 0x00, // L2CAP PDU length
+		uint8(cidLEAtt), 0x00, // L2CAP CID
+		0x1E, // Handle Value Confirmation
+	}
+}
+
+// completed returns the number of Host Number Of Completed Packets commands
+// sent, and the packets they have returned to the controller, by handle.
+func completed(skt *pipeSkt) (cmds, pkts map[uint16]int) {
+	cmds, pkts = make(map[uint16]int), make(map[uint16]int)
+	for {
+		select {
+		case b := <-skt.out:
+			if opcode(b) != 0x0C35 {
+				continue
+			}
+			h := uint16(b[5]) | uint16(b[6])<<8
+			cmds[h]++
+			pkts[h] += int(b[7]) | int(b[8])<<8
+		case <-time.After(50 * time.Millisecond):
+			return cmds, pkts
+		}
+	}
+}
+
+func TestConnRxShare(t *testing.T) {
+	skt, cs := newFlowConns(t, 0x0040, 0x0041)
+	a, b := cs[0], cs[1]
+	share := hostACLDataCnt / 2
+
+	// a doesn't read, and the PDUs beyond its share are dropped, with their
+	// host buffers returned right away.
+	for i := 0; i < share+8; i++ {
+		skt.in <- attPkt(0x0040)
+	}
+	if _, pkts := completed(skt); pkts[0x0040] != 8 {
+		t.Fatalf("%d dropped packets returned, want 8", pkts[0x0040])
+	}
+
+	// b still receives its data.
+	skt.in <- attPkt(0x0041)
+	buf := make([]byte, ble.MaxMTU)
+	if n, err := b.Read(buf); err != nil || n != 1 {
+		t.Fatalf("read %d bytes, %v", n, err)
+	}
+	if _, pkts := completed(skt); pkts[0x0041] != 1 {
+		t.Fatalf("%d packets of b returned, want 1", pkts[0x0041])
+	}
+
+	// The packets consumed by a are returned in batches.
+	for i := 0; i < share; i++ {
+		if _, err := a.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmds, pkts := completed(skt)
+	if pkts[0x0040] != share {
+		t.Errorf("%d packets of a returned, want %d", pkts[0x0040], share)
+	}
+	if max := share/hostACLDataBatch + 1; cmds[0x0040] > max {
+		t.Errorf("packets of a returned in %d commands, want at most %d", cmds[0x0040], max)
+	}
+
+	// a has room again.
+	skt.in <- attPkt(0x0040)
+	if _, err := a.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+}
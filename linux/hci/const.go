@@ -22,9 +22,43 @@ const (
 	cidLEAtt    uint16 = 0x04 // Attribute Protocol [Vol 3, Part F].
 	cidLESignal uint16 = 0x05 // Low Energy L2CAP Signaling channel [Vol 3, Part A, 4].
 	cidSMP      uint16 = 0x06 // SecurityManager Protocol [Vol 3, Part H].
+
+	cidDynamicFirst uint16 = 0x40 // First dynamically allocated CID.
+	cidDynamicLast  uint16 = 0x7F // Last dynamically allocated CID.
+)
+
+// Host buffers for ACL data advertised to the controller for Controller to Host
+// flow control [Vol 2, Part E, 4.2].
+const (
+	hostACLDataLen = 1021 // Maximum length of the data portion of an ACL data packet.
+	hostACLDataCnt = 64   // Total number of ACL data packets the host can hold.
+
+	// The consumed packets of a connection are returned to the controller in
+	// batches of up to hostACLDataBatch.
+	hostACLDataBatch = hostACLDataCnt / 4
+
+	// rxPktSize is the size of the buffers for received ACL data packets,
+	// including the ACL data header.
+	rxPktSize = 4 + hostACLDataLen
 )
 
 const (
 	roleMaster = 0x00
 	roleSlave  = 0x01
 )
+
+// LE Feature Support [Vol 6, Part B, 4.6]
+const (
+	leFeatureCodedPHY            = 1 << 11
+	leFeatureExtendedAdvertising = 1 << 12
+)
+
+// Advertising sets used when extended advertising is in use.
+const (
+	legacyAdvHandle   = 0x00 // Used by the legacy advertising API.
+	periodicAdvHandle = 0x01 // Used by periodic advertising.
+)
+
+// maxPeriodicAdvDataLen is the maximum length of periodic advertising data
+// [Vol 6, Part B, 2.3.4.9].
+const maxPeriodicAdvDataLen = 1650
@@ -0,0 +1,269 @@
+package hci
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Parameters of the channels in Enhanced Credit Based Flow Control Mode [Vol 3, Part A, 4.25].
+const (
+	ecredMinMTU   = 64 // Minimum MTU and MPS.
+	ecredMaxChans = 5  // Maximum number of channels in a request.
+)
+
+// DialCoCs requests n, up to five, channels in Enhanced Credit Based Flow
+// Control Mode to the psm of the remote device in a single Credit Based
+// Connection Request. [Vol 3, Part A, 4.25]
+// The remote device may refuse some of the channels, in which case fewer
+// channels are returned. An error is returned if all of them are refused.
+func (c *Conn) DialCoCs(ctx context.Context, psm uint16, n int) ([]*CoC, error) {
+	if psm == 0 || psm > 0x00FF {
+		return nil, ErrInvalidPSM
+	}
+	if n < 1 || n > ecredMaxChans {
+		return nil, errors.Errorf("invalid number of channels: %d", n)
+	}
+	chs := make([]*CoC, 0, n)
+	scids := make([]uint16, 0, n)
+	for i := 0; i < n; i++ {
+		ch, err := c.newCoC(psm, true)
+		if err != nil {
+			for _, ch := range chs {
+				c.removeCoC(ch)
+			}
+			return nil, err
+		}
+		chs = append(chs, ch)
+		scids = append(scids, ch.scid)
+	}
+
+	var rsp CreditBasedConnectionResponse
+	if err := c.signal(ctx, &CreditBasedConnectionRequest{
+		SPSM:           psm,
+		MTU:            cocRxMTU,
+		MPS:            cocRxMPS,
+		InitialCredits: cocRxCredits,
+		SourceCID:      scids,
+	}, &rsp); err != nil {
+		for _, ch := range chs {
+			c.removeCoC(ch)
+		}
+		return nil, errors.Wrap(err, "can't request channels")
+	}
+
+	// The refused channels have the Destination CID of 0x0000.
+	var established []*CoC
+	valid := rsp.MTU >= ecredMinMTU && rsp.MPS >= ecredMinMTU && rsp.MPS <= cocMaxMPS
+	for i, ch := range chs {
+		if i >= len(rsp.DestinationCID) || rsp.DestinationCID[i] == 0x0000 {
+			c.removeCoC(ch)
+			continue
+		}
+		dcid := rsp.DestinationCID[i]
+		valid = valid && dcid >= cidDynamicFirst && dcid <= cidDynamicLast
+		ch.establish(dcid, rsp.MTU, rsp.MPS)
+		established = append(established, ch)
+	}
+	if len(established) == 0 {
+		if rsp.Result == 0x0000 {
+			return nil, errors.New("no channel established")
+		}
+		return nil, CoCError(rsp.Result)
+	}
+
+	// The remote device considers the channels established. Disconnect them
+	// if the parameters are invalid.
+	if !valid {
+		for _, ch := range established {
+			ch.Close()
+		}
+		return nil, errors.New("invalid channel parameters")
+	}
+	for _, ch := range established {
+		ch.addCredits(int(rsp.InitialCredits))
+	}
+	return established, nil
+}
+
+// CreditBasedConnectionRequest implements Credit Based Connection Request (0x17) [Vol 3, Part A, 4.25].
+func (c *Conn) handleCreditBasedConnectionRequest(s sigCmd) {
+	var req CreditBasedConnectionRequest
+	err := req.Unmarshal(s.data())
+	n := len(req.SourceCID)
+	if n > ecredMaxChans {
+		req.SourceCID = req.SourceCID[:ecredMaxChans]
+	}
+
+	// Each of the requested channels has a Destination CID in the response,
+	// which is 0x0000 if the channel is refused.
+	rsp := &CreditBasedConnectionResponse{
+		DestinationCID: make([]uint16, len(req.SourceCID)),
+	}
+	respond := func(e CoCError) {
+		rsp.Result = uint16(e)
+		c.sendResponse(SignalCreditBasedConnectionResponse, s.id(), rsp)
+	}
+
+	if err != nil || len(s.data())%2 != 0 || n == 0 || n > ecredMaxChans {
+		respond(ErrCoCInvalidParams)
+		return
+	}
+	l := c.hci.cocListener(req.SPSM)
+	if l == nil {
+		respond(ErrCoCPSMNotSupported)
+		return
+	}
+	if req.MTU < ecredMinMTU || req.MPS < ecredMinMTU || req.MPS > cocMaxMPS {
+		respond(ErrCoCUnacceptableParams)
+		return
+	}
+
+	// Respond before passing the channels to the listener, so the remote device
+	// learns our CIDs before any K-frame we send on them.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var accepted []*CoC
+	result := CoCError(0x0000)
+	for i, scid := range req.SourceCID {
+		switch {
+		case scid < cidDynamicFirst || scid > cidDynamicLast:
+			result = ErrCoCInvalidSourceCID
+			continue
+		case c.cocByDCID(scid) != nil:
+			result = ErrCoCSourceCIDInUse
+			continue
+		case l.closed || len(l.chAccept)+len(accepted) >= cap(l.chAccept):
+			result = ErrCoCNoResources
+			continue
+		}
+		ch, err := c.newCoC(req.SPSM, true)
+		if err != nil {
+			result = ErrCoCNoResources
+			continue
+		}
+		ch.establish(scid, req.MTU, req.MPS)
+		ch.addCredits(int(req.InitialCredits))
+		rsp.DestinationCID[i] = ch.scid
+		accepted = append(accepted, ch)
+	}
+	if len(accepted) > 0 {
+		rsp.MTU, rsp.MPS, rsp.InitialCredits = cocRxMTU, cocRxMPS, cocRxCredits
+	}
+	respond(result)
+	for _, ch := range accepted {
+		l.chAccept <- ch
+	}
+}
+
+// ReconfigureCoCs sets the MTU and MPS we can receive on up to five channels in
+// Enhanced Credit Based Flow Control Mode. [Vol 3, Part A, 4.27]
+// The MTU of a channel can't be reduced, and the MPS can be reduced only if a
+// single channel is reconfigured.
+func (c *Conn) ReconfigureCoCs(ctx context.Context, mtu, mps int, chs ...*CoC) error {
+	if len(chs) == 0 || len(chs) > ecredMaxChans {
+		return errors.Errorf("invalid number of channels: %d", len(chs))
+	}
+	if mtu < ecredMinMTU || mtu > 0xFFFF || mps < ecredMinMTU || mps > cocMaxMPS {
+		return ErrReconfigureParams
+	}
+	for _, ch := range chs {
+		if ch.conn != c || !ch.ecred {
+			return errors.New("not an enhanced credit based channel of the connection")
+		}
+		ch.mu.Lock()
+		rxMTU, rxMPS := ch.rxMTU, ch.rxMPS
+		ch.mu.Unlock()
+		if mtu < rxMTU {
+			return ErrReconfigureMTU
+		}
+		if mps < rxMPS && len(chs) > 1 {
+			return ErrReconfigureMPS
+		}
+	}
+
+	// The remote device may send larger SDUs and K-frames as soon as it has
+	// responded, so accept them before the request. A reduced MPS takes effect
+	// after the response.
+	type prev struct{ mtu, mps int }
+	prevs := make([]prev, len(chs))
+	cids := make([]uint16, len(chs))
+	for i, ch := range chs {
+		ch.mu.Lock()
+		prevs[i] = prev{ch.rxMTU, ch.rxMPS}
+		ch.rxMTU = mtu
+		if mps > ch.rxMPS {
+			ch.rxMPS = mps
+		}
+		ch.mu.Unlock()
+		cids[i] = ch.scid
+	}
+
+	var rsp CreditBasedReconfigureResponse
+	err := c.signal(ctx, &CreditBasedReconfigureRequest{
+		MTU:            uint16(mtu),
+		MPS:            uint16(mps),
+		DestinationCID: cids,
+	}, &rsp)
+	if err == nil && rsp.Result != 0x0000 {
+		err = ReconfigureError(rsp.Result)
+	}
+	for i, ch := range chs {
+		ch.mu.Lock()
+		if err != nil {
+			ch.rxMTU, ch.rxMPS = prevs[i].mtu, prevs[i].mps
+		} else {
+			ch.rxMPS = mps
+		}
+		ch.mu.Unlock()
+	}
+	return err
+}
+
+// CreditBasedReconfigureRequest implements Credit Based Reconfigure Request (0x19) [Vol 3, Part A, 4.27].
+func (c *Conn) handleCreditBasedReconfigureRequest(s sigCmd) {
+	var req CreditBasedReconfigureRequest
+	err := req.Unmarshal(s.data())
+	var result ReconfigureError
+	switch {
+	case err != nil || len(s.data())%2 != 0 || len(req.DestinationCID) == 0 || len(req.DestinationCID) > ecredMaxChans:
+		result = ErrReconfigureParams
+	case req.MTU < ecredMinMTU || req.MPS < ecredMinMTU || req.MPS > cocMaxMPS:
+		result = ErrReconfigureParams
+	default:
+		result = c.reconfigureTx(&req)
+	}
+	c.sendResponse(
+		SignalCreditBasedReconfigureResponse,
+		s.id(),
+		&CreditBasedReconfigureResponse{Result: uint16(result)})
+}
+
+// reconfigureTx sets the MTU and MPS of the remote device on the channels, whose
+// endpoints on the remote device are listed in the request.
+func (c *Conn) reconfigureTx(req *CreditBasedReconfigureRequest) ReconfigureError {
+	chs := make([]*CoC, 0, len(req.DestinationCID))
+	for _, cid := range req.DestinationCID {
+		ch := c.cocByDCID(cid)
+		if ch == nil || !ch.ecred {
+			return ErrReconfigureCID
+		}
+		chs = append(chs, ch)
+	}
+	for _, ch := range chs {
+		ch.mu.Lock()
+		txMTU, txMPS := ch.txMTU, ch.txMPS
+		ch.mu.Unlock()
+		if int(req.MTU) < txMTU {
+			return ErrReconfigureMTU
+		}
+		if int(req.MPS) < txMPS && len(chs) > 1 {
+			return ErrReconfigureMPS
+		}
+	}
+	for _, ch := range chs {
+		ch.mu.Lock()
+		ch.txMTU, ch.txMPS = int(req.MTU), int(req.MPS)
+		ch.mu.Unlock()
+	}
+	return 0x0000
+}
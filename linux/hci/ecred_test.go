@@ -0,0 +1,151 @@
+package hci
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// dialCoCs establishes n channels in Enhanced Credit Based Flow Control Mode
+// from the master to the slave, and returns the ones the slave has accepted,
+// in the same order.
+func dialCoCs(t *testing.T, p *smpPipe, l *CoCListener, n int) (mchs, schs []*CoC) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	mchs, err := p.mc.DialCoCs(ctx, testPSM, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range mchs {
+		sch, err := l.AcceptCoC()
+		if err != nil {
+			t.Fatal(err)
+		}
+		schs = append(schs, sch)
+	}
+	for i, mch := range mchs {
+		if schs[i].dcid != mch.scid || !schs[i].ecred {
+			t.Fatalf("channel %04X accepted as %04X", mch.scid, schs[i].dcid)
+		}
+	}
+	return mchs, schs
+}
+
+func TestCoCsPartialRefusal(t *testing.T) {
+	p, l := newCoCPipe(t)
+
+	// The listener holds up to eight channels before they're accepted.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if chs, err := p.mc.DialCoCs(ctx, testPSM, 5); err != nil || len(chs) != 5 {
+		t.Fatalf("dialed %d channels, %v, want 5", len(chs), err)
+	}
+	chs, err := p.mc.DialCoCs(ctx, testPSM, 5)
+	if err != nil || len(chs) != cocAcceptQueueLen-5 {
+		t.Fatalf("dialed %d channels, %v, want %d", len(chs), err, cocAcceptQueueLen-5)
+	}
+	if _, err := p.mc.DialCoCs(ctx, testPSM, 1); err != ErrCoCNoResources {
+		t.Fatalf("dial: %v, want %v", err, ErrCoCNoResources)
+	}
+	p.mc.cocMu.Lock()
+	n := len(p.mc.cocs)
+	p.mc.cocMu.Unlock()
+	if n != cocAcceptQueueLen {
+		t.Fatalf("%d channels registered, want the %d established", n, cocAcceptQueueLen)
+	}
+
+	// The channels established are usable.
+	var sch *CoC
+	for i := 0; i < cocAcceptQueueLen; i++ {
+		if sch, err = l.AcceptCoC(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sch.dcid != chs[len(chs)-1].scid {
+		t.Fatalf("channel %04X accepted last, want %04X", sch.dcid, chs[len(chs)-1].scid)
+	}
+	if _, err := chs[len(chs)-1].Write([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 3)
+	sch.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(sch, b); err != nil || !bytes.Equal(b, []byte{1, 2, 3}) {
+		t.Fatalf("read %X, %v", b, err)
+	}
+}
+
+func TestReconfigureCoCs(t *testing.T) {
+	p, l := newCoCPipe(t)
+	mchs, schs := dialCoCs(t, p, l, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The MTU can't be reduced, nor the MPS of more than one channel, which is
+	// checked before the request is sent.
+	if err := p.mc.ReconfigureCoCs(ctx, cocRxMTU-1, cocRxMPS, mchs...); err != ErrReconfigureMTU {
+		t.Fatalf("MTU reduced: %v, want %v", err, ErrReconfigureMTU)
+	}
+	if err := p.mc.ReconfigureCoCs(ctx, cocRxMTU, cocRxMPS-1, mchs...); err != ErrReconfigureMPS {
+		t.Fatalf("MPS of two channels reduced: %v, want %v", err, ErrReconfigureMPS)
+	}
+
+	// The master refuses the request of the slave reducing its MTU.
+	var rsp CreditBasedReconfigureResponse
+	if err := p.sc.signal(ctx, &CreditBasedReconfigureRequest{
+		MTU:            cocRxMTU - 1,
+		MPS:            cocRxMPS,
+		DestinationCID: []uint16{schs[0].scid},
+	}, &rsp); err != nil {
+		t.Fatal(err)
+	}
+	if e := ReconfigureError(rsp.Result); e != ErrReconfigureMTU {
+		t.Fatalf("result = %v, want %v", e, ErrReconfigureMTU)
+	}
+	if mtu := mchs[0].TxMTU(); mtu != cocRxMTU {
+		t.Fatalf("tx MTU = %d after refused reconfiguration, want %d", mtu, cocRxMTU)
+	}
+
+	// An SDU of the increased MTU is received in a single read.
+	if err := p.mc.ReconfigureCoCs(ctx, 2*cocRxMTU, cocRxMPS, mchs...); err != nil {
+		t.Fatal(err)
+	}
+	for i, sch := range schs {
+		if mtu := sch.TxMTU(); mtu != 2*cocRxMTU {
+			t.Fatalf("tx MTU of channel %d = %d, want %d", i, mtu, 2*cocRxMTU)
+		}
+	}
+	b := pattern(2 * cocRxMTU)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := schs[1].Write(b)
+		errc <- err
+	}()
+	got := make([]byte, 2*cocRxMTU+1)
+	mchs[1].SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := mchs[1].Read(got); err != nil || !bytes.Equal(got[:n], b) {
+		t.Fatalf("read %d octets, %v, want %d", n, err, len(b))
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// The MPS of a single channel can be reduced, and the slave segments the
+	// SDUs into the smaller K-frames.
+	if err := p.mc.ReconfigureCoCs(ctx, 2*cocRxMTU, ecredMinMTU, mchs[0]); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, err := schs[0].Write(b[:cocRxMPS])
+		errc <- err
+	}()
+	mchs[0].SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := mchs[0].Read(got); err != nil || !bytes.Equal(got[:n], b[:cocRxMPS]) {
+		t.Fatalf("read %d octets, %v, want %d", n, err, cocRxMPS)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
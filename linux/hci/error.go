@@ -1,6 +1,11 @@
 package hci
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/currantlabs/ble"
+)
 
 // errors
 var (
@@ -9,8 +14,47 @@ var (
 	ErrBusyDialing     = errors.New("busy dialing")
 	ErrBusyListening   = errors.New("busy listening")
 	ErrInvalidAddr     = errors.New("invalid address")
+
+	ErrExtendedNotSupported = errors.New("extended advertising not supported")
+	ErrExtendedNotEnabled   = errors.New("extended advertising not enabled")
+	ErrAdvDataTooLong       = errors.New("advertising data too long")
+	ErrSyncLost             = errors.New("periodic advertising sync lost")
+
+	ErrInvalidPSM = errors.New("invalid LE_PSM")
+	ErrPSMInUse   = errors.New("LE_PSM already in use")
+
+	ErrInvalidCID     = errors.New("invalid fixed channel CID")
+	ErrReservedCID    = errors.New("fixed channel reserved by the host")
+	ErrPayloadTooLong = errors.New("payload too long")
+
+	ErrSignalTimeout = errors.New("signaling request timed out")
+
+	ErrPairingTimeout = errors.New("pairing timed out")
+	ErrNoKeyStore     = errors.New("no key store")
+
+	ErrSecurityLevel = errors.New("insufficient security level")
+
+	ErrNoCSRK    = errors.New("no CSRK")
+	ErrSignature = errors.New("invalid signature")
+
+	ErrInvalidOOBData = errors.New("invalid OOB data")
 )
 
+// A ConnectionError is returned by Dial when the controller reports that the
+// connection couldn't be established. It wraps the ErrCommand reported, so it
+// can be matched with errors.Is, e.g. errors.Is(err, ErrEstablished).
+type ConnectionError struct {
+	Addr   ble.Addr
+	Status ErrCommand
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("can't connect to %s: %s", e.Addr, e.Status)
+}
+
+// Unwrap returns the ErrCommand reported by the controller.
+func (e *ConnectionError) Unwrap() error { return e.Status }
+
 // HCI Command Errors  [Vol2, Part D, 1.3 ]
 // FIXME: Terrible shorthand. Name them properly.
 const (
@@ -75,6 +119,10 @@ const (
 	ErrEstablished          ErrCommand = 0x3E // Connection Failed to be Established
 	ErrMACConn              ErrCommand = 0x3F // MAC Connection Failed
 	ErrCoarseClock          ErrCommand = 0x40 // Coarse Clock Adjustment Rejected but Will Try to Adjust Using Clock Dragging
+	ErrType0Submap          ErrCommand = 0x41 // Type0 Submap Not Defined
+	ErrUnknownAdvID         ErrCommand = 0x42 // Unknown Advertising Identifier
+	ErrLimitReached         ErrCommand = 0x43 // Limit Reached
+	ErrCanceledByHost       ErrCommand = 0x44 // Operation Cancelled by Host
 	// 0x2B // Reserved
 	// 0x31 // Reserved
 	// 0x33 // Reserved
@@ -158,4 +206,153 @@ var errCmd = map[ErrCommand]string{
 	0x3E: "Connection Failed to be Established",
 	0x3F: "MAC Connection Failed",
 	0x40: "Coarse Clock Adjustment Rejected but Will Try to Adjust Using Clock Dragging",
+	0x41: "Type0 Submap Not Defined",
+	0x42: "Unknown Advertising Identifier",
+	0x43: "Limit Reached",
+	0x44: "Operation Cancelled by Host",
+}
+
+// RejectReason is the reason of a Command Reject. [Vol 3, Part A, 4.1]
+type RejectReason uint16
+
+// Command Reject reasons [Vol 3, Part A, 4.1]
+const (
+	RejectNotUnderstood RejectReason = 0x0000 // Command not understood
+	RejectMTUExceeded   RejectReason = 0x0001 // Signaling MTU exceeded
+	RejectInvalidCID    RejectReason = 0x0002 // Invalid CID in request
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectNotUnderstood:
+		return "command not understood"
+	case RejectMTUExceeded:
+		return "signaling MTU exceeded"
+	case RejectInvalidCID:
+		return "invalid CID in request"
+	}
+	return fmt.Sprintf("reason 0x%04X", uint16(r))
+}
+
+// A RejectError is returned when the remote device responds a signaling request
+// with a Command Reject. Data is the reason specific data, which is the actual
+// signaling MTU for RejectMTUExceeded, or the local and remote endpoints of the
+// channel for RejectInvalidCID.
+type RejectError struct {
+	Reason RejectReason
+	Data   []byte
+}
+
+func (e *RejectError) Error() string {
+	return "signaling request rejected - " + e.Reason.String()
+}
+
+// CoCError is the result of a refused LE Credit Based Connection Request, or
+// Credit Based Connection Request. [Vol 3, Part A, 4.23, 4.26]
+type CoCError uint16
+
+// LE Credit Based Connection Response results [Vol 3, Part A, 4.23]
+const (
+	ErrCoCPSMNotSupported    CoCError = 0x0002 // LE_PSM not supported
+	ErrCoCNoResources        CoCError = 0x0004 // No resources available
+	ErrCoCAuthentication     CoCError = 0x0005 // Insufficient Authentication
+	ErrCoCAuthorization      CoCError = 0x0006 // Insufficient Authorization
+	ErrCoCEncKeySize         CoCError = 0x0007 // Insufficient Encryption Key Size
+	ErrCoCEncryption         CoCError = 0x0008 // Insufficient Encryption
+	ErrCoCInvalidSourceCID   CoCError = 0x0009 // Invalid Source CID
+	ErrCoCSourceCIDInUse     CoCError = 0x000A // Source CID already allocated
+	ErrCoCUnacceptableParams CoCError = 0x000B // Unacceptable parameters
+	ErrCoCInvalidParams      CoCError = 0x000C // Invalid parameters
+)
+
+func (e CoCError) Error() string {
+	if s, ok := errCoC[e]; ok {
+		return "connection refused - " + s
+	}
+	return fmt.Sprintf("connection refused - result 0x%04X", uint16(e))
+}
+
+var errCoC = map[CoCError]string{
+	0x0002: "LE_PSM not supported",
+	0x0004: "no resources available",
+	0x0005: "insufficient authentication",
+	0x0006: "insufficient authorization",
+	0x0007: "insufficient encryption key size",
+	0x0008: "insufficient encryption",
+	0x0009: "invalid Source CID",
+	0x000A: "Source CID already allocated",
+	0x000B: "unacceptable parameters",
+	0x000C: "invalid parameters",
+}
+
+// ReconfigureError is the result of a failed Credit Based Reconfigure Request.
+// [Vol 3, Part A, 4.28]
+type ReconfigureError uint16
+
+// Credit Based Reconfigure Response results [Vol 3, Part A, 4.28]
+const (
+	ErrReconfigureMTU    ReconfigureError = 0x0001 // Reduction in size of MTU not allowed
+	ErrReconfigureMPS    ReconfigureError = 0x0002 // Reduction in size of MPS not allowed for more than one channel at a time
+	ErrReconfigureCID    ReconfigureError = 0x0003 // One or more Destination CIDs invalid
+	ErrReconfigureParams ReconfigureError = 0x0004 // Other unacceptable parameters
+)
+
+func (e ReconfigureError) Error() string {
+	if s, ok := errReconfigure[e]; ok {
+		return "reconfiguration failed - " + s
+	}
+	return fmt.Sprintf("reconfiguration failed - result 0x%04X", uint16(e))
+}
+
+var errReconfigure = map[ReconfigureError]string{
+	0x0001: "reduction in size of MTU not allowed",
+	0x0002: "reduction in size of MPS not allowed for more than one channel at a time",
+	0x0003: "one or more Destination CIDs invalid",
+	0x0004: "other unacceptable parameters",
+}
+
+// PairingError is the reason of a failed pairing, which is sent or received in
+// a Pairing Failed command. [Vol 3, Part H, 3.5.5]
+type PairingError uint8
+
+// Pairing Failed reasons [Vol 3, Part H, 3.5.5]
+const (
+	ErrPairingPasskeyEntry        PairingError = 0x01 // Passkey Entry Failed
+	ErrPairingOOBNotAvailable     PairingError = 0x02 // OOB Not Available
+	ErrPairingAuthRequirements    PairingError = 0x03 // Authentication Requirements
+	ErrPairingConfirmValue        PairingError = 0x04 // Confirm Value Failed
+	ErrPairingNotSupported        PairingError = 0x05 // Pairing Not Supported
+	ErrPairingEncKeySize          PairingError = 0x06 // Encryption Key Size
+	ErrPairingCommandNotSupported PairingError = 0x07 // Command Not Supported
+	ErrPairingUnspecified         PairingError = 0x08 // Unspecified Reason
+	ErrPairingRepeatedAttempts    PairingError = 0x09 // Repeated Attempts
+	ErrPairingInvalidParams       PairingError = 0x0A // Invalid Parameters
+	ErrPairingDHKeyCheck          PairingError = 0x0B // DHKey Check Failed
+	ErrPairingNumericComparison   PairingError = 0x0C // Numeric Comparison Failed
+	ErrPairingBREDRInProgress     PairingError = 0x0D // BR/EDR pairing in progress
+	ErrPairingCrossTransport      PairingError = 0x0E // Cross-transport Key Derivation/Generation not allowed
+)
+
+func (e PairingError) Error() string {
+	if s, ok := errPairing[e]; ok {
+		return "pairing failed - " + s
+	}
+	return fmt.Sprintf("pairing failed - reason 0x%02X", uint8(e))
+}
+
+var errPairing = map[PairingError]string{
+	0x01: "passkey entry failed",
+	0x02: "OOB not available",
+	0x03: "authentication requirements",
+	0x04: "confirm value failed",
+	0x05: "pairing not supported",
+	0x06: "encryption key size",
+	0x07: "command not supported",
+	0x08: "unspecified reason",
+	0x09: "repeated attempts",
+	0x0A: "invalid parameters",
+	0x0B: "DHKey check failed",
+	0x0C: "numeric comparison failed",
+	0x0D: "BR/EDR pairing in progress",
+	0x0E: "cross-transport key derivation/generation not allowed",
 }
@@ -53,3 +53,66 @@ func (e LEAdvertisingReport) RSSI(i int) int8 {
 	}
 	return int8(e[2+int(e.NumReports())*9+l+i])
 }
+
+// Unlike the LE Advertising Report, the parameters of each report in the
+// LE Extended Advertising Report are placed contiguously. [Vol 2, Part E, 7.7.65.13]
+//
+//     EventType(2), AddressType(1), Address(6), PrimaryPHY(1), SecondaryPHY(1),
+//     AdvertisingSID(1), TxPower(1), RSSI(1), PeriodicAdvertisingInterval(2),
+//     DirectAddressType(1), DirectAddress(6), DataLength(1), Data(DataLength)
+
+func (e LEExtendedAdvertisingReport) SubeventCode() uint8 { return e[0] }
+func (e LEExtendedAdvertisingReport) NumReports() uint8   { return e[1] }
+
+// report returns the i-th report of the event.
+func (e LEExtendedAdvertisingReport) report(i int) []byte {
+	b := []byte(e[2:])
+	for j := 0; j < i; j++ {
+		b = b[24+int(b[23]):]
+	}
+	return b
+}
+
+func (e LEExtendedAdvertisingReport) EventType(i int) uint16 {
+	return binary.LittleEndian.Uint16(e.report(i))
+}
+func (e LEExtendedAdvertisingReport) AddressType(i int) uint8 { return e.report(i)[2] }
+func (e LEExtendedAdvertisingReport) Address(i int) [6]byte {
+	b := [6]byte{}
+	copy(b[:], e.report(i)[3:])
+	return b
+}
+func (e LEExtendedAdvertisingReport) PrimaryPHY(i int) uint8     { return e.report(i)[9] }
+func (e LEExtendedAdvertisingReport) SecondaryPHY(i int) uint8   { return e.report(i)[10] }
+func (e LEExtendedAdvertisingReport) AdvertisingSID(i int) uint8 { return e.report(i)[11] }
+func (e LEExtendedAdvertisingReport) TxPower(i int) int8         { return int8(e.report(i)[12]) }
+func (e LEExtendedAdvertisingReport) RSSI(i int) int8            { return int8(e.report(i)[13]) }
+func (e LEExtendedAdvertisingReport) PeriodicAdvertisingInterval(i int) uint16 {
+	return binary.LittleEndian.Uint16(e.report(i)[14:])
+}
+func (e LEExtendedAdvertisingReport) DirectAddressType(i int) uint8 { return e.report(i)[16] }
+func (e LEExtendedAdvertisingReport) DirectAddress(i int) [6]byte {
+	b := [6]byte{}
+	copy(b[:], e.report(i)[17:])
+	return b
+}
+func (e LEExtendedAdvertisingReport) DataLength(i int) uint8 { return e.report(i)[23] }
+func (e LEExtendedAdvertisingReport) Data(i int) []byte {
+	b := e.report(i)
+	return b[24 : 24+int(b[23])]
+}
+
+// Valid reports whether the event is long enough to hold all of its reports.
+func (e LEExtendedAdvertisingReport) Valid() bool {
+	if len(e) < 2 {
+		return false
+	}
+	b := []byte(e[2:])
+	for j := 0; j < int(e.NumReports()); j++ {
+		if len(b) < 24 || len(b) < 24+int(b[23]) {
+			return false
+		}
+		b = b[24+int(b[23]):]
+	}
+	return true
+}
@@ -225,3 +225,77 @@ type AuthenticatedPayloadTimeoutExpired []byte
 func (r AuthenticatedPayloadTimeoutExpired) ConnectionHandle() uint16 {
 	return binary.LittleEndian.Uint16(r[0:])
 }
+
+const LEExtendedAdvertisingReportCode = 0x3E
+
+const LEExtendedAdvertisingReportSubCode = 0x0D
+
+// LEExtendedAdvertisingReport implements LE Extended Advertising Report (0x3E:0x0D) [Vol 2, Part E, 7.7.65.13].
+type LEExtendedAdvertisingReport []byte
+
+const LEPeriodicAdvertisingSyncEstablishedCode = 0x3E
+
+const LEPeriodicAdvertisingSyncEstablishedSubCode = 0x0E
+
+// LEPeriodicAdvertisingSyncEstablished implements LE Periodic Advertising Sync Established (0x3E:0x0E) [Vol 2, Part E, 7.7.65.14].
+type LEPeriodicAdvertisingSyncEstablished []byte
+
+func (r LEPeriodicAdvertisingSyncEstablished) SubeventCode() uint8 { return r[0] }
+
+func (r LEPeriodicAdvertisingSyncEstablished) Status() uint8 { return r[1] }
+
+func (r LEPeriodicAdvertisingSyncEstablished) SyncHandle() uint16 {
+	return binary.LittleEndian.Uint16(r[2:])
+}
+
+func (r LEPeriodicAdvertisingSyncEstablished) AdvertisingSID() uint8 { return r[4] }
+
+func (r LEPeriodicAdvertisingSyncEstablished) AdvertiserAddressType() uint8 { return r[5] }
+
+func (r LEPeriodicAdvertisingSyncEstablished) AdvertiserAddress() [6]byte {
+	b := [6]byte{}
+	copy(b[:], r[6:])
+	return b
+}
+
+func (r LEPeriodicAdvertisingSyncEstablished) AdvertiserPHY() uint8 { return r[12] }
+
+func (r LEPeriodicAdvertisingSyncEstablished) PeriodicAdvertisingInterval() uint16 {
+	return binary.LittleEndian.Uint16(r[13:])
+}
+
+func (r LEPeriodicAdvertisingSyncEstablished) AdvertiserClockAccuracy() uint8 { return r[15] }
+
+const LEPeriodicAdvertisingReportCode = 0x3E
+
+const LEPeriodicAdvertisingReportSubCode = 0x0F
+
+// LEPeriodicAdvertisingReport implements LE Periodic Advertising Report (0x3E:0x0F) [Vol 2, Part E, 7.7.65.15].
+type LEPeriodicAdvertisingReport []byte
+
+func (r LEPeriodicAdvertisingReport) SubeventCode() uint8 { return r[0] }
+
+func (r LEPeriodicAdvertisingReport) SyncHandle() uint16 { return binary.LittleEndian.Uint16(r[1:]) }
+
+func (r LEPeriodicAdvertisingReport) TxPower() int8 { return int8(r[3]) }
+
+func (r LEPeriodicAdvertisingReport) RSSI() int8 { return int8(r[4]) }
+
+func (r LEPeriodicAdvertisingReport) CTEType() uint8 { return r[5] }
+
+func (r LEPeriodicAdvertisingReport) DataStatus() uint8 { return r[6] }
+
+func (r LEPeriodicAdvertisingReport) DataLength() uint8 { return r[7] }
+
+func (r LEPeriodicAdvertisingReport) Data() []byte { return r[8:] }
+
+const LEPeriodicAdvertisingSyncLostCode = 0x3E
+
+const LEPeriodicAdvertisingSyncLostSubCode = 0x10
+
+// LEPeriodicAdvertisingSyncLost implements LE Periodic Advertising Sync Lost (0x3E:0x10) [Vol 2, Part E, 7.7.65.16].
+type LEPeriodicAdvertisingSyncLost []byte
+
+func (r LEPeriodicAdvertisingSyncLost) SubeventCode() uint8 { return r[0] }
+
+func (r LEPeriodicAdvertisingSyncLost) SyncHandle() uint16 { return binary.LittleEndian.Uint16(r[1:]) }
@@ -0,0 +1,85 @@
+package hci
+
+// Fixed channels of LE-U, which are used by the upper layers other than ATT,
+// the LE signaling channel and SMP [Vol 3, Part A, 2.1].
+const (
+	cidFixedFirst uint16 = 0x01
+	cidFixedLast  uint16 = 0x3F
+)
+
+// A FixedChannelHandler handles the information payload of the PDUs received
+// on a fixed channel. It's called on the goroutine receiving the data of the
+// connection, so it shouldn't block. The payload is only valid until it returns.
+type FixedChannelHandler func(c *Conn, payload []byte)
+
+// checkFixedCID returns an error if the cid isn't a fixed channel users can
+// handle or send PDUs on.
+func checkFixedCID(cid uint16) error {
+	switch {
+	case cid == cidLEAtt || cid == cidLESignal || cid == cidSMP:
+		return ErrReservedCID
+	case cid < cidFixedFirst || cid > cidFixedLast:
+		return ErrInvalidCID
+	}
+	return nil
+}
+
+// HandleFixed registers the handler for the PDUs received on the fixed channel
+// cid of all the connections, unless a connection has one of its own.
+// A nil handler unregisters the current one.
+func (h *HCI) HandleFixed(cid uint16, fh FixedChannelHandler) error {
+	if err := checkFixedCID(cid); err != nil {
+		return err
+	}
+	h.muFixed.Lock()
+	defer h.muFixed.Unlock()
+	if fh == nil {
+		delete(h.fixed, cid)
+		return nil
+	}
+	h.fixed[cid] = fh
+	return nil
+}
+
+// HandleFixed registers the handler for the PDUs received on the fixed channel
+// cid of the connection. It takes precedence over the one registered on HCI.
+// A nil handler unregisters the current one.
+func (c *Conn) HandleFixed(cid uint16, fh FixedChannelHandler) error {
+	if err := checkFixedCID(cid); err != nil {
+		return err
+	}
+	c.fixedMu.Lock()
+	defer c.fixedMu.Unlock()
+	if fh == nil {
+		delete(c.fixed, cid)
+		return nil
+	}
+	c.fixed[cid] = fh
+	return nil
+}
+
+// fixedHandler returns the handler of the fixed channel cid, or nil if there
+// isn't one.
+func (c *Conn) fixedHandler(cid uint16) FixedChannelHandler {
+	c.fixedMu.Lock()
+	fh := c.fixed[cid]
+	c.fixedMu.Unlock()
+	if fh != nil {
+		return fh
+	}
+	c.hci.muFixed.Lock()
+	defer c.hci.muFixed.Unlock()
+	return c.hci.fixed[cid]
+}
+
+// WriteFixed sends a PDU on the fixed channel cid, with payload as its
+// information payload. It returns the number of payload bytes sent.
+func (c *Conn) WriteFixed(cid uint16, payload []byte) (int, error) {
+	if err := checkFixedCID(cid); err != nil {
+		return 0, err
+	}
+	if len(payload) > 0xFFFF {
+		return 0, ErrPayloadTooLong
+	}
+	return c.writePDU(cid, payload)
+}
@@ -0,0 +1,86 @@
+package hci
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+const testFixedCID = 0x003E
+
+// recvFixed returns a handler, which passes copies of the payloads to ch.
+func recvFixed(ch chan []byte) FixedChannelHandler {
+	return func(c *Conn, payload []byte) {
+		ch <- append([]byte(nil), payload...)
+	}
+}
+
+func TestFixedChannel(t *testing.T) {
+	p := newSMPPipe(t, nil, nil, nil)
+	for _, cid := range []uint16{cidLEAtt, cidLESignal, cidSMP} {
+		if err := p.sc.HandleFixed(cid, recvFixed(nil)); err != ErrReservedCID {
+			t.Errorf("handle 0x%04X: %v, want %v", cid, err, ErrReservedCID)
+		}
+		if _, err := p.mc.WriteFixed(cid, nil); err != ErrReservedCID {
+			t.Errorf("write 0x%04X: %v, want %v", cid, err, ErrReservedCID)
+		}
+	}
+	for _, cid := range []uint16{0x0000, cidFixedLast + 1, cidDynamicFirst} {
+		if err := p.sc.hci.HandleFixed(cid, recvFixed(nil)); err != ErrInvalidCID {
+			t.Errorf("handle 0x%04X: %v, want %v", cid, err, ErrInvalidCID)
+		}
+		if _, err := p.mc.WriteFixed(cid, nil); err != ErrInvalidCID {
+			t.Errorf("write 0x%04X: %v, want %v", cid, err, ErrInvalidCID)
+		}
+	}
+	if _, err := p.mc.WriteFixed(testFixedCID, make([]byte, 0x10000)); err != ErrPayloadTooLong {
+		t.Errorf("write: %v, want %v", err, ErrPayloadTooLong)
+	}
+
+	recv := func(ch chan []byte, want []byte) {
+		t.Helper()
+		select {
+		case b := <-ch:
+			if !bytes.Equal(b, want) {
+				t.Fatalf("received %X, want %X", b, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("payload not received")
+		}
+	}
+
+	// The payloads are reassembled from the ACL fragments.
+	all, own := make(chan []byte, 1), make(chan []byte, 1)
+	if err := p.sc.hci.HandleFixed(testFixedCID, recvFixed(all)); err != nil {
+		t.Fatal(err)
+	}
+	b := pattern(100)
+	if n, err := p.mc.WriteFixed(testFixedCID, b); err != nil || n != len(b) {
+		t.Fatalf("wrote %d octets, %v, want %d", n, err, len(b))
+	}
+	recv(all, b)
+
+	// The handler of the connection takes precedence over the one of HCI,
+	// until it's unregistered.
+	if err := p.sc.HandleFixed(testFixedCID, recvFixed(own)); err != nil {
+		t.Fatal(err)
+	}
+	p.mc.WriteFixed(testFixedCID, []byte{1})
+	recv(own, []byte{1})
+	if err := p.sc.HandleFixed(testFixedCID, nil); err != nil {
+		t.Fatal(err)
+	}
+	p.mc.WriteFixed(testFixedCID, []byte{2})
+	recv(all, []byte{2})
+
+	// An empty payload is delivered too.
+	p.mc.WriteFixed(testFixedCID, nil)
+	recv(all, []byte{})
+
+	if err := p.sc.hci.HandleFixed(testFixedCID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fh := p.sc.fixedHandler(testFixedCID); fh != nil {
+		t.Fatal("handler not unregistered")
+	}
+}
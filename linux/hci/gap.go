@@ -10,11 +10,31 @@ import (
 	"github.com/currantlabs/ble"
 	"github.com/currantlabs/ble/linux/adv"
 	"github.com/currantlabs/ble/linux/gatt"
+	"github.com/currantlabs/ble/linux/hci/cmd"
+	"github.com/currantlabs/ble/linux/hci/evt"
 	"github.com/pkg/errors"
 )
 
-// Addr ...
-func (h *HCI) Addr() ble.Addr { return h.addr }
+// Addr returns the address of the device, which is a RandomAddress if it
+// uses the static random address set by OptRandomAddress.
+func (h *HCI) Addr() ble.Addr {
+	if a := h.randAddr; a != nil {
+		return RandomAddress{net.HardwareAddr([]byte{a[5], a[4], a[3], a[2], a[1], a[0]})}
+	}
+	return h.addr
+}
+
+// setAdvSetRandomAddress sets the random address of the advertising set, which
+// in extended mode is set per set, if the device uses one.
+func (h *HCI) setAdvSetRandomAddress(handle uint8) error {
+	if h.randAddr == nil {
+		return nil
+	}
+	return h.Send(&cmd.LESetAdvertisingSetRandomAddress{
+		AdvertisingHandle: handle,
+		RandomAddress:     *h.randAddr,
+	}, nil)
+}
 
 // SetAdvHandler ...
 func (h *HCI) SetAdvHandler(ah ble.AdvHandler) error {
@@ -24,6 +44,12 @@ func (h *HCI) SetAdvHandler(ah ble.AdvHandler) error {
 
 // Scan starts scanning.
 func (h *HCI) Scan(allowDup bool) error {
+	return h.ScanContext(context.Background(), allowDup)
+}
+
+// ScanContext starts scanning. It returns ctx.Err() if ctx is done before the
+// controller responds.
+func (h *HCI) ScanContext(ctx context.Context, allowDup bool) error {
 	h.params.scanEnable.FilterDuplicates = 1
 	if allowDup {
 		h.params.scanEnable.FilterDuplicates = 0
@@ -31,13 +57,26 @@ func (h *HCI) Scan(allowDup bool) error {
 	h.params.scanEnable.LEScanEnable = 1
 	h.adHist = make([]*Advertisement, 128)
 	h.adLast = 0
-	return h.Send(&h.params.scanEnable, nil)
+	if h.extended {
+		h.extFrags = make(map[extAdvKey]*ExtendedAdvertisement)
+		h.extHist = make([]*ExtendedAdvertisement, 128)
+		h.extLast = 0
+		return h.SendContext(ctx, h.params.extScanEnable(), nil)
+	}
+	return h.SendContext(ctx, &h.params.scanEnable, nil)
 }
 
 // StopScanning stops scanning.
 func (h *HCI) StopScanning() error {
+	return h.stopScanning(context.Background())
+}
+
+func (h *HCI) stopScanning(ctx context.Context) error {
 	h.params.scanEnable.LEScanEnable = 0
-	return h.Send(&h.params.scanEnable, nil)
+	if h.extended {
+		return h.SendContext(ctx, h.params.extScanEnable(), nil)
+	}
+	return h.SendContext(ctx, &h.params.scanEnable, nil)
 }
 
 // AdvertiseNameAndServices advertises device name, and specified service UUIDs.
@@ -128,8 +167,12 @@ func (h *HCI) AdvertiseIBeacon(u ble.UUID, major, minor uint16, pwr int8) error
 
 // StopAdvertising stops advertising.
 func (h *HCI) StopAdvertising() error {
+	return h.stopAdvertising(context.Background())
+}
+
+func (h *HCI) stopAdvertising(ctx context.Context) error {
 	h.params.advEnable.AdvertisingEnable = 0
-	return h.Send(&h.params.advEnable, nil)
+	return h.SendContext(ctx, h.advEnableCmd(), nil)
 }
 
 // Accept starts advertising and accepts connection.
@@ -140,7 +183,7 @@ func (h *HCI) Accept() (ble.Conn, error) {
 	}
 	select {
 	case <-h.done:
-		return nil, h.err
+		return nil, h.Error()
 	case c := <-h.chSlaveConn:
 		return c, nil
 	case <-tmo:
@@ -148,17 +191,74 @@ func (h *HCI) Accept() (ble.Conn, error) {
 	}
 }
 
-// Dial ...
+// dial is a pending Dial, waiting for its connection.
+type dial struct {
+	addr   [6]byte
+	any    bool // The white list is used, and any peer is accepted.
+	chConn chan *Conn
+	err    ErrCommand // Set when chConn receives nil.
+
+	// canceled is set when the dial cancels its connection, and is guarded by
+	// muConns. Otherwise, a cancellation reported to it is a stale one.
+	canceled bool
+}
+
+// match reports whether the LE Connection Complete event e was requested by d.
+func (d *dial) match(e evt.LEConnectionComplete) bool {
+	return d.any || e.PeerAddress() == d.addr
+}
+
+// Dial connects to the peripheral a. It is safe for concurrent use; since the
+// controller allows only one pending connection, the dials are queued, and
+// the time spent waiting in the queue counts against ctx as well.
 func (h *HCI) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
-	b, err := net.ParseMAC(a.String())
+	addr, typ, err := peerAddr(a)
 	if err != nil {
-		return nil, ErrInvalidAddr
+		return nil, err
 	}
-	h.params.connParams.PeerAddress = [6]byte{b[5], b[4], b[3], b[2], b[1], b[0]}
-	if _, ok := a.(RandomAddress); ok {
-		h.params.connParams.PeerAddressType = 1
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.done:
+		return nil, h.Error()
+	case h.chDialSem <- struct{}{}:
 	}
-	if err = h.Send(&h.params.connParams, nil); err != nil {
+	defer func() { <-h.chDialSem }()
+
+	p := h.params.connParams
+	p.PeerAddress = addr
+	p.PeerAddressType = typ
+	d := &dial{
+		addr:   addr,
+		any:    p.InitiatorFilterPolicy == 0x01,
+		chConn: make(chan *Conn, 1),
+	}
+	h.muConns.Lock()
+	h.dialing = d
+	h.muConns.Unlock()
+	defer func() {
+		h.muConns.Lock()
+		if h.dialing == d {
+			h.dialing = nil
+		}
+		h.muConns.Unlock()
+	}()
+
+	var c Command = &p
+	if h.extended {
+		c = extConnParams(p)
+	}
+	// The status of the command is waited for even if ctx is done, since the
+	// controller is initiating once it has accepted the command, and the
+	// connection has to be cancelled below.
+	f := h.SendAsync(c)
+	select {
+	case <-h.done:
+		return nil, h.Error()
+	case <-f.Done():
+	}
+	if err = f.Err(); err != nil {
 		return nil, err
 	}
 	var tmo <-chan time.Time
@@ -166,31 +266,72 @@ func (h *HCI) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
 		tmo = time.After(h.dialerTmo)
 	}
 	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
 	case <-h.done:
-		return nil, h.err
-	case c := <-h.chMasterConn:
+		return nil, h.Error()
+	case c := <-d.chConn:
+		if c == nil {
+			return nil, &ConnectionError{Addr: a, Status: d.err}
+		}
 		return gatt.NewClient(c)
+	case <-ctx.Done():
+		err = ctx.Err()
 	case <-tmo:
-		err := h.Send(&h.params.connCancel, nil)
-		if err == nil {
-			// The pending connection was canceled successfully.
-			return nil, fmt.Errorf("connection timed out")
-		}
-		// The connection has been established, the cancel command
-		// failed with ErrDisallowed.
-		if err == ErrDisallowed {
-			return gatt.NewClient(<-h.chMasterConn)
+		err = fmt.Errorf("connection timed out")
+	}
+
+	// Cancel the pending connection. If the connection has been established in
+	// the meantime, the cancel command fails with ErrDisallowed.
+	h.muConns.Lock()
+	d.canceled = true
+	h.muConns.Unlock()
+	if cerr := h.Send(&h.params.connCancel, nil); cerr != nil && cerr != ErrDisallowed {
+		return nil, errors.Wrap(cerr, "cancel connection failed")
+	}
+
+	// Either way, the controller reports the outcome with a LE Connection
+	// Complete event, which has to be consumed before the next dial starts.
+	select {
+	case <-h.done:
+		return nil, h.Error()
+	case c := <-d.chConn:
+		if c != nil {
+			return gatt.NewClient(c)
 		}
-		return nil, errors.Wrap(err, "cancel connection failed")
 	}
+	return nil, err
+}
+
+// peerAddr returns the address, in HCI byte order, and the address type of a.
+func peerAddr(a ble.Addr) ([6]byte, uint8, error) {
+	b, err := net.ParseMAC(a.String())
+	if err != nil || len(b) != 6 {
+		return [6]byte{}, 0, ErrInvalidAddr
+	}
+	typ := uint8(0x00) // Public Device Address
+	if _, ok := a.(RandomAddress); ok {
+		typ = 0x01 // Random Device Address
+	}
+	return [6]byte{b[5], b[4], b[3], b[2], b[1], b[0]}, typ, nil
 }
 
 // Advertise starts advertising.
 func (h *HCI) Advertise() error {
+	return h.AdvertiseContext(context.Background())
+}
+
+// AdvertiseContext starts advertising. It returns ctx.Err() if ctx is done
+// before the controller responds.
+func (h *HCI) AdvertiseContext(ctx context.Context) error {
 	h.params.advEnable.AdvertisingEnable = 1
-	return h.Send(&h.params.advEnable, nil)
+	return h.SendContext(ctx, h.advEnableCmd(), nil)
+}
+
+// advEnableCmd returns the command that applies the current advertising enable.
+func (h *HCI) advEnableCmd() Command {
+	if h.extended {
+		return h.params.extAdvEnable()
+	}
+	return &h.params.advEnable
 }
 
 // SetAdvertisement sets advertising data and scanResp.
@@ -201,14 +342,281 @@ func (h *HCI) SetAdvertisement(ad []byte, sr []byte) error {
 
 	h.params.advData.AdvertisingDataLength = uint8(len(ad))
 	copy(h.params.advData.AdvertisingData[:], ad)
+	h.params.scanResp.ScanResponseDataLength = uint8(len(sr))
+	copy(h.params.scanResp.ScanResponseData[:], sr)
+
+	if h.extended {
+		if err := h.Send(h.params.extAdvData(), nil); err != nil {
+			return err
+		}
+		return h.Send(h.params.extScanResp(), nil)
+	}
+
 	if err := h.Send(&h.params.advData, nil); err != nil {
 		return err
 	}
-
-	h.params.scanResp.ScanResponseDataLength = uint8(len(sr))
-	copy(h.params.scanResp.ScanResponseData[:], sr)
 	if err := h.Send(&h.params.scanResp, nil); err != nil {
 		return err
 	}
 	return nil
 }
+
+// SetPeriodicAdvertisement sets the periodic advertising data. Up to 1650 bytes
+// of data can be set while the periodic advertising is disabled. Once enabled,
+// the controller accepts only data that fits in a single command (252 bytes).
+// Periodic advertising requires OptExtendedAdvertising.
+func (h *HCI) SetPeriodicAdvertisement(data []byte) error {
+	if !h.extended {
+		return ErrExtendedNotEnabled
+	}
+	if len(data) > maxPeriodicAdvDataLen {
+		return ErrAdvDataTooLong
+	}
+	if h.params.perAdvEnable.Enable == 0 {
+		if err := h.setPeriodicAdvParams(); err != nil {
+			return err
+		}
+	}
+	for first := true; first || len(data) > 0; first = false {
+		c := &cmd.LESetPeriodicAdvertisingData{AdvertisingHandle: periodicAdvHandle}
+		n := copy(c.AdvertisingData[:], data)
+		c.AdvertisingDataLength = uint8(n)
+		data = data[n:]
+		switch {
+		case first && len(data) == 0:
+			c.Operation = 0x03 // Complete periodic advertising data
+		case first:
+			c.Operation = 0x01 // First fragment
+		case len(data) == 0:
+			c.Operation = 0x02 // Last fragment
+		default:
+			c.Operation = 0x00 // Intermediate fragment
+		}
+		if err := h.Send(c, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdvertisePeriodic starts periodic advertising. The periodic advertising is
+// carried by its own advertising set, which is advertised in addition to the
+// one used by Advertise.
+// Periodic advertising requires OptExtendedAdvertising.
+func (h *HCI) AdvertisePeriodic() error {
+	if !h.extended {
+		return ErrExtendedNotEnabled
+	}
+	if h.params.perAdvEnable.Enable == 0 {
+		if err := h.setPeriodicAdvParams(); err != nil {
+			return err
+		}
+	}
+	h.params.perAdvEnable.Enable = 1
+	h.params.perAdvEnable.AdvertisingHandle = periodicAdvHandle
+	if err := h.Send(&h.params.perAdvEnable, nil); err != nil {
+		h.params.perAdvEnable.Enable = 0
+		return err
+	}
+	return h.Send(&cmd.LESetExtendedAdvertisingEnable{
+		Enable:            1,
+		NumberOfSets:      1,
+		AdvertisingHandle: periodicAdvHandle,
+	}, nil)
+}
+
+// StopPeriodicAdvertising stops periodic advertising.
+func (h *HCI) StopPeriodicAdvertising() error {
+	return h.stopPeriodicAdvertising(context.Background())
+}
+
+func (h *HCI) stopPeriodicAdvertising(ctx context.Context) error {
+	if !h.extended {
+		return ErrExtendedNotEnabled
+	}
+	if err := h.SendContext(ctx, &cmd.LESetExtendedAdvertisingEnable{
+		Enable:            0,
+		NumberOfSets:      1,
+		AdvertisingHandle: periodicAdvHandle,
+	}, nil); err != nil {
+		return err
+	}
+	h.params.perAdvEnable.Enable = 0
+	h.params.perAdvEnable.AdvertisingHandle = periodicAdvHandle
+	return h.SendContext(ctx, &h.params.perAdvEnable, nil)
+}
+
+// setPeriodicAdvParams configures the advertising set used for periodic
+// advertising. The parameters can't be changed while it's enabled.
+func (h *HCI) setPeriodicAdvParams() error {
+	if err := h.Send(h.params.perExtAdvParams(), nil); err != nil {
+		return err
+	}
+	if err := h.setAdvSetRandomAddress(periodicAdvHandle); err != nil {
+		return err
+	}
+	return h.Send(&h.params.perAdvParams, nil)
+}
+
+// SyncPeriodic synchronizes to the periodic advertising of the advertising set
+// sid of advertiser a, and passes the reports received to ph. The controller
+// finds the periodic advertising through the extended advertising, so scanning
+// has to be enabled until SyncPeriodic returns.
+// Periodic advertising requires OptExtendedAdvertising.
+func (h *HCI) SyncPeriodic(ctx context.Context, a ble.Addr, sid uint8, ph PeriodicAdvHandler) (*PeriodicSync, error) {
+	if !h.extended {
+		return nil, ErrExtendedNotEnabled
+	}
+	addr, typ, err := peerAddr(a)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one LE Periodic Advertising Create Sync can be pending.
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.done:
+		return nil, h.Error()
+	case h.chSyncSem <- struct{}{}:
+	}
+	defer func() { <-h.chSyncSem }()
+
+	s := &PeriodicSync{
+		h:             h,
+		addr:          a,
+		sid:           sid,
+		handler:       ph,
+		chEstablished: make(chan error, 1),
+		done:          make(chan struct{}),
+	}
+	h.muSyncs.Lock()
+	h.syncPending = s
+	h.muSyncs.Unlock()
+
+	c := h.params.syncParams
+	c.AdvertisingSID = sid
+	c.AdvertiserAddressType = typ
+	c.AdvertiserAddress = addr
+	if err := h.Send(&c, nil); err != nil {
+		h.muSyncs.Lock()
+		h.syncPending = nil
+		h.muSyncs.Unlock()
+		return nil, err
+	}
+
+	select {
+	case err := <-s.chEstablished:
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case <-h.done:
+		return nil, h.Error()
+	case <-ctx.Done():
+	}
+
+	// Cancel the pending sync. If the cancel failed with ErrDisallowed, the
+	// sync has been established in the meantime; terminate it.
+	if err := h.Send(&cmd.LEPeriodicAdvertisingCreateSyncCancel{}, nil); err != nil && err != ErrDisallowed {
+		// The sync is abandoned, so a later Sync Established event isn't
+		// taken for it.
+		h.muSyncs.Lock()
+		if h.syncPending == s {
+			h.syncPending = nil
+		}
+		h.muSyncs.Unlock()
+		return nil, errors.Wrap(err, "cancel sync failed")
+	}
+	select {
+	case err := <-s.chEstablished:
+		if err == nil {
+			s.Terminate()
+		}
+	case <-h.done:
+	}
+	return nil, ctx.Err()
+}
+
+// PeriodicSync is a synchronization to a periodic advertising train.
+type PeriodicSync struct {
+	h       *HCI
+	handle  uint16
+	addr    ble.Addr
+	sid     uint8
+	phy     uint8
+	handler PeriodicAdvHandler
+
+	// interval is in units of 1.25 msec.
+	interval uint16
+
+	// r is the report being reassembled.
+	r *PeriodicAdvertisement
+
+	chEstablished chan error
+	done          chan struct{}
+	err           error
+}
+
+// Addr returns the address of the advertiser.
+func (s *PeriodicSync) Addr() ble.Addr { return s.addr }
+
+// SID returns the advertising set identifier.
+func (s *PeriodicSync) SID() uint8 { return s.sid }
+
+// PHY returns the PHY of the periodic advertising. 0x01: LE 1M, 0x02: LE 2M, 0x03: LE Coded.
+func (s *PeriodicSync) PHY() uint8 { return s.phy }
+
+// Interval returns the interval of the periodic advertising.
+func (s *PeriodicSync) Interval() time.Duration {
+	return time.Duration(s.interval) * 1250 * time.Microsecond
+}
+
+// Done returns a channel, which is closed when the sync is lost or terminated.
+func (s *PeriodicSync) Done() <-chan struct{} { return s.done }
+
+// Err returns ErrSyncLost, if the sync has been lost.
+func (s *PeriodicSync) Err() error { return s.err }
+
+// Terminate stops the synchronization.
+func (s *PeriodicSync) Terminate() error {
+	return s.terminate(context.Background())
+}
+
+func (s *PeriodicSync) terminate(ctx context.Context) error {
+	h := s.h
+	h.muSyncs.Lock()
+	_, ok := h.syncs[s.handle]
+	delete(h.syncs, s.handle)
+	h.muSyncs.Unlock()
+	if !ok {
+		// Already lost or terminated.
+		return nil
+	}
+	close(s.done)
+	return h.SendContext(ctx, &cmd.LEPeriodicAdvertisingTerminateSync{SyncHandle: s.handle}, nil)
+}
+
+// handleReport reassembles the periodic advertising data, which the controller
+// might have reported in several fragments, and passes it to the handler.
+func (s *PeriodicSync) handleReport(e evt.LEPeriodicAdvertisingReport) {
+	if s.r == nil {
+		s.r = &PeriodicAdvertisement{
+			txPower: e.TxPower(),
+			rssi:    e.RSSI(),
+			cteType: e.CTEType(),
+		}
+	}
+	s.r.data = append(s.r.data, e.Data()...)
+	switch e.DataStatus() {
+	case extDataIncomplete:
+		return
+	case extDataTruncated:
+		s.r.truncated = true
+	}
+	r := s.r
+	s.r = nil
+	if s.handler != nil {
+		s.h.spawn(func() { s.handler(r) })
+	}
+}
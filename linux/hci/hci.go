@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/currantlabs/ble"
 	"github.com/currantlabs/ble/linux/hci/cmd"
 	"github.com/currantlabs/ble/linux/hci/evt"
@@ -28,6 +30,10 @@ type CommandRP interface {
 
 type handlerFn func(b []byte) error
 
+// cmdBufSize is the size of command buffers, which hold the HCI packet type,
+// the command header, and up to 255 bytes of parameters [Vol 2, Part E, 5.4.1].
+const cmdBufSize = 1 + 3 + 255
+
 type pkt struct {
 	cmd  Command
 	done chan []byte
@@ -40,19 +46,34 @@ func NewHCI(opts ...Option) (*HCI, error) {
 
 		chCmdPkt:  make(chan *pkt),
 		chCmdBufs: make(chan []byte, 8),
-		sent:      make(map[int]*pkt),
+		sent:      make(map[int][]*pkt),
 
 		evth: map[int]handlerFn{},
 		subh: map[int]handlerFn{},
 
-		muConns:      &sync.Mutex{},
-		conns:        make(map[uint16]*Conn),
-		chMasterConn: make(chan *Conn),
-		chSlaveConn:  make(chan *Conn),
+		muConns:     &sync.Mutex{},
+		conns:       make(map[uint16]*Conn),
+		chDialSem:   make(chan struct{}, 1),
+		chSlaveConn: make(chan *Conn),
+
+		fixed:        make(map[uint16]FixedChannelHandler),
+		cocListeners: make(map[uint16]*CoCListener),
+
+		chSyncSem: make(chan struct{}, 1),
+		muSyncs:   &sync.Mutex{},
+		syncs:     make(map[uint16]*PeriodicSync),
+
+		chAdv:  make(chan advReport, advQueueLen),
+		rxBufs: make(chan packet, hostACLDataCnt),
+
+		sigRTX: sigRTXDefault,
 
 		done: make(chan bool),
 	}
 	h.params.init()
+	if err := h.smp.init(); err != nil {
+		return nil, err
+	}
 	if err := h.Option(opts...); err != nil {
 		return nil, errors.Wrap(err, "can't set options")
 	}
@@ -66,13 +87,15 @@ type HCI struct {
 
 	params params
 
-	skt io.ReadWriteCloser
-	id  int
+	skt  io.ReadWriteCloser
+	id   int
+	mgmt bool
 
 	// Host to Controller command flow control [Vol 2, Part E, 4.4]
 	chCmdPkt  chan *pkt
 	chCmdBufs chan []byte
-	sent      map[int]*pkt
+	muSent    sync.Mutex
+	sent      map[int][]*pkt
 
 	// evtHub
 	evth map[int]handlerFn
@@ -86,6 +109,10 @@ type HCI struct {
 	addr    net.HardwareAddr
 	txPwrLv int
 
+	// randAddr is the static random address of the device, in HCI byte
+	// order, which it uses instead of the public address, if set.
+	randAddr *[6]byte
+
 	// adHist and adLast track the history of past scannable advertising packets.
 	// Controller delivers AD(Advertising Data) and SR(Scan Response) separately
 	// through HCI. Upon recieving an AD, no matter it's scannable or not, we
@@ -97,21 +124,82 @@ type HCI struct {
 	adHist     []*Advertisement
 	adLast     int
 
+	// Advertisements are passed to the handler by the advLoop, so the handler
+	// doesn't hold up the sktLoop. advDropping is set while the queue is full.
+	chAdv       chan advReport
+	advDropping bool
+
+	// extended indicates the extended advertising commands are used instead of
+	// the legacy ones. With extended scanning, the controller delivers large
+	// advertising data in fragments, which are reassembled in extFrags before
+	// being passed to the advHandler. extHist and extLast are the counterpart of
+	// adHist and adLast.
+	extended   bool
+	leFeatures uint64
+	extFrags   map[extAdvKey]*ExtendedAdvertisement
+	extHist    []*ExtendedAdvertisement
+	extLast    int
+
+	// Periodic advertising syncs. The controller allows only one pending
+	// LE Periodic Advertising Create Sync at a time. syncPending is the one
+	// waiting for the LE Periodic Advertising Sync Established event.
+	chSyncSem   chan struct{}
+	muSyncs     *sync.Mutex
+	syncPending *PeriodicSync
+	syncs       map[uint16]*PeriodicSync
+
 	// Host to Controller Data Flow Control Packet-based Data flow control for LE-U [Vol 2, Part E, 4.1.1]
 	// Minimum 27 bytes. 4 bytes of L2CAP Header, and 23 bytes Payload from upper layer (ATT)
 	pool *Pool
 
+	// rxBufs is the free list of buffers for received ACL data packets.
+	rxBufs chan packet
+
+	// Controller to Host Data Flow Control for ACL data [Vol 2, Part E, 4.2]
+	// hostFlow indicates the controller has been told our buffer capacity, and
+	// sends no more ACL data packets than we have returned credits for. The
+	// credits are returned once the PDUs are consumed, and the PDUs arriving
+	// on a connection which holds its share of them are dropped. A connection
+	// slow to consume its data loses it instead of holding back the sktLoop,
+	// or the controller for the other connections.
+	hostFlow bool
+
 	// L2CAP connections
-	muConns      *sync.Mutex
-	conns        map[uint16]*Conn
-	chMasterConn chan *Conn // Dial returns master connections.
-	chSlaveConn  chan *Conn // Peripheral accept slave connections.
+	muConns     *sync.Mutex
+	conns       map[uint16]*Conn
+	chSlaveConn chan *Conn // Peripheral accept slave connections.
+
+	// The controller allows only one pending LE Create Connection, so dials are
+	// queued on chDialSem. dialing is the one waiting for its connection, and
+	// is guarded by muConns.
+	chDialSem chan struct{}
+	dialing   *dial
+
+	// Handlers of the fixed channels for all connections, indexed by CIDs.
+	muFixed sync.Mutex
+	fixed   map[uint16]FixedChannelHandler
+
+	// Listeners of LE Credit Based channels, indexed by LE_PSMs.
+	muCoC        sync.Mutex
+	cocListeners map[uint16]*CoCListener
 
 	dialerTmo   time.Duration
 	listenerTmo time.Duration
 
-	err  error
-	done chan bool
+	// smp is the pairing configuration of the local device.
+	smp smpConfig
+
+	// sigRTX is the RTX timer of the L2CAP signaling requests.
+	sigRTX time.Duration
+
+	// err is the error the HCI has failed with, which fails the following
+	// commands. It's set by the sktLoop and the senders, and guarded by muErr.
+	muErr sync.Mutex
+	err   error
+	done  chan bool
+
+	// wg tracks the goroutines of the HCI.
+	wg sync.WaitGroup
 }
 
 // Init ...
@@ -121,47 +209,169 @@ func (h *HCI) Init() error {
 	h.evth[evt.CommandStatusCode] = h.handleCommandStatus
 	h.evth[evt.DisconnectionCompleteCode] = h.handleDisconnectionComplete
 	h.evth[evt.NumberOfCompletedPacketsCode] = h.handleNumberOfCompletedPackets
+	h.evth[evt.EncryptionChangeCode] = h.handleEncryptionChange
+	h.evth[evt.EncryptionKeyRefreshCompleteCode] = h.handleEncryptionKeyRefreshComplete
 
 	h.subh[evt.LEAdvertisingReportSubCode] = h.handleLEAdvertisingReport
+	h.subh[evt.LEExtendedAdvertisingReportSubCode] = h.handleLEExtendedAdvertisingReport
+	h.subh[evt.LEPeriodicAdvertisingSyncEstablishedSubCode] = h.handleLEPeriodicAdvertisingSyncEstablished
+	h.subh[evt.LEPeriodicAdvertisingReportSubCode] = h.handleLEPeriodicAdvertisingReport
+	h.subh[evt.LEPeriodicAdvertisingSyncLostSubCode] = h.handleLEPeriodicAdvertisingSyncLost
 	h.subh[evt.LEConnectionCompleteSubCode] = h.handleLEConnectionComplete
 	h.subh[evt.LEConnectionUpdateCompleteSubCode] = h.handleLEConnectionUpdateComplete
 	h.subh[evt.LELongTermKeyRequestSubCode] = h.handleLELongTermKeyRequest
-	// evt.EncryptionChangeCode:                     todo),
 	// evt.ReadRemoteVersionInformationCompleteCode: todo),
 	// evt.HardwareErrorCode:                        todo),
 	// evt.DataBufferOverflowCode:                   todo),
-	// evt.EncryptionKeyRefreshCompleteCode:         todo),
 	// evt.AuthenticatedPayloadTimeoutExpiredCode:   todo),
 	// evt.LEReadRemoteUsedFeaturesCompleteSubCode:   todo),
 	// evt.LERemoteConnectionParameterRequestSubCode: todo),
 
-	skt, err := socket.NewSocket(h.id)
+	skt, err := h.openSocket()
 	if err != nil {
 		return err
 	}
 	h.skt = skt
 
-	h.chCmdBufs <- make([]byte, 64)
+	h.chCmdBufs <- make([]byte, cmdBufSize)
 
-	go h.sktLoop()
+	h.spawn(h.sktLoop)
+	h.spawn(h.advLoop)
 	h.init()
 
 	// Pre-allocate buffers with additional head room for lower layer headers.
 	// HCI header (1 Byte) + ACL Data Header (4 bytes) + L2CAP PDU (or fragment)
 	h.pool = NewPool(1+4+h.bufSize, h.bufCnt-1)
 
+	if h.randAddr != nil {
+		if err := h.Send(&cmd.LESetRandomAddress{RandomAddress: *h.randAddr}, nil); err != nil {
+			return errors.Wrap(err, "can't set random address")
+		}
+	}
+
+	if h.extended {
+		if h.leFeatures&leFeatureExtendedAdvertising == 0 {
+			return ErrExtendedNotSupported
+		}
+		h.Send(h.params.extAdvParams(), nil)
+		h.setAdvSetRandomAddress(legacyAdvHandle)
+		h.Send(h.params.extScanParams(h.leFeatures&leFeatureCodedPHY != 0), nil)
+		return nil
+	}
 	h.Send(&h.params.advParams, nil)
 	h.Send(&h.params.scanParams, nil)
 	return nil
 }
 
-// Close ...
+// Close closes the HCI abruptly. See Shutdown for a graceful one.
 func (h *HCI) Close() error {
 	return h.close(nil)
 }
 
+// Shutdown gracefully shuts down the HCI. It stops advertising, scanning and
+// periodic advertising syncs, cancels the pending connection, disconnects every
+// connection with reason "Remote User Terminated Connection", and waits for
+// them to be disconnected. It then resets the controller, closes the socket,
+// and returns after all the goroutines of the HCI have exited.
+// The commands are sent with ctx. Once ctx is done, the steps left are skipped,
+// the socket is closed, and ctx.Err() is returned after the goroutines have
+// exited. These include the callbacks of the Agent, which are expected to
+// return once the connection is disconnected.
+func (h *HCI) Shutdown(ctx context.Context) error {
+	select {
+	case <-h.done:
+		h.wg.Wait()
+		return nil
+	default:
+	}
+
+	h.params.RLock()
+	advertising := h.params.advEnable.AdvertisingEnable == 1
+	scanning := h.params.scanEnable.LEScanEnable == 1
+	periodic := h.params.perAdvEnable.Enable == 1
+	h.params.RUnlock()
+	if advertising {
+		h.stopAdvertising(ctx)
+	}
+	if scanning {
+		h.stopScanning(ctx)
+	}
+	if periodic {
+		h.stopPeriodicAdvertising(ctx)
+	}
+
+	h.muSyncs.Lock()
+	syncs := make([]*PeriodicSync, 0, len(h.syncs))
+	for _, s := range h.syncs {
+		syncs = append(syncs, s)
+	}
+	h.muSyncs.Unlock()
+	for _, s := range syncs {
+		s.terminate(ctx)
+	}
+
+	// The pending Dial returns once the controller reports the cancellation.
+	h.muConns.Lock()
+	dialing := h.dialing != nil
+	h.muConns.Unlock()
+	if dialing {
+		h.SendContext(ctx, &h.params.connCancel, nil)
+	}
+
+	h.muConns.Lock()
+	conns := make([]*Conn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.muConns.Unlock()
+	for _, c := range conns {
+		c.disconnect(ctx)
+	}
+wait:
+	for _, c := range conns {
+		select {
+		case <-c.chDone:
+		case <-h.done:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	h.SendContext(ctx, &cmd.Reset{}, nil)
+	err := ctx.Err()
+	h.close(err)
+	<-h.done
+
+	// Release the connections, which weren't disconnected in time. The sktLoop
+	// has exited, so no more events are coming for them.
+	h.muConns.Lock()
+	for handle, c := range h.conns {
+		delete(h.conns, handle)
+		close(c.chInPkt)
+		c.reason = ErrLocalHost
+		close(c.chDone)
+		c.txBuffer.Close()
+	}
+	h.muConns.Unlock()
+
+	h.wg.Wait()
+	return err
+}
+
+// spawn runs f in a goroutine, which Shutdown waits for.
+func (h *HCI) spawn(f func()) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		f()
+	}()
+}
+
 // Error ...
 func (h *HCI) Error() error {
+	h.muErr.Lock()
+	defer h.muErr.Unlock()
 	return h.err
 }
 
@@ -204,8 +414,20 @@ func (h *HCI) init() error {
 
 	h.txPwrLv = int(LEReadAdvertisingChannelTxPowerRP.TransmitPowerLevel)
 
+	LEReadLocalSupportedFeaturesRP := cmd.LEReadLocalSupportedFeaturesRP{}
+	h.Send(&cmd.LEReadLocalSupportedFeatures{}, &LEReadLocalSupportedFeaturesRP)
+
+	h.leFeatures = LEReadLocalSupportedFeaturesRP.LEFeatures
+
+	leEventMask := uint64(0x000000000000001F)
+	if h.extended {
+		leEventMask |= 0x0000000000001000 // LE Extended Advertising Report
+		leEventMask |= 0x0000000000002000 // LE Periodic Advertising Sync Established
+		leEventMask |= 0x0000000000004000 // LE Periodic Advertising Report
+		leEventMask |= 0x0000000000008000 // LE Periodic Advertising Sync Lost
+	}
 	LESetEventMaskRP := cmd.LESetEventMaskRP{}
-	h.Send(&cmd.LESetEventMask{LEEventMask: 0x000000000000001F}, &LESetEventMaskRP)
+	h.Send(&cmd.LESetEventMask{LEEventMask: leEventMask}, &LESetEventMaskRP)
 
 	SetEventMaskRP := cmd.SetEventMaskRP{}
 	h.Send(&cmd.SetEventMask{EventMask: 0x3dbff807fffbffff}, &SetEventMaskRP)
@@ -213,12 +435,43 @@ func (h *HCI) init() error {
 	WriteLEHostSupportRP := cmd.WriteLEHostSupportRP{}
 	h.Send(&cmd.WriteLEHostSupport{LESupportedHost: 1, SimultaneousLEHost: 0}, &WriteLEHostSupportRP)
 
-	return h.err
+	// Controllers without Controller to Host flow control fall back to
+	// delivering ACL data freely.
+	if err := h.Send(&cmd.HostBufferSize{
+		HostACLDataPacketLength:    hostACLDataLen,
+		HostTotalNumACLDataPackets: hostACLDataCnt,
+	}, nil); err != nil {
+		logger.Info("host buffer size not supported", "err", err)
+		return h.Error()
+	}
+	if err := h.Send(&cmd.SetControllerToHostFlowControl{FlowControlEnable: 0x01}, nil); err != nil {
+		logger.Info("controller to host flow control not supported", "err", err)
+		return h.Error()
+	}
+	h.hostFlow = true
+
+	return h.Error()
 }
 
-// Send ...
+// openSocket opens the HCI user channel of the device.
+func (h *HCI) openSocket() (io.ReadWriteCloser, error) {
+	if h.mgmt {
+		return socket.NewMgmtSocket(h.id)
+	}
+	return socket.NewSocket(h.id)
+}
+
+// Send sends the command c, and waits for its response. The return parameters
+// are unmarshalled into r, if r is not nil.
 func (h *HCI) Send(c Command, r CommandRP) error {
-	b, err := h.send(c)
+	return h.SendContext(context.Background(), c, r)
+}
+
+// SendContext is like Send, but gives up waiting when ctx is done, in which
+// case ctx.Err() is returned. If the command has been sent by then, the
+// controller still carries it out, and its late response is discarded.
+func (h *HCI) SendContext(ctx context.Context, c Command, r CommandRP) error {
+	b, err := h.send(ctx, c)
 	if err != nil {
 		return err
 	}
@@ -231,21 +484,70 @@ func (h *HCI) Send(c Command, r CommandRP) error {
 	return nil
 }
 
-func (h *HCI) send(c Command) ([]byte, error) {
-	if h.err != nil {
-		return nil, h.err
+// A CommandFuture is the pending result of a command sent by SendAsync.
+type CommandFuture struct {
+	done chan struct{}
+	err  error
+}
+
+// Done returns a channel, which is closed when the command has completed.
+func (f *CommandFuture) Done() <-chan struct{} { return f.done }
+
+// Err returns the result of the command, once Done is closed.
+func (f *CommandFuture) Err() error { return f.err }
+
+// Wait waits for the command to complete, and returns its result. It returns
+// ctx.Err() if ctx is done first.
+func (f *CommandFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendAsync sends the command c without waiting for its response, and returns
+// a CommandFuture of its status. It's meant for the commands answered by a
+// Command Status event, such as LE Create Connection or Disconnect, which
+// carry out the operation afterwards. The return parameters of other commands
+// are discarded; use SendContext for them.
+func (h *HCI) SendAsync(c Command) *CommandFuture {
+	f := &CommandFuture{done: make(chan struct{})}
+	h.spawn(func() {
+		f.err = h.Send(c, nil)
+		close(f.done)
+	})
+	return f
+}
+
+func (h *HCI) send(ctx context.Context, c Command) ([]byte, error) {
+	if err := h.Error(); err != nil {
+		return nil, err
+	}
+	// The response is buffered, so the sktLoop won't block on a late one.
+	p := &pkt{c, make(chan []byte, 1)}
+	var b []byte
+	select {
+	case b = <-h.chCmdBufs:
+	case <-h.done:
+		return nil, h.Error()
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	p := &pkt{c, make(chan []byte)}
-	b := <-h.chCmdBufs
 	b[0] = byte(pktTypeCommand) // HCI header
 	b[1] = byte(c.OpCode())
 	b[2] = byte(c.OpCode() >> 8)
 	b[3] = byte(c.Len())
 	if err := c.Marshal(b[4:]); err != nil {
-		h.close(fmt.Errorf("hci: failed to marshal cmd"))
+		h.chCmdBufs <- b
+		return nil, errors.Wrap(err, "can't marshal cmd")
 	}
 
-	h.sent[c.OpCode()] = p // TODO: lock
+	// Responses to the commands of the same opcode come in order.
+	h.muSent.Lock()
+	h.sent[c.OpCode()] = append(h.sent[c.OpCode()], p)
+	h.muSent.Unlock()
 	if n, err := h.skt.Write(b[:4+c.Len()]); err != nil {
 		h.close(fmt.Errorf("hci: failed to send cmd"))
 	} else if n != 4+c.Len() {
@@ -254,35 +556,100 @@ func (h *HCI) send(c Command) ([]byte, error) {
 
 	select {
 	case <-h.done:
-		return nil, h.err
+		return nil, h.Error()
 	case b := <-p.done:
 		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// popSent returns the oldest command of the opcode waiting for a response.
+func (h *HCI) popSent(op int) *pkt {
+	h.muSent.Lock()
+	defer h.muSent.Unlock()
+	q := h.sent[op]
+	if len(q) == 0 {
+		return nil
+	}
+	if len(q) == 1 {
+		delete(h.sent, op)
+	} else {
+		h.sent[op] = q[1:]
+	}
+	return q[0]
+}
+
+// sendNoWait sends a command, which the controller doesn't respond to, such as
+// Host Number Of Completed Packets. It can be sent regardless of the command
+// flow control. [Vol 2, Part E, 7.3.40]
+func (h *HCI) sendNoWait(c Command) error {
+	if err := h.Error(); err != nil {
+		return err
+	}
+	b := make([]byte, 4+c.Len())
+	b[0] = byte(pktTypeCommand) // HCI header
+	b[1] = byte(c.OpCode())
+	b[2] = byte(c.OpCode() >> 8)
+	b[3] = byte(c.Len())
+	if err := c.Marshal(b[4:]); err != nil {
+		return errors.Wrap(err, "can't marshal cmd")
+	}
+	if _, err := h.skt.Write(b); err != nil {
+		return errors.Wrap(err, "can't send cmd")
+	}
+	return nil
+}
+
+// completeACL returns the host buffers of n ACL data packets of the connection
+// handle, which have been consumed, to the controller. [Vol 2, Part E, 4.2]
+func (h *HCI) completeACL(handle uint16, n int) {
+	if !h.hostFlow || n == 0 {
+		return
+	}
+	err := h.sendNoWait(&cmd.HostNumberOfCompletedPackets{
+		NumberOfHandles:           1,
+		ConnectionHandle:          []uint16{handle},
+		HostNumOfCompletedPackets: []uint16{uint16(n)},
+	})
+	if err != nil {
+		logger.Warn("can't complete ACL data packets", "handle", handle, "err", err)
 	}
 }
 
 func (h *HCI) sktLoop() {
+	// Packets are handled in place, and the buffer is reused for the next one.
+	// Handlers copy what they need to keep beyond the handling.
 	b := make([]byte, 4096)
 	defer close(h.done)
 	for {
 		n, err := h.skt.Read(b)
 		if n == 0 || err != nil {
-			h.err = fmt.Errorf("skt: %s", err)
+			h.setErr(fmt.Errorf("skt: %s", err))
 			return
 		}
-		p := make([]byte, n)
-		copy(p, b)
-		if err := h.handlePkt(p); err != nil {
-			h.err = fmt.Errorf("skt: %s", err)
+		if err := h.handlePkt(b[:n]); err != nil {
+			h.setErr(fmt.Errorf("skt: %s", err))
 			return
 		}
 	}
 }
 
 func (h *HCI) close(err error) error {
-	h.err = err
+	h.setErr(err)
 	return h.skt.Close()
 }
 
+// setErr records the error the HCI has failed with, unless it has failed
+// already.
+func (h *HCI) setErr(err error) {
+	h.muErr.Lock()
+	defer h.muErr.Unlock()
+	if h.err == nil {
+		h.err = err
+	}
+}
+
 func (h *HCI) handlePkt(b []byte) error {
 	// Strip the 1-byte HCI header and pass down the rest of the packet.
 	t, b := b[0], b[1:]
@@ -309,12 +676,62 @@ func (h *HCI) handleACL(b []byte) error {
 	h.muConns.Unlock()
 	if !ok {
 		logger.Warn("invalid connection handle on ACL packet", "handle", handle)
+		h.completeACL(handle, 1)
+		return nil
+	}
+	share := 0
+	if h.hostFlow {
+		share = h.rxShare()
+	}
+	if !c.hold(packet(b).pbf()&pbfContinuing == 0, share) {
+		logger.Warn("host buffers of the connection full, ACL packet dropped", "handle", handle)
+		h.completeACL(handle, 1)
 		return nil
 	}
-	c.chInPkt <- b
+	p := h.getPkt(len(b))
+	copy(p, b)
+	c.chInPkt <- p
 	return nil
 }
 
+// rxShare returns the number of host buffers for ACL data each connection can
+// hold.
+func (h *HCI) rxShare() int {
+	h.muConns.Lock()
+	n := len(h.conns)
+	h.muConns.Unlock()
+	if n <= 1 {
+		return hostACLDataCnt
+	}
+	if hostACLDataCnt/n < 1 {
+		return 1
+	}
+	return hostACLDataCnt / n
+}
+
+// getPkt returns a buffer of length n from the free list for an ACL data packet.
+func (h *HCI) getPkt(n int) packet {
+	select {
+	case b := <-h.rxBufs:
+		if cap(b) >= n {
+			return b[:n]
+		}
+	default:
+	}
+	if n < rxPktSize {
+		return make(packet, n, rxPktSize)
+	}
+	return make(packet, n)
+}
+
+// putPkt returns the buffer of an ACL data packet to the free list.
+func (h *HCI) putPkt(b packet) {
+	select {
+	case h.rxBufs <- b:
+	default:
+	}
+}
+
 func (h *HCI) handleEvt(b []byte) error {
 	code, plen := int(b[0]), int(b[1])
 	if plen != len(b[2:]) {
@@ -325,11 +742,12 @@ func (h *HCI) handleEvt(b []byte) error {
 			return f(b[2:])
 		}
 	}
-	if plen != len(b[2:]) {
-		h.err = fmt.Errorf("invalid event packet: % X", b)
-	}
+	// The errors of the other events concern only the events themselves, and
+	// don't fail the HCI.
 	if f := h.evth[code]; f != nil {
-		h.err = f(b[2:])
+		if err := f(b[2:]); err != nil {
+			logger.Warn("can't handle event", "code", fmt.Sprintf("0x%02X", code), "err", err)
+		}
 		return nil
 	}
 	if code == 0xff { // Ignore vendor events
@@ -373,9 +791,11 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 				if h.adHist[idx] == nil {
 					break
 				}
-				if h.adHist[idx].Address().String() == sr.Address().String() {
-					h.adHist[idx].setScanResponse(sr)
-					a = h.adHist[idx]
+				if h.adHist[idx].addr == sr.addr && h.adHist[idx].addrType == sr.addrType {
+					// The advertisement might still be in use by the handler.
+					ad := *h.adHist[idx]
+					ad.setScanResponse(sr)
+					a = &ad
 					break
 				}
 			}
@@ -386,16 +806,173 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 		default:
 			a = newAdvertisement(e, i)
 		}
-		go h.advHandler(a)
+		h.dispatchAdv(a)
+	}
+
+	return nil
+}
+
+func (h *HCI) handleLEExtendedAdvertisingReport(b []byte) error {
+	if h.advHandler == nil {
+		return nil
+	}
+
+	e := evt.LEExtendedAdvertisingReport(b)
+	if !e.Valid() {
+		return fmt.Errorf("invalid extended advertising report: % X", b)
+	}
+	for i := 0; i < int(e.NumReports()); i++ {
+		typ := e.EventType(i)
+		k := extAdvKey{
+			sr:       typ&extEvtTypScanRsp != 0,
+			addrType: e.AddressType(i),
+			addr:     e.Address(i),
+			sid:      e.AdvertisingSID(i),
+		}
+
+		// Reassemble the advertising data, which the controller might have
+		// reported in several fragments. [Vol 2, Part E, 7.7.65.13]
+		a, ok := h.extFrags[k]
+		if !ok {
+			a = newExtendedAdvertisement(k, e, i)
+		} else {
+			a.data = append(a.data, e.Data(i)...)
+		}
+		switch (typ >> 5) & 0x03 {
+		case extDataIncomplete:
+			if !ok && len(h.extFrags) >= maxExtFrags {
+				// Too many interleaved advertisers; drop the partial ones.
+				h.extFrags = make(map[extAdvKey]*ExtendedAdvertisement)
+			}
+			h.extFrags[k] = a
+			continue
+		case extDataTruncated:
+			a.truncated = true
+		}
+		delete(h.extFrags, k)
+
+		switch {
+		case k.sr:
+			var ad *ExtendedAdvertisement
+			adk := k
+			adk.sr = false
+			for idx := h.extLast - 1; idx != h.extLast; idx-- {
+				if idx == -1 {
+					idx = len(h.extHist) - 1
+				}
+				if h.extHist[idx] == nil {
+					break
+				}
+				if h.extHist[idx].k == adk {
+					// The advertisement might still be in use by the handler.
+					cp := *h.extHist[idx]
+					cp.setScanResponse(a)
+					ad = &cp
+					break
+				}
+			}
+			if ad == nil {
+				logger.Debug("adv", "scan response with no associated advertising data", a.Address())
+				continue
+			}
+			a = ad
+		case typ&extEvtTypScannable != 0:
+			h.extHist[h.extLast] = a
+			h.extLast++
+			if h.extLast == len(h.extHist) {
+				h.extLast = 0
+			}
+		}
+		h.dispatchAdv(a)
+	}
+
+	return nil
+}
+
+// dispatchAdv queues the advertisement for the advLoop. Advertisements are
+// dropped if the handler can't keep up, rather than stalling the sktLoop.
+func (h *HCI) dispatchAdv(a ble.Advertisement) {
+	select {
+	case h.chAdv <- advReport{h: h.advHandler, a: a}:
+		h.advDropping = false
+	default:
+		if !h.advDropping {
+			logger.Warn("advertising handler can't keep up, dropping advertisements")
+		}
+		h.advDropping = true
+	}
+}
+
+// advLoop passes queued advertisements to the handler, one at a time.
+func (h *HCI) advLoop() {
+	for {
+		select {
+		case r := <-h.chAdv:
+			r.h(r.a)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *HCI) handleLEPeriodicAdvertisingSyncEstablished(b []byte) error {
+	e := evt.LEPeriodicAdvertisingSyncEstablished(b)
+	h.muSyncs.Lock()
+	s := h.syncPending
+	h.syncPending = nil
+	if s != nil && e.Status() == 0x00 {
+		// Register the sync right away, so the reports that follow won't be missed.
+		s.handle = e.SyncHandle()
+		s.phy = e.AdvertiserPHY()
+		s.interval = e.PeriodicAdvertisingInterval()
+		h.syncs[s.handle] = s
+	}
+	h.muSyncs.Unlock()
+	if s == nil {
+		// The pending sync has been cancelled already.
+		logger.Info("periodic advertising sync established with no pending sync", "status", e.Status(), "handle", e.SyncHandle())
+		return nil
+	}
+	if e.Status() != 0x00 {
+		s.chEstablished <- ErrCommand(e.Status())
+		return nil
 	}
+	s.chEstablished <- nil
+	return nil
+}
 
+func (h *HCI) handleLEPeriodicAdvertisingReport(b []byte) error {
+	e := evt.LEPeriodicAdvertisingReport(b)
+	h.muSyncs.Lock()
+	s, ok := h.syncs[e.SyncHandle()]
+	h.muSyncs.Unlock()
+	if !ok {
+		return nil
+	}
+	s.handleReport(e)
+	return nil
+}
+
+func (h *HCI) handleLEPeriodicAdvertisingSyncLost(b []byte) error {
+	e := evt.LEPeriodicAdvertisingSyncLost(b)
+	h.muSyncs.Lock()
+	s, ok := h.syncs[e.SyncHandle()]
+	delete(h.syncs, e.SyncHandle())
+	h.muSyncs.Unlock()
+	if !ok {
+		// The sync has been terminated already.
+		logger.Info("lost an unknown periodic advertising sync", "handle", e.SyncHandle())
+		return nil
+	}
+	s.err = ErrSyncLost
+	close(s.done)
 	return nil
 }
 
 func (h *HCI) handleCommandComplete(b []byte) error {
 	e := evt.CommandComplete(b)
 	for i := 0; i < int(e.NumHCICommandPackets()); i++ {
-		h.chCmdBufs <- make([]byte, 64)
+		h.chCmdBufs <- make([]byte, cmdBufSize)
 	}
 
 	// NOP command, used for flow control purpose [Vol 2, Part E, 4.4]
@@ -403,22 +980,29 @@ func (h *HCI) handleCommandComplete(b []byte) error {
 		h.chCmdBufs = make(chan []byte, 8)
 		return nil
 	}
-	p, found := h.sent[int(e.CommandOpcode())]
-	if !found {
+	// Host Number Of Completed Packets is responded only on errors.
+	if e.CommandOpcode() == uint16((&cmd.HostNumberOfCompletedPackets{}).OpCode()) {
+		logger.Warn("host number of completed packets failed", "status", e.ReturnParameters())
+		return nil
+	}
+
+	p := h.popSent(int(e.CommandOpcode()))
+	if p == nil {
 		return fmt.Errorf("can't find the cmd for CommandCompleteEP: % X", e)
 	}
-	p.done <- e.ReturnParameters()
+	// The event buffer is reused once the handler returns.
+	p.done <- append([]byte(nil), e.ReturnParameters()...)
 	return nil
 }
 
 func (h *HCI) handleCommandStatus(b []byte) error {
 	e := evt.CommandStatus(b)
 	for i := 0; i < int(e.NumHCICommandPackets()); i++ {
-		h.chCmdBufs <- make([]byte, 64)
+		h.chCmdBufs <- make([]byte, cmdBufSize)
 	}
 
-	p, found := h.sent[int(e.CommandOpcode())]
-	if !found {
+	p := h.popSent(int(e.CommandOpcode()))
+	if p == nil {
 		return fmt.Errorf("can't find the cmd for CommandStatusEP: % X", e)
 	}
 	p.done <- []byte{e.Status()}
@@ -427,23 +1011,43 @@ func (h *HCI) handleCommandStatus(b []byte) error {
 
 func (h *HCI) handleLEConnectionComplete(b []byte) error {
 	e := evt.LEConnectionComplete(b)
-	c := newConn(h, e)
-	h.muConns.Lock()
-	h.conns[e.ConnectionHandle()] = c
-	h.muConns.Unlock()
 	if e.Role() == roleMaster {
-		if e.Status() == 0x00 {
-			h.chMasterConn <- c
+		// Only one LE Create Connection can be pending, so a failure always
+		// belongs to the current dial, while an established connection is
+		// matched by the peer address.
+		h.muConns.Lock()
+		d := h.dialing
+		if d != nil && e.Status() == 0x00 && !d.match(e) {
+			d = nil
+		}
+		if d != nil && ErrCommand(e.Status()) == ErrConnID && !d.canceled {
+			// A connection canceled by a dial that has given up already.
+			d = nil
+		}
+		if d != nil {
+			h.dialing = nil
+		}
+		h.muConns.Unlock()
+		if e.Status() != 0x00 {
+			if d != nil {
+				// The connection was canceled, or failed to be established.
+				d.err = ErrCommand(e.Status())
+				d.chConn <- nil
+			}
 			return nil
 		}
-		if ErrCommand(e.Status()) == ErrConnID {
-			// The connection was canceled successfully.
+		c := h.addConn(e)
+		if d == nil {
+			// Nobody is waiting for this connection.
+			logger.Warn("unexpected master connection", "peer", fmt.Sprintf("% X", e.PeerAddress()))
+			h.spawn(func() { c.Close() })
 			return nil
 		}
+		d.chConn <- c
 		return nil
 	}
 	if e.Status() == 0x00 {
-		h.chSlaveConn <- c
+		h.chSlaveConn <- h.addConn(e)
 		// When a controller accepts a connection, it moves from advertising
 		// state to idle/ready state. Host needs to explicitly ask the
 		// controller to re-enable advertising. Note that the host was most
@@ -453,15 +1057,38 @@ func (h *HCI) handleLEConnectionComplete(b []byte) error {
 		// The re-enabling might failed or ignored by the controller, if
 		// it had reached the maximum number of concurrent connections.
 		// So we also re-enable the advertising when a connection disconnected
-		h.params.RLock()
-		if h.params.advEnable.AdvertisingEnable == 1 {
-			go h.Send(&h.params.advEnable, nil)
-		}
-		h.params.RUnlock()
+		h.reenableAdvertising()
 	}
 	return nil
 }
 
+// addConn creates and registers the connection established by e.
+func (h *HCI) addConn(e evt.LEConnectionComplete) *Conn {
+	// The event buffer is reused once the handler returns.
+	c := newConn(h, append(evt.LEConnectionComplete(nil), e...))
+	h.muConns.Lock()
+	h.conns[e.ConnectionHandle()] = c
+	h.muConns.Unlock()
+	return c
+}
+
+// reenableAdvertising re-enables advertising in the background, if it was
+// advertising. This may fail with ErrDisallowed, if the controller was actually
+// still advertising. It does no harm though.
+func (h *HCI) reenableAdvertising() {
+	h.params.RLock()
+	defer h.params.RUnlock()
+	if h.params.advEnable.AdvertisingEnable != 1 {
+		return
+	}
+	f := h.SendAsync(h.advEnableCmd())
+	h.spawn(func() {
+		if err := f.Wait(context.Background()); err != nil && err != ErrDisallowed {
+			logger.Warn("can't re-enable advertising", "err", err)
+		}
+	})
+}
+
 func (h *HCI) handleLEConnectionUpdateComplete(b []byte) error {
 	return nil
 }
@@ -476,23 +1103,18 @@ func (h *HCI) handleDisconnectionComplete(b []byte) error {
 		return fmt.Errorf("disconnecting an invalid handle %04X", e.ConnectionHandle())
 	}
 	close(c.chInPkt)
+	c.reason = ErrCommand(e.Reason())
+	close(c.chDone)
 	if c.param.Role() == roleSlave {
 		// Re-enable advertising, if it was advertising. Refer to the
 		// handleLEConnectionComplete() for details.
 		// This may failed with ErrCommandDisallowed, if the controller
 		// was actually in advertising state. It does no harm though.
-		h.params.RLock()
-		if h.params.advEnable.AdvertisingEnable == 1 {
-			go h.Send(&h.params.advEnable, nil)
-		}
-		h.params.RUnlock()
-	} else {
-		// remote peripheral disconnected
-		close(c.chDone)
+		h.reenableAdvertising()
 	}
 	// When a connection disconnects, all the sent packets and weren't acked yet
 	// will be recycled. [Vol2, Part E 4.1.1]
-	c.txBuffer.PutAll()
+	c.txBuffer.Close()
 	return nil
 }
 
@@ -514,9 +1136,45 @@ func (h *HCI) handleNumberOfCompletedPackets(b []byte) error {
 	return nil
 }
 
+// conn returns the connection of the handle, or nil if there isn't one.
+func (h *HCI) conn(handle uint16) *Conn {
+	h.muConns.Lock()
+	defer h.muConns.Unlock()
+	return h.conns[handle]
+}
+
+// The SMP handles the encryption events on goroutines of their own, since
+// replying to the controller, or waiting for the SMP, would block the sktLoop.
+
+func (h *HCI) handleEncryptionChange(b []byte) error {
+	e := evt.EncryptionChange(b)
+	c := h.conn(e.ConnectionHandle())
+	if c == nil {
+		return nil
+	}
+	status, enabled := e.Status(), e.EncryptionEnabled() != 0x00
+	h.spawn(func() { c.handleEncryptionChange(status, enabled) })
+	return nil
+}
+
+func (h *HCI) handleEncryptionKeyRefreshComplete(b []byte) error {
+	e := evt.EncryptionKeyRefreshComplete(b)
+	c := h.conn(e.ConnectionHandle())
+	if c == nil {
+		return nil
+	}
+	status := e.Status()
+	h.spawn(func() { c.handleEncryptionChange(status, true) })
+	return nil
+}
+
 func (h *HCI) handleLELongTermKeyRequest(b []byte) error {
 	e := evt.LELongTermKeyRequest(b)
-	return h.Send(&cmd.LELongTermKeyRequestNegativeReply{
-		ConnectionHandle: e.ConnectionHandle(),
-	}, nil)
+	c := h.conn(e.ConnectionHandle())
+	if c == nil {
+		return nil
+	}
+	ediv, rand := e.EncryptionDiversifier(), e.RandomNumber()
+	h.spawn(func() { c.handleLTKRequest(ediv, rand) })
+	return nil
 }
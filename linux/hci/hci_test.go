@@ -0,0 +1,59 @@
+package hci
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/currantlabs/ble/linux/hci/evt"
+	"golang.org/x/net/context"
+)
+
+// deadSkt is the socket of a controller which doesn't respond.
+type deadSkt struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *deadSkt) Read(b []byte) (int, error) {
+	<-s.closed
+	return 0, io.EOF
+}
+
+func (s *deadSkt) Write(b []byte) (int, error) { return len(b), nil }
+
+func (s *deadSkt) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestShutdownUnresponsive(t *testing.T) {
+	h, err := NewHCI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.skt = &deadSkt{closed: make(chan struct{})}
+	h.bufSize, h.bufCnt = 27, 8
+	h.pool = NewPool(1+4+h.bufSize, h.bufCnt)
+	h.evth[evt.CommandCompleteCode] = h.handleCommandComplete
+	h.chCmdBufs <- make([]byte, cmdBufSize)
+	h.spawn(h.sktLoop)
+	c := newBenchConn(h)
+	h.params.advEnable.AdvertisingEnable = 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := h.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shut down in %s", d)
+	}
+	select {
+	case <-c.Disconnected():
+	default:
+		t.Error("connection not released")
+	}
+}
@@ -0,0 +1,53 @@
+package hci
+
+// LTK is a Long Term Key, and the EDIV and Rand identifying it. [Vol 3, Part H, 2.4.2]
+type LTK struct {
+	Key  [16]byte
+	EDIV uint16
+	Rand uint64
+}
+
+// IdentityAddr is the public device address or static random address, which
+// identifies a device using resolvable private addresses. [Vol 3, Part H, 3.6.5]
+type IdentityAddr struct {
+	Type uint8 // 0x00: public, 0x01: static random
+	Addr [6]byte
+}
+
+// Keys are the keys distributed in pairing with a remote device. [Vol 3, Part H, 2.4]
+// The keys that aren't distributed are nil.
+type Keys struct {
+	// KeySize is the encryption key size in octets, from 7 to 16.
+	KeySize int
+
+	// Authenticated is set if the pairing is protected against MITM attacks.
+	Authenticated bool
+
+	// SecureConnections is set if the keys are generated in LE Secure
+	// Connections, in which case LocalLTK and PeerLTK are the same key, with
+	// EDIV and Rand of 0.
+	SecureConnections bool
+
+	// LocalLTK is distributed by the local device, and encrypts the link when
+	// the remote device is the master. PeerLTK is distributed by the remote
+	// device, and encrypts the link when the local device is the master.
+	LocalLTK *LTK
+	PeerLTK  *LTK
+
+	// LocalIRK is the IRK distributed by the local device. PeerIRK and
+	// PeerIdentity identify the remote device.
+	LocalIRK     *[16]byte
+	PeerIRK      *[16]byte
+	PeerIdentity *IdentityAddr
+
+	// LocalCSRK signs the data sent by the local device, and PeerCSRK verifies
+	// the data signed by the remote device.
+	LocalCSRK *[16]byte
+	PeerCSRK  *[16]byte
+
+	// LocalSignCounter is the SignCounter of the next data signed by the
+	// local device, and PeerSignCounter is the lowest one accepted in the
+	// next data signed by the remote device. [Vol 3, Part C, 10.4.1]
+	LocalSignCounter uint32
+	PeerSignCounter  uint32
+}
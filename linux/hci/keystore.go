@@ -0,0 +1,392 @@
+package hci
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/currantlabs/ble"
+	"github.com/pkg/errors"
+)
+
+// A Bond is the keys of a remote device the local device has bonded with,
+// which is identified by its identity address.
+type Bond struct {
+	Peer IdentityAddr
+	Keys Keys
+}
+
+// A KeyStore persists the bonds, so the links to bonded devices can be
+// encrypted without pairing again.
+type KeyStore interface {
+	// Bonds returns all the bonds.
+	Bonds() ([]Bond, error)
+
+	// Save adds the bond, or replaces the one with the same peer.
+	Save(b Bond) error
+
+	// Delete removes the bond with the peer. It's not an error if there isn't one.
+	Delete(peer IdentityAddr) error
+}
+
+// String returns the address in the form of "01:23:45:67:89:AB".
+func (a IdentityAddr) String() string {
+	b := a.Addr
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", b[5], b[4], b[3], b[2], b[1], b[0])
+}
+
+// Bonds returns the bonds in the key store.
+func (h *HCI) Bonds() ([]Bond, error) {
+	if h.smp.keyStore == nil {
+		return nil, ErrNoKeyStore
+	}
+	return h.smp.keyStore.Bonds()
+}
+
+// DeleteBond removes the bond with the peer from the key store. The keys
+// already in use by a connection to the peer aren't affected.
+func (h *HCI) DeleteBond(peer IdentityAddr) error {
+	if h.smp.keyStore == nil {
+		return ErrNoKeyStore
+	}
+	return h.smp.keyStore.Delete(peer)
+}
+
+// peerAddr returns the address of the remote device, with which the connection
+// was established.
+func (c *Conn) peerAddr() IdentityAddr {
+	return IdentityAddr{Type: c.param.PeerAddressType() & 0x01, Addr: c.param.PeerAddress()}
+}
+
+// loadBond sets the keys of the connection to the ones of the bond with the
+// remote device, if there is one in the key store. A remote device using a
+// resolvable private address is identified by the IRK it has distributed.
+// The caller holds smpMu. [Vol 3, Part C, 10.8.2.3]
+func (c *Conn) loadBond() {
+	ks := c.hci.smp.keyStore
+	if ks == nil || c.keys != nil {
+		return
+	}
+	bonds, err := ks.Bonds()
+	if err != nil {
+		logger.Warn("can't load bonds", "err", err)
+		return
+	}
+	a := c.peerAddr()
+	for _, b := range bonds {
+		if b.Peer == a || b.Keys.PeerIRK != nil && resolveRPA(*b.Keys.PeerIRK, a) {
+			k, peer := b.Keys, b.Peer
+			c.keys, c.bond = &k, &peer
+			return
+		}
+	}
+}
+
+// bondLTK returns the LTK distributed by the remote device in a previous
+// bonding, if the link encrypted with it would have at least the security
+// level, or nil. The caller holds smpMu.
+func (c *Conn) bondLTK(level ble.SecurityLevel) *LTK {
+	c.loadBond()
+	k := c.keys
+	if k == nil || k.PeerLTK == nil || k.securityLevel() < level {
+		return nil
+	}
+	return k.PeerLTK
+}
+
+// saveBond stores the keys of the pairing, if both devices are bonding. The
+// bond with the peer isn't replaced by one of a lower security level, so a
+// device spoofing the address of a bonded peer can't downgrade its bond with
+// Just Works. The caller holds smpMu.
+func (c *Conn) saveBond(p *pairing) {
+	c.bond = nil
+	ks := c.hci.smp.keyStore
+	if ks == nil || len(p.preq) < 4 || len(p.pres) < 4 || p.preq[3]&p.pres[3]&authReqBonding == 0 {
+		return
+	}
+	b := Bond{Peer: c.peerAddr(), Keys: p.keys}
+	if p.keys.PeerIdentity != nil {
+		b.Peer = *p.keys.PeerIdentity
+	}
+	bonds, err := ks.Bonds()
+	if err != nil {
+		logger.Warn("can't load bonds", "err", err)
+		return
+	}
+	for _, o := range bonds {
+		if o.Peer == b.Peer && o.Keys.securityLevel() > b.Keys.securityLevel() {
+			logger.Warn("smp", "bond not downgraded", b.Peer, "level", o.Keys.securityLevel())
+			return
+		}
+	}
+	if err := ks.Save(b); err != nil {
+		logger.Warn("can't save bond", "peer", b.Peer, "err", err)
+		return
+	}
+	c.bond = &b.Peer
+}
+
+// updateBond stores the keys of the connection, if they are the ones of a
+// bond, after the sign counters have changed. The caller holds smpMu.
+func (c *Conn) updateBond() {
+	ks := c.hci.smp.keyStore
+	if ks == nil || c.bond == nil {
+		return
+	}
+	if err := ks.Save(Bond{Peer: *c.bond, Keys: *c.keys}); err != nil {
+		logger.Warn("can't save bond", "peer", *c.bond, "err", err)
+	}
+}
+
+// localIRK returns the IRK of the local device. It's the one distributed in the
+// bonds of the key store, if there are any, so the bonded devices keep
+// resolving the private addresses of the local device after a restart, or the
+// one generated at start. [Vol 3, Part H, 2.4.2.1]
+func (h *HCI) localIRK() [16]byte {
+	if ks := h.smp.keyStore; ks != nil {
+		bonds, err := ks.Bonds()
+		if err != nil {
+			logger.Warn("can't load bonds", "err", err)
+		}
+		for _, b := range bonds {
+			if b.Keys.LocalIRK != nil {
+				return *b.Keys.LocalIRK
+			}
+		}
+	}
+	return h.smp.irk
+}
+
+// resolveRPA returns true if the address is a resolvable private address
+// generated with the irk. [Vol 6, Part B, 1.3.2.3]
+func resolveRPA(irk [16]byte, a IdentityAddr) bool {
+	// The two most significant bits of a resolvable private address are 0b01.
+	if a.Type != 0x01 || a.Addr[5]>>6 != 0x01 {
+		return false
+	}
+	var prand [3]byte
+	copy(prand[:], a.Addr[3:6])
+	hash := smpAh(irk, prand)
+	return hash[0] == a.Addr[0] && hash[1] == a.Addr[1] && hash[2] == a.Addr[2]
+}
+
+// FileKeyStore is a KeyStore, which keeps the bonds in a JSON file readable
+// and writable only by its owner.
+type FileKeyStore struct {
+	mu    sync.Mutex
+	path  string
+	bonds []Bond
+}
+
+// NewFileKeyStore returns a FileKeyStore with the bonds in the file at path,
+// which is created when a bond is saved, if it doesn't exist.
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read key store")
+	}
+	var jbs []jsonBond
+	if err := json.Unmarshal(b, &jbs); err != nil {
+		return nil, errors.Wrap(err, "can't parse key store")
+	}
+	for _, jb := range jbs {
+		bond, err := jb.bond()
+		if err != nil {
+			return nil, errors.Wrap(err, "can't parse key store")
+		}
+		s.bonds = append(s.bonds, bond)
+	}
+	return s, nil
+}
+
+// Bonds returns all the bonds.
+func (s *FileKeyStore) Bonds() ([]Bond, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Bond(nil), s.bonds...), nil
+}
+
+// Save adds the bond, or replaces the one with the same peer, and writes the file.
+func (s *FileKeyStore) Save(b Bond) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bonds := make([]Bond, 0, len(s.bonds)+1)
+	for _, o := range s.bonds {
+		if o.Peer != b.Peer {
+			bonds = append(bonds, o)
+		}
+	}
+	return s.write(append(bonds, b))
+}
+
+// Delete removes the bond with the peer, and writes the file.
+func (s *FileKeyStore) Delete(peer IdentityAddr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bonds := make([]Bond, 0, len(s.bonds))
+	for _, o := range s.bonds {
+		if o.Peer != peer {
+			bonds = append(bonds, o)
+		}
+	}
+	if len(bonds) == len(s.bonds) {
+		return nil
+	}
+	return s.write(bonds)
+}
+
+// write replaces the file with the bonds, through a temporary file, so the
+// file is never left partially written. The caller holds mu.
+func (s *FileKeyStore) write(bonds []Bond) error {
+	jbs := make([]jsonBond, 0, len(bonds))
+	for _, b := range bonds {
+		jbs = append(jbs, newJSONBond(b))
+	}
+	data, err := json.MarshalIndent(jbs, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "can't write key store")
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return errors.Wrap(err, "can't write key store")
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "can't write key store")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "can't write key store")
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return errors.Wrap(err, "can't write key store")
+	}
+	s.bonds = bonds
+	return nil
+}
+
+// jsonBond is the representation of a Bond in the file, with the keys in hex,
+// most significant octet first.
+type jsonBond struct {
+	Addr              string   `json:"addr"`
+	AddrType          uint8    `json:"addr_type"`
+	KeySize           int      `json:"key_size"`
+	Authenticated     bool     `json:"authenticated"`
+	SecureConnections bool     `json:"secure_connections"`
+	LocalLTK          *jsonLTK `json:"local_ltk,omitempty"`
+	PeerLTK           *jsonLTK `json:"peer_ltk,omitempty"`
+	LocalIRK          string   `json:"local_irk,omitempty"`
+	PeerIRK           string   `json:"peer_irk,omitempty"`
+	LocalCSRK         string   `json:"local_csrk,omitempty"`
+	PeerCSRK          string   `json:"peer_csrk,omitempty"`
+	LocalSignCounter  uint32   `json:"local_sign_counter,omitempty"`
+	PeerSignCounter   uint32   `json:"peer_sign_counter,omitempty"`
+}
+
+type jsonLTK struct {
+	Key  string `json:"key"`
+	EDIV uint16 `json:"ediv"`
+	Rand uint64 `json:"rand"`
+}
+
+func newJSONBond(b Bond) jsonBond {
+	k := &b.Keys
+	jb := jsonBond{
+		Addr:              b.Peer.String(),
+		AddrType:          b.Peer.Type,
+		KeySize:           k.KeySize,
+		Authenticated:     k.Authenticated,
+		SecureConnections: k.SecureConnections,
+		LocalIRK:          hexKey(k.LocalIRK),
+		PeerIRK:           hexKey(k.PeerIRK),
+		LocalCSRK:         hexKey(k.LocalCSRK),
+		PeerCSRK:          hexKey(k.PeerCSRK),
+		LocalSignCounter:  k.LocalSignCounter,
+		PeerSignCounter:   k.PeerSignCounter,
+	}
+	if k.LocalLTK != nil {
+		jb.LocalLTK = &jsonLTK{hexKey(&k.LocalLTK.Key), k.LocalLTK.EDIV, k.LocalLTK.Rand}
+	}
+	if k.PeerLTK != nil {
+		jb.PeerLTK = &jsonLTK{hexKey(&k.PeerLTK.Key), k.PeerLTK.EDIV, k.PeerLTK.Rand}
+	}
+	return jb
+}
+
+func (jb *jsonBond) bond() (Bond, error) {
+	var b Bond
+	if _, err := fmt.Sscanf(jb.Addr, "%02X:%02X:%02X:%02X:%02X:%02X",
+		&b.Peer.Addr[5], &b.Peer.Addr[4], &b.Peer.Addr[3],
+		&b.Peer.Addr[2], &b.Peer.Addr[1], &b.Peer.Addr[0]); err != nil {
+		return b, errors.Wrapf(err, "invalid address %q", jb.Addr)
+	}
+	b.Peer.Type = jb.AddrType
+	k := &b.Keys
+	k.KeySize, k.Authenticated, k.SecureConnections = jb.KeySize, jb.Authenticated, jb.SecureConnections
+	k.LocalSignCounter, k.PeerSignCounter = jb.LocalSignCounter, jb.PeerSignCounter
+	var err error
+	if k.LocalIRK, err = parseKey(jb.LocalIRK); err != nil {
+		return b, err
+	}
+	if k.PeerIRK, err = parseKey(jb.PeerIRK); err != nil {
+		return b, err
+	}
+	if k.LocalCSRK, err = parseKey(jb.LocalCSRK); err != nil {
+		return b, err
+	}
+	if k.PeerCSRK, err = parseKey(jb.PeerCSRK); err != nil {
+		return b, err
+	}
+	if k.LocalLTK, err = jb.LocalLTK.ltk(); err != nil {
+		return b, err
+	}
+	if k.PeerLTK, err = jb.PeerLTK.ltk(); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func (jl *jsonLTK) ltk() (*LTK, error) {
+	if jl == nil {
+		return nil, nil
+	}
+	k, err := parseKey(jl.Key)
+	if err != nil || k == nil {
+		return nil, errors.Errorf("invalid LTK %q", jl.Key)
+	}
+	return &LTK{Key: *k, EDIV: jl.EDIV, Rand: jl.Rand}, nil
+}
+
+func hexKey(k *[16]byte) string {
+	if k == nil {
+		return ""
+	}
+	s := swap16(*k)
+	return hex.EncodeToString(s[:])
+}
+
+func parseKey(s string) (*[16]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 16 {
+		return nil, errors.Errorf("invalid key %q", s)
+	}
+	var k [16]byte
+	copy(k[:], b)
+	k = swap16(k)
+	return &k, nil
+}
@@ -0,0 +1,217 @@
+package hci
+
+import (
+	"crypto/ecdh"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// AD types of the OOB data. [CSS, Part A, 1.6, 1.8, 1.16]
+const (
+	oobTK         = 0x10 // Security Manager TK Value
+	oobAddr       = 0x1B // LE Bluetooth Device Address
+	oobSCConfirm  = 0x22 // LE Secure Connections Confirmation Value
+	oobSCRandom   = 0x23 // LE Secure Connections Random Value
+	oobAddrLength = 7
+)
+
+// OOBData is the out-of-band data of a device, which another device receives
+// through a channel other than LE, such as NFC or a QR code, before pairing.
+// The pairing uses the OOB method if the devices have the OOB data of each
+// other in LE legacy pairing, or either one has the OOB data of the other in
+// LE Secure Connections. [Vol 3, Part H, 2.3.5.1]
+type OOBData struct {
+	// Addr is the address of the device the data belongs to.
+	Addr IdentityAddr
+
+	// TK is the TK of LE legacy pairing, or nil. If both devices pass the
+	// data with a TK to each other, the pairing uses the one of the master.
+	// [Vol 3, Part H, 2.3.5.4]
+	TK *[16]byte
+
+	// Confirm and Random are the confirm value and the random value of LE
+	// Secure Connections, or nil. [Vol 3, Part H, 2.3.5.6.4]
+	Confirm *[16]byte
+	Random  *[16]byte
+}
+
+// Bytes returns the OOB data in the format of AD structures, which is the one
+// of the OOB data blocks in NFC records. [Vol 3, Part C, 11]
+func (d *OOBData) Bytes() []byte {
+	b := []byte{oobAddrLength + 1, oobAddr}
+	b = append(b, d.Addr.Addr[:]...)
+	b = append(b, d.Addr.Type&0x01)
+	for _, f := range []struct {
+		typ uint8
+		v   *[16]byte
+	}{
+		{oobTK, d.TK},
+		{oobSCConfirm, d.Confirm},
+		{oobSCRandom, d.Random},
+	} {
+		if f.v != nil {
+			b = append(b, 17, f.typ)
+			b = append(b, f.v[:]...)
+		}
+	}
+	return b
+}
+
+// ParseOOBData parses the OOB data in the format of AD structures. Unknown AD
+// types are ignored, but the address of the device is required.
+func ParseOOBData(b []byte) (*OOBData, error) {
+	d := &OOBData{}
+	hasAddr := false
+	for len(b) > 0 {
+		n := int(b[0])
+		if n == 0 {
+			// Zero padding of the significant part. [Vol 3, Part C, 11]
+			break
+		}
+		if n+1 > len(b) {
+			return nil, errors.Wrap(ErrInvalidOOBData, "truncated AD structure")
+		}
+		typ, v := b[1], b[2:n+1]
+		b = b[n+1:]
+		switch typ {
+		case oobAddr:
+			if len(v) != oobAddrLength {
+				return nil, errors.Wrapf(ErrInvalidOOBData, "address of %d octets", len(v))
+			}
+			copy(d.Addr.Addr[:], v)
+			d.Addr.Type = v[6] & 0x01
+			hasAddr = true
+		case oobTK, oobSCConfirm, oobSCRandom:
+			if len(v) != 16 {
+				return nil, errors.Wrapf(ErrInvalidOOBData, "AD type 0x%02X of %d octets", typ, len(v))
+			}
+			k := new([16]byte)
+			copy(k[:], v)
+			switch typ {
+			case oobTK:
+				d.TK = k
+			case oobSCConfirm:
+				d.Confirm = k
+			default:
+				d.Random = k
+			}
+		}
+	}
+	if !hasAddr {
+		return nil, errors.Wrap(ErrInvalidOOBData, "no address")
+	}
+	return d, nil
+}
+
+// oobConfig is the OOB data of the local device, and the ones received from
+// remote devices.
+type oobConfig struct {
+	sync.Mutex
+	local *OOBData
+	priv  *ecdh.PrivateKey // Key pair the confirm value of the local data is computed with.
+	lpk   [64]byte
+	peers map[IdentityAddr]*OOBData
+}
+
+// LocalOOBData generates the OOB data of the local device, which has both the
+// TK of LE legacy pairing, and the confirm and random values of LE Secure
+// Connections. It replaces the data generated previously, so it has to be
+// passed to the remote devices again. In LE legacy pairing, where both devices
+// pass the OOB data to each other, the TK generated by the master is used.
+// [Vol 3, Part H, 2.3.5.6.4]
+func (h *HCI) LocalOOBData() (*OOBData, error) {
+	tk, err := randKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't generate TK")
+	}
+	r, err := randKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't generate random value")
+	}
+	priv, pk, err := smpKeyPair()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't generate key pair")
+	}
+
+	// The confirm value binds the random value to the public key, which is
+	// used in the pairings with the OOB data.
+	confirm := smpF4(pk[:32], pk[:32], r, 0)
+	d := &OOBData{TK: &tk, Confirm: &confirm, Random: &r}
+	if a := h.randAddr; a != nil {
+		d.Addr = IdentityAddr{Type: 0x01, Addr: *a}
+	} else {
+		a := h.addr
+		for i := 0; i < 6 && i < len(a); i++ {
+			d.Addr.Addr[i] = a[len(a)-1-i]
+		}
+	}
+
+	o := &h.smp.oob
+	o.Lock()
+	defer o.Unlock()
+	o.local, o.priv, o.lpk = d, priv, pk
+	return d, nil
+}
+
+// SetPeerOOBData sets the OOB data received from the remote device with the
+// address of the data, which is used in the following pairings with it. The
+// data is removed if d has neither the TK nor the LE Secure Connections values.
+func (h *HCI) SetPeerOOBData(d *OOBData) {
+	o := &h.smp.oob
+	o.Lock()
+	defer o.Unlock()
+	if d.TK == nil && (d.Confirm == nil || d.Random == nil) {
+		delete(o.peers, d.Addr)
+		return
+	}
+	if o.peers == nil {
+		o.peers = make(map[IdentityAddr]*OOBData)
+	}
+	dd := *d
+	o.peers[d.Addr] = &dd
+}
+
+// peerOOBData returns the OOB data received from the remote device, or nil.
+func (c *Conn) peerOOBData() *OOBData {
+	o := &c.hci.smp.oob
+	o.Lock()
+	defer o.Unlock()
+	return o.peers[c.peerAddr()]
+}
+
+// localOOBData returns the OOB data of the local device, and the key pair the
+// confirm value is computed with, or nil if it hasn't been generated.
+func (c *Conn) localOOBData() (d *OOBData, priv *ecdh.PrivateKey, pk [64]byte) {
+	o := &c.hci.smp.oob
+	o.Lock()
+	defer o.Unlock()
+	return o.local, o.priv, o.lpk
+}
+
+// oobTK returns the TK of LE legacy pairing shared through the OOB data, or nil.
+// If both devices have generated a TK, the one of the master is used, so both
+// devices agree on it. Otherwise, the only one generated is used.
+func (c *Conn) oobTK(peer *OOBData) *[16]byte {
+	var remote *[16]byte
+	if peer != nil {
+		remote = peer.TK
+	}
+	var local *[16]byte
+	if d, _, _ := c.localOOBData(); d != nil {
+		local = d.TK
+	}
+	if c.param.Role() == roleMaster && local != nil || remote == nil {
+		return local
+	}
+	return remote
+}
+
+// oobFlag returns the OOB data flag of the Pairing Request and Response, which
+// is set if the OOB data of the remote device is present. [Vol 3, Part H, 3.5.1]
+func (c *Conn) oobFlag() uint8 {
+	if c.peerOOBData() != nil {
+		return 0x01
+	}
+	return 0x00
+}
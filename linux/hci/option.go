@@ -1,8 +1,12 @@
 package hci
 
 import (
+	"net"
 	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/currantlabs/ble"
 	"github.com/currantlabs/ble/linux/hci/cmd"
 )
 
@@ -33,6 +37,76 @@ func OptListenerTimeout(d time.Duration) Option {
 	}
 }
 
+// OptSignalingTimeout sets the RTX timer of the L2CAP signaling requests, which
+// ranges from 1 to 60 seconds [Vol 3, Part A, 6.2.1]. Default is 30 seconds.
+func OptSignalingTimeout(d time.Duration) Option {
+	return func(h *HCI) error {
+		if d < sigRTXMin || d > sigRTXMax {
+			return errors.Errorf("signaling timeout out of range: %s", d)
+		}
+		h.sigRTX = d
+		return nil
+	}
+}
+
+// OptPasskey sets the static passkey, from 0 to 999999, which is displayed, or
+// entered, in pairing with Passkey Entry. It makes the device a DisplayOnly
+// device requiring MITM protection. Without it, the device has no input or
+// output capability, and pairs with Just Works. [Vol 3, Part H, 2.3.5.1]
+func OptPasskey(passkey int) Option {
+	return func(h *HCI) error {
+		if passkey < 0 || passkey > 999999 {
+			return errors.Errorf("passkey out of range: %d", passkey)
+		}
+		h.smp.ioCap = ioDisplayOnly
+		h.smp.authReq |= authReqMITM
+		h.smp.needMITM = true
+		h.smp.passkey = passkey
+		return nil
+	}
+}
+
+// OptAgent sets the agent, which interacts with the user in pairing, with the
+// IO capability it declares. Unless the agent has no input or output
+// capability, MITM protection is requested, though Just Works is still
+// accepted if the remote device can't provide it. A passkey set with
+// OptPasskey is used instead of displaying or requesting one.
+func OptAgent(a Agent) Option {
+	return func(h *HCI) error {
+		io := a.IOCapability()
+		if io > KeyboardDisplay {
+			return errors.Errorf("invalid IO capability: %d", io)
+		}
+		h.smp.agent = a
+		h.smp.ioCap = uint8(io)
+		if io != NoInputNoOutput {
+			h.smp.authReq |= authReqMITM
+		}
+		if io == KeyboardOnly || io == KeyboardDisplay {
+			h.smp.authReq |= authReqKeypress
+		}
+		return nil
+	}
+}
+
+// OptSecureConnectionsOnly makes the device pair only with devices supporting
+// LE Secure Connections, using 128-bit keys. [Vol 3, Part H, 2.3.5.1]
+func OptSecureConnectionsOnly() Option {
+	return func(h *HCI) error {
+		h.smp.scOnly = true
+		return nil
+	}
+}
+
+// OptKeyStore sets the key store, in which the keys of bonded devices are
+// saved, and from which they are loaded to encrypt the links to them.
+func OptKeyStore(ks KeyStore) Option {
+	return func(h *HCI) error {
+		h.smp.keyStore = ks
+		return nil
+	}
+}
+
 // OptConnParams overrides default connection parameters.
 func OptConnParams(param cmd.LECreateConnection) Option {
 	return func(h *HCI) error {
@@ -40,3 +114,72 @@ func OptConnParams(param cmd.LECreateConnection) Option {
 		return nil
 	}
 }
+
+// OptPeriodicAdvParams overrides default periodic advertising parameters.
+// The AdvertisingHandle is ignored.
+func OptPeriodicAdvParams(param cmd.LESetPeriodicAdvertisingParameters) Option {
+	return func(h *HCI) error {
+		param.AdvertisingHandle = periodicAdvHandle
+		h.params.perAdvParams = param
+		return nil
+	}
+}
+
+// OptPeriodicSyncParams overrides default parameters for synchronizing to
+// periodic advertising. The advertiser is specified when calling SyncPeriodic.
+func OptPeriodicSyncParams(param cmd.LEPeriodicAdvertisingCreateSync) Option {
+	return func(h *HCI) error {
+		h.params.syncParams = param
+		return nil
+	}
+}
+
+// OptRandomAddress makes the device use the static random address addr, such
+// as "C0:11:22:33:44:55", instead of its public address in advertising,
+// scanning and initiating connections, and as its identity address in
+// pairing. It sets the OwnAddressType of the parameters to random, so it has
+// to come after OptConnParams. [Vol 6, Part B, 1.3.2.1]
+func OptRandomAddress(addr ble.Addr) Option {
+	return func(h *HCI) error {
+		b, err := net.ParseMAC(addr.String())
+		if err != nil || len(b) != 6 {
+			return ErrInvalidAddr
+		}
+		// The two most significant bits of a static address are 0b11, and
+		// the rest of the random part are neither all 0s nor all 1s.
+		a := [6]byte{b[5], b[4], b[3], b[2], b[1], b[0]}
+		r := a
+		r[5] &= 0x3F
+		if a[5]>>6 != 0x03 || r == [6]byte{} || r == [6]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x3F} {
+			return errors.Errorf("not a static random address: %s", addr)
+		}
+		h.randAddr = &a
+		h.params.advParams.OwnAddressType = 0x01
+		h.params.scanParams.OwnAddressType = 0x01
+		h.params.connParams.OwnAddressType = 0x01
+		return nil
+	}
+}
+
+// OptMgmt makes the HCI take the user channel of the device through the
+// kernel's Bluetooth management interface, so it coexists with BlueZ. The
+// device is powered off for the user channel, and handed back to the kernel in
+// its previous power state when the HCI is closed.
+func OptMgmt() Option {
+	return func(h *HCI) error {
+		h.mgmt = true
+		return nil
+	}
+}
+
+// OptExtendedAdvertising makes the HCI use the extended advertising, scanning
+// and initiating commands introduced in Bluetooth 5. The scanner then also
+// receives extended advertising PDUs, on both LE 1M and LE Coded PHYs if the
+// controller supports the latter. Init fails if the controller doesn't support
+// extended advertising.
+func OptExtendedAdvertising() Option {
+	return func(h *HCI) error {
+		h.extended = true
+		return nil
+	}
+}
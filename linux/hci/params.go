@@ -18,6 +18,10 @@ type params struct {
 	advParams  cmd.LESetAdvertisingParameters
 	scanParams cmd.LESetScanParameters
 	connParams cmd.LECreateConnection
+
+	perAdvEnable cmd.LESetPeriodicAdvertisingEnable
+	perAdvParams cmd.LESetPeriodicAdvertisingParameters
+	syncParams   cmd.LEPeriodicAdvertisingCreateSync
 }
 
 func (p *params) init() {
@@ -52,4 +56,149 @@ func (p *params) init() {
 		MinimumCELength:       0x0000,    // 0x0000 - 0xFFFF; N * 0.625 msec
 		MaximumCELength:       0x0000,    // 0x0000 - 0xFFFF; N * 0.625 msec
 	}
+	p.perAdvParams = cmd.LESetPeriodicAdvertisingParameters{
+		AdvertisingHandle:              periodicAdvHandle,
+		PeriodicAdvertisingIntervalMin: 0x0050, // 0x0006 - 0xFFFF; N * 1.25 msec
+		PeriodicAdvertisingIntervalMax: 0x0050, // 0x0006 - 0xFFFF; N * 1.25 msec
+		PeriodicAdvertisingProperties:  0x0000, // 0x0040: include TxPower
+	}
+	p.syncParams = cmd.LEPeriodicAdvertisingCreateSync{
+		Options:     0x00,   // Use the specified advertiser, report enabled.
+		Skip:        0x0000, // 0x0000 - 0x01F3; number of events that can be skipped
+		SyncTimeout: 0x07D0, // 0x000A - 0x4000; N * 10 msec
+		SyncCTEType: 0x00,   // Do not sync to packets with a specific type of CTE.
+	}
+}
+
+// Controllers that support extended advertising refuse the legacy advertising,
+// scanning and initiating commands once an extended one has been issued, and
+// vice versa [Vol 2, Part E, 3.1.1]. In extended mode, the following helpers
+// derive the extended commands from the legacy parameters, so the rest of the
+// HCI keeps a single set of parameters.
+
+// advEventProperties maps legacy advertising types to the properties of the
+// equivalent legacy PDUs used with extended advertising. [Vol 2, Part E, 7.8.53]
+var advEventProperties = map[uint8]uint16{
+	0x00: 0x0013, // ADV_IND
+	0x01: 0x001D, // ADV_DIRECT_IND (high duty cycle)
+	0x02: 0x0012, // ADV_SCAN_IND
+	0x03: 0x0010, // ADV_NONCONN_IND
+	0x04: 0x0015, // ADV_DIRECT_IND (low duty cycle)
+}
+
+// extAdvParams returns the parameters of the advertising set used by the
+// legacy advertising API in extended mode.
+func (p *params) extAdvParams() *cmd.LESetExtendedAdvertisingParameters {
+	a := p.advParams
+	min, max := a.AdvertisingIntervalMin, a.AdvertisingIntervalMax
+	return &cmd.LESetExtendedAdvertisingParameters{
+		AdvertisingHandle:             legacyAdvHandle,
+		AdvertisingEventProperties:    advEventProperties[a.AdvertisingType],
+		PrimaryAdvertisingIntervalMin: [3]byte{byte(min), byte(min >> 8), 0},
+		PrimaryAdvertisingIntervalMax: [3]byte{byte(max), byte(max >> 8), 0},
+		PrimaryAdvertisingChannelMap:  a.AdvertisingChannelMap,
+		OwnAddressType:                a.OwnAddressType,
+		PeerAddressType:               a.DirectAddressType,
+		PeerAddress:                   a.DirectAddress,
+		AdvertisingFilterPolicy:       a.AdvertisingFilterPolicy,
+		AdvertisingTxPower:            0x7F, // Host has no preference.
+		PrimaryAdvertisingPHY:         0x01, // LE 1M
+		SecondaryAdvertisingMaxSkip:   0x00,
+		SecondaryAdvertisingPHY:       0x01, // LE 1M
+		AdvertisingSID:                0x00,
+		ScanRequestNotificationEnable: 0x00,
+	}
+}
+
+// extAdvEnable returns the command that applies advEnable to the advertising
+// set used by the legacy advertising API in extended mode.
+func (p *params) extAdvEnable() *cmd.LESetExtendedAdvertisingEnable {
+	return &cmd.LESetExtendedAdvertisingEnable{
+		Enable:            p.advEnable.AdvertisingEnable,
+		NumberOfSets:      1,
+		AdvertisingHandle: legacyAdvHandle,
+	}
+}
+
+// extAdvData returns the advertising data of the advertising set used by the
+// legacy advertising API in extended mode.
+func (p *params) extAdvData() *cmd.LESetExtendedAdvertisingData {
+	c := &cmd.LESetExtendedAdvertisingData{
+		AdvertisingHandle:     legacyAdvHandle,
+		Operation:             0x03, // Complete data
+		FragmentPreference:    0x01, // Controller should not fragment
+		AdvertisingDataLength: p.advData.AdvertisingDataLength,
+	}
+	copy(c.AdvertisingData[:], p.advData.AdvertisingData[:])
+	return c
+}
+
+// extScanResp returns the scan response of the advertising set used by the
+// legacy advertising API in extended mode.
+func (p *params) extScanResp() *cmd.LESetExtendedScanResponseData {
+	c := &cmd.LESetExtendedScanResponseData{
+		AdvertisingHandle:      legacyAdvHandle,
+		Operation:              0x03, // Complete data
+		FragmentPreference:     0x01, // Controller should not fragment
+		ScanResponseDataLength: p.scanResp.ScanResponseDataLength,
+	}
+	copy(c.ScanResponseData[:], p.scanResp.ScanResponseData[:])
+	return c
+}
+
+// perExtAdvParams returns the parameters of the advertising set used for
+// periodic advertising, which has to be non-connectable and non-scannable.
+func (p *params) perExtAdvParams() *cmd.LESetExtendedAdvertisingParameters {
+	c := p.extAdvParams()
+	c.AdvertisingHandle = periodicAdvHandle
+	c.AdvertisingEventProperties = 0x0000 // Non-connectable and non-scannable
+	c.PeerAddressType, c.PeerAddress = 0, [6]byte{}
+	c.AdvertisingSID = periodicAdvHandle
+	return c
+}
+
+// extScanParams returns the extended scan parameters, which apply the legacy
+// ones to the LE 1M PHY, and to the LE Coded PHY if coded is set.
+func (p *params) extScanParams(coded bool) *cmd.LESetExtendedScanParameters {
+	s := p.scanParams
+	c := &cmd.LESetExtendedScanParameters{
+		OwnAddressType:       s.OwnAddressType,
+		ScanningFilterPolicy: s.ScanningFilterPolicy,
+		ScanningPHYs:         0x01, // LE 1M
+		ScanType:             [2]uint8{s.LEScanType, s.LEScanType},
+		ScanInterval:         [2]uint16{s.LEScanInterval, s.LEScanInterval},
+		ScanWindow:           [2]uint16{s.LEScanWindow, s.LEScanWindow},
+	}
+	if coded {
+		c.ScanningPHYs |= 0x04 // LE Coded
+	}
+	return c
+}
+
+// extScanEnable returns the extended equivalent of scanEnable.
+func (p *params) extScanEnable() *cmd.LESetExtendedScanEnable {
+	return &cmd.LESetExtendedScanEnable{
+		Enable:           p.scanEnable.LEScanEnable,
+		FilterDuplicates: p.scanEnable.FilterDuplicates,
+	}
+}
+
+// extConnParams returns the extended equivalent of c, which initiates
+// connections on the LE 1M PHY.
+func extConnParams(c cmd.LECreateConnection) *cmd.LEExtendedCreateConnection {
+	return &cmd.LEExtendedCreateConnection{
+		InitiatorFilterPolicy: c.InitiatorFilterPolicy,
+		OwnAddressType:        c.OwnAddressType,
+		PeerAddressType:       c.PeerAddressType,
+		PeerAddress:           c.PeerAddress,
+		InitiatingPHYs:        0x01, // LE 1M
+		ScanInterval:          c.LEScanInterval,
+		ScanWindow:            c.LEScanWindow,
+		ConnIntervalMin:       c.ConnIntervalMin,
+		ConnIntervalMax:       c.ConnIntervalMax,
+		ConnLatency:           c.ConnLatency,
+		SupervisionTimeout:    c.SupervisionTimeout,
+		MinimumCELength:       c.MinimumCELength,
+		MaximumCELength:       c.MaximumCELength,
+	}
 }
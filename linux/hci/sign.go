@@ -0,0 +1,51 @@
+package hci
+
+import (
+	"encoding/binary"
+
+	"github.com/currantlabs/ble"
+	"github.com/pkg/errors"
+)
+
+// SignData returns the signature of the data, which is signed with the CSRK
+// distributed by the local device, and increments the sign counter.
+// [Vol 3, Part C, 10.4.1]
+func (c *Conn) SignData(data []byte) ([12]byte, error) {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	c.loadBond()
+	if c.keys == nil || c.keys.LocalCSRK == nil {
+		return [12]byte{}, ErrNoCSRK
+	}
+	sig := smpSign(*c.keys.LocalCSRK, data, c.keys.LocalSignCounter)
+	c.keys.LocalSignCounter++
+	c.updateBond()
+	return sig, nil
+}
+
+// VerifyData verifies the signature of the data signed by the remote device,
+// with the CSRK it has distributed, and returns the security level of the CSRK.
+// The data with a SignCounter lower than the one following the last data
+// verified is rejected, so it can't be replayed. [Vol 3, Part C, 10.4.2]
+func (c *Conn) VerifyData(data []byte, sig [12]byte) (ble.SecurityLevel, error) {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	c.loadBond()
+	k := c.keys
+	if k == nil || k.PeerCSRK == nil {
+		return ble.SecurityNone, ErrNoCSRK
+	}
+	counter := binary.LittleEndian.Uint32(sig[0:4])
+	if counter < k.PeerSignCounter {
+		return ble.SecurityNone, errors.Wrapf(ErrSignature, "SignCounter %d replayed", counter)
+	}
+	if smpSign(*k.PeerCSRK, data, counter) != sig {
+		return ble.SecurityNone, ErrSignature
+	}
+	k.PeerSignCounter = counter + 1
+	c.updateBond()
+	if k.Authenticated {
+		return ble.SecurityAuthenticated, nil
+	}
+	return ble.SecurityUnauthenticated, nil
+}
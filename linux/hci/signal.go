@@ -1,12 +1,14 @@
 package hci
 
 import (
-	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"io"
 	"time"
 
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
 	"github.com/currantlabs/ble/linux/hci/cmd"
 )
 
@@ -17,6 +19,9 @@ type Signal interface {
 	Unmarshal([]byte) error
 }
 
+// sigCmd is a signaling command, which has a header of 4 octets followed by
+// the data of the length in the header. It's sliced from the L2CAP packet once
+// the length has been validated. [Vol 3, Part A, 4]
 type sigCmd []byte
 
 func (s sigCmd) code() int    { return int(s[0]) }
@@ -24,57 +29,161 @@ func (s sigCmd) id() uint8    { return s[1] }
 func (s sigCmd) len() int     { return int(binary.LittleEndian.Uint16(s[2:4])) }
 func (s sigCmd) data() []byte { return s[4 : 4+s.len()] }
 
-// Signal ...
+// Signaling Response Timeout eXpired (RTX) timer [Vol 3, Part A, 6.2.1]
+const (
+	sigRTXMin     = 1 * time.Second
+	sigRTXMax     = 60 * time.Second
+	sigRTXDefault = 30 * time.Second
+)
+
+// sigTxn is an outstanding signaling request, waiting for its response.
+type sigTxn struct {
+	rsp chan sigCmd
+}
+
+// Signal sends a signaling request, and waits for its response.
+// Requests can be sent concurrently from multiple goroutines.
 func (c *Conn) Signal(req Signal, rsp Signal) error {
+	return c.signal(context.Background(), req, rsp)
+}
+
+// signal sends a signaling request, and waits for its response until the RTX
+// timer expires or ctx is done. A Command Reject is returned as a *RejectError.
+func (c *Conn) signal(ctx context.Context, req Signal, rsp Signal) error {
+	t := &sigTxn{rsp: make(chan sigCmd, 1)}
+	id, err := c.addSigTxn(t)
+	if err != nil {
+		return err
+	}
+	defer c.removeSigTxn(id)
+
 	data := req.Marshal()
-	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, uint16(4+len(data)))
-	binary.Write(buf, binary.LittleEndian, uint16(cidLESignal))
-
-	binary.Write(buf, binary.LittleEndian, uint8(req.Code()))
-	binary.Write(buf, binary.LittleEndian, uint8(c.sigID))
-	binary.Write(buf, binary.LittleEndian, uint16(len(data)))
-	binary.Write(buf, binary.LittleEndian, data)
-
-	c.sigSent = make(chan []byte)
-	defer close(c.sigSent)
-	if _, err := c.writePDU(buf.Bytes()); err != nil {
+	if _, err := c.writePDU(cidLESignal, sigHdr(uint8(req.Code()), id, len(data)), data); err != nil {
 		return err
 	}
+
+	rtx := time.NewTimer(c.hci.sigRTX)
+	defer rtx.Stop()
 	var s sigCmd
 	select {
-	case s = <-c.sigSent:
-	case <-time.After(time.Second):
-		// TODO: Find the proper timed out defined in spec, if any.
-		return errors.New("signaling request timed out")
+	case s = <-t.rsp:
+	case <-rtx.C:
+		return ErrSignalTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.chDone:
+		return io.ErrClosedPipe
 	}
 
-	if s.code() != req.Code() {
-		return errors.New("mismatched signaling response")
-	}
-	if s.id() != c.sigID {
-		return errors.New("mismatched signaling id")
+	if s.code() == SignalCommandReject {
+		var r CommandReject
+		if err := r.Unmarshal(s.data()); err != nil {
+			return errors.Wrap(err, "invalid command reject")
+		}
+		return &RejectError{Reason: RejectReason(r.Reason), Data: r.Data}
 	}
-	c.sigID++
 	if rsp == nil {
 		return nil
 	}
+	if s.code() != rsp.Code() {
+		return errors.Errorf("mismatched signaling response: 0x%02X", s.code())
+	}
 	return rsp.Unmarshal(s.data())
 }
 
+// nextSigID returns the identifier for a new signaling command, which isn't
+// used by any outstanding request. 0x00 is an illegal identifier, and shall
+// never be used. [Vol 3, Part A, 4]
+// The caller must hold sigMu.
+func (c *Conn) nextSigID() (uint8, error) {
+	for i := 0; i < 0xFF; i++ {
+		if c.sigID++; c.sigID == 0 {
+			c.sigID++
+		}
+		if _, ok := c.sigPending[c.sigID]; !ok {
+			return c.sigID, nil
+		}
+	}
+	return 0, errors.New("too many outstanding signaling requests")
+}
+
+// addSigTxn allocates an identifier for the request.
+func (c *Conn) addSigTxn(t *sigTxn) (uint8, error) {
+	c.sigMu.Lock()
+	defer c.sigMu.Unlock()
+	id, err := c.nextSigID()
+	if err != nil {
+		return 0, err
+	}
+	c.sigPending[id] = t
+	return id, nil
+}
+
+func (c *Conn) removeSigTxn(id uint8) {
+	c.sigMu.Lock()
+	defer c.sigMu.Unlock()
+	delete(c.sigPending, id)
+}
+
+// handleSigResponse passes a response to the outstanding request of the same
+// identifier, and reports whether there is one.
+func (c *Conn) handleSigResponse(s sigCmd) bool {
+	c.sigMu.Lock()
+	defer c.sigMu.Unlock()
+	t, ok := c.sigPending[s.id()]
+	if !ok {
+		return false
+	}
+	// The PDU buffer is reused once the handling returns.
+	select {
+	case t.rsp <- append(sigCmd(nil), s...):
+	default:
+		// Duplicated response. Keep the first one.
+	}
+	return true
+}
+
+// sendCommand sends a signaling command, which has no response.
+func (c *Conn) sendCommand(r Signal) (int, error) {
+	c.sigMu.Lock()
+	id, err := c.nextSigID()
+	c.sigMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return c.sendResponse(uint8(r.Code()), id, r)
+}
+
 func (c *Conn) sendResponse(code uint8, id uint8, r Signal) (int, error) {
 	data := r.Marshal()
-	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, uint16(4+len(data)))
-	binary.Write(buf, binary.LittleEndian, uint16(cidLESignal))
-	binary.Write(buf, binary.LittleEndian, uint8(code))
-	binary.Write(buf, binary.LittleEndian, uint8(id))
-	binary.Write(buf, binary.LittleEndian, uint16(len(data)))
-	if err := binary.Write(buf, binary.LittleEndian, data); err != nil {
-		return 0, err
+	hdr := sigHdr(code, id, len(data))
+	logger.Debug("sig", "send", fmt.Sprintf("[%X%X]", hdr, data))
+	return c.writePDU(cidLESignal, hdr, data)
+}
+
+// rejectCommand responds to the request of the identifier with a Command
+// Reject, as the request isn't understood. [Vol 3, Part A, 4.1]
+func (c *Conn) rejectCommand(id uint8) {
+	c.sendResponse(SignalCommandReject, id, &CommandReject{Reason: 0x0000})
+}
+
+// sigHdr returns the header of a signaling command. [Vol 3, Part A, 4]
+func sigHdr(code uint8, id uint8, dlen int) []byte {
+	return []byte{code, id, uint8(dlen), uint8(dlen >> 8)}
+}
+
+// isSigResponse reports whether the code is of a response. [Vol 3, Part A, 4]
+func isSigResponse(code int) bool {
+	switch code {
+	case SignalCommandReject,
+		SignalDisconnectResponse,
+		SignalConnectionParameterUpdateResponse,
+		SignalLECreditBasedConnectionResponse,
+		SignalCreditBasedConnectionResponse,
+		SignalCreditBasedReconfigureResponse:
+		return true
 	}
-	logger.Debug("sig", "send", fmt.Sprintf("[%X]", buf.Bytes()))
-	return c.writePDU(buf.Bytes())
+	return false
 }
 
 func (c *Conn) handleSignal(p pdu) error {
@@ -95,8 +204,20 @@ func (c *Conn) handleSignal(p pdu) error {
 		return nil
 	}
 
-	s := sigCmd(p.payload())
-	for len(s) > 0 {
+	for b := p.payload(); len(b) > 0; {
+		// A command with the length beyond the packet is malformed, and so
+		// is the rest of the packet, which is discarded. A request gets a
+		// Command Reject, if its identifier is there. [Vol 3, Part A, 4.1]
+		if len(b) < 4 || len(b) < 4+int(binary.LittleEndian.Uint16(b[2:4])) {
+			logger.Warn("sig", "malformed command", fmt.Sprintf("[%X]", b))
+			if len(b) >= 2 && !isSigResponse(int(b[0])) {
+				c.rejectCommand(b[1])
+			}
+			return nil
+		}
+		s := sigCmd(b[:4+int(binary.LittleEndian.Uint16(b[2:4]))])
+		b = b[len(s):] // advance to the next command.
+
 		// Check if it's a supported request.
 		switch s.code() {
 		case SignalDisconnectRequest:
@@ -104,26 +225,21 @@ func (c *Conn) handleSignal(p pdu) error {
 		case SignalConnectionParameterUpdateRequest:
 			c.handleConnectionParameterUpdateRequest(s)
 		case SignalLECreditBasedConnectionRequest:
-			c.LECreditBasedConnectionRequest(s)
+			c.handleLECreditBasedConnectionRequest(s)
 		case SignalLEFlowControlCredit:
-			c.LEFlowControlCredit(s)
+			c.handleLEFlowControlCredit(s)
+		case SignalCreditBasedConnectionRequest:
+			c.handleCreditBasedConnectionRequest(s)
+		case SignalCreditBasedReconfigureRequest:
+			c.handleCreditBasedReconfigureRequest(s)
 		default:
-			// Check if it's a response to a sent command.
-			select {
-			case c.sigSent <- s:
-				continue
-			default:
+			// Check if it's a response to a sent command. Responses matching
+			// no outstanding request, such as the ones arriving after the RTX
+			// timer expired, are silently discarded.
+			if !c.handleSigResponse(s) && !isSigResponse(s.code()) {
+				c.rejectCommand(s.id())
 			}
-
-			c.sendResponse(
-				SignalCommandReject,
-				s.id(),
-				&CommandReject{
-					Reason: 0x0000, // Command not understood.
-				})
 		}
-		s = s[4+s.len():] // advance to next the packet.
-
 	}
 	return nil
 }
@@ -132,14 +248,21 @@ func (c *Conn) handleSignal(p pdu) error {
 func (c *Conn) handleDisconnectRequest(s sigCmd) {
 	var req DisconnectRequest
 	if err := req.Unmarshal(s.data()); err != nil {
+		c.rejectCommand(s.id())
+		return
+	}
+
+	// The DCID of a dynamic channel is the CID of our endpoint.
+	if ch := c.coc(req.DestinationCID); ch != nil {
+		c.handleCoCDisconnectRequest(s.id(), ch, &req)
 		return
 	}
 
 	// Send Command Reject when the DCID is unrecognized.
 	if req.DestinationCID != cidLEAtt {
 		endpoints := make([]byte, 4)
-		binary.LittleEndian.PutUint16(endpoints, req.SourceCID)
-		binary.LittleEndian.PutUint16(endpoints, req.DestinationCID)
+		binary.LittleEndian.PutUint16(endpoints[0:], req.DestinationCID)
+		binary.LittleEndian.PutUint16(endpoints[2:], req.SourceCID)
 		c.sendResponse(
 			SignalCommandReject,
 			s.id(),
@@ -174,17 +297,13 @@ func (c *Conn) handleConnectionParameterUpdateRequest(s sigCmd) {
 	// Request packet it shall respond with a Command Reject packet with reason
 	// 0x0000 (Command not understood).
 	if c.param.Role() != roleMaster {
-		c.sendResponse(
-			SignalCommandReject,
-			s.id(),
-			&CommandReject{
-				Reason: 0x0000, // Command not understood.
-			})
+		c.rejectCommand(s.id())
 
 		return
 	}
 	var req ConnectionParameterUpdateRequest
 	if err := req.Unmarshal(s.data()); err != nil {
+		c.rejectCommand(s.id())
 		return
 	}
 
@@ -211,13 +330,3 @@ func (c *Conn) handleConnectionParameterUpdateRequest(s sigCmd) {
 			Result: 0, // Accept.
 		})
 }
-
-// LECreditBasedConnectionRequest ...
-func (c *Conn) LECreditBasedConnectionRequest(s sigCmd) {
-	// TODO:
-}
-
-// LEFlowControlCredit ...
-func (c *Conn) LEFlowControlCredit(s sigCmd) {
-	// TODO:
-}
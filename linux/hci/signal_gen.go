@@ -20,13 +20,22 @@ func (s CommandReject) Code() int { return 0x01 }
 // Marshal serializes the command parameters into binary form.
 func (s *CommandReject) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.Reason)
+	binary.Write(buf, binary.LittleEndian, s.Data)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *CommandReject) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.Reason); err != nil {
+		return err
+	}
+	s.Data = make([]byte, buf.Len())
+	if err := binary.Read(buf, binary.LittleEndian, &s.Data); err != nil {
+		return err
+	}
+	return nil
 }
 
 // SignalDisconnectRequest is the code of Disconnect Request signaling packet.
@@ -44,13 +53,21 @@ func (s DisconnectRequest) Code() int { return 0x06 }
 // Marshal serializes the command parameters into binary form.
 func (s *DisconnectRequest) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.DestinationCID)
+	binary.Write(buf, binary.LittleEndian, s.SourceCID)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *DisconnectRequest) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.DestinationCID); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.SourceCID); err != nil {
+		return err
+	}
+	return nil
 }
 
 // SignalDisconnectResponse is the code of Disconnect Response signaling packet.
@@ -68,13 +85,21 @@ func (s DisconnectResponse) Code() int { return 0x07 }
 // Marshal serializes the command parameters into binary form.
 func (s *DisconnectResponse) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.DestinationCID)
+	binary.Write(buf, binary.LittleEndian, s.SourceCID)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *DisconnectResponse) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.DestinationCID); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.SourceCID); err != nil {
+		return err
+	}
+	return nil
 }
 
 // SignalConnectionParameterUpdateRequest is the code of Connection Parameter Update Request signaling packet.
@@ -94,13 +119,29 @@ func (s ConnectionParameterUpdateRequest) Code() int { return 0x12 }
 // Marshal serializes the command parameters into binary form.
 func (s *ConnectionParameterUpdateRequest) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.IntervalMin)
+	binary.Write(buf, binary.LittleEndian, s.IntervalMax)
+	binary.Write(buf, binary.LittleEndian, s.SlaveLatency)
+	binary.Write(buf, binary.LittleEndian, s.TimeoutMultiplier)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *ConnectionParameterUpdateRequest) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.IntervalMin); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.IntervalMax); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.SlaveLatency); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.TimeoutMultiplier); err != nil {
+		return err
+	}
+	return nil
 }
 
 // SignalConnectionParameterUpdateResponse is the code of Connection Parameter Update Response signaling packet.
@@ -117,13 +158,17 @@ func (s ConnectionParameterUpdateResponse) Code() int { return 0x13 }
 // Marshal serializes the command parameters into binary form.
 func (s *ConnectionParameterUpdateResponse) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.Result)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *ConnectionParameterUpdateResponse) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.Result); err != nil {
+		return err
+	}
+	return nil
 }
 
 // SignalLECreditBasedConnectionRequest is the code of LE Credit Based Connection Request signaling packet.
@@ -144,13 +189,33 @@ func (s LECreditBasedConnectionRequest) Code() int { return 0x14 }
 // Marshal serializes the command parameters into binary form.
 func (s *LECreditBasedConnectionRequest) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.LEPSM)
+	binary.Write(buf, binary.LittleEndian, s.SourceCID)
+	binary.Write(buf, binary.LittleEndian, s.MTU)
+	binary.Write(buf, binary.LittleEndian, s.MPS)
+	binary.Write(buf, binary.LittleEndian, s.InitialCredits)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *LECreditBasedConnectionRequest) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.LEPSM); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.SourceCID); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MTU); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MPS); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.InitialCredits); err != nil {
+		return err
+	}
+	return nil
 }
 
 // SignalLECreditBasedConnectionResponse is the code of LE Credit Based Connection Response signaling packet.
@@ -158,11 +223,11 @@ const SignalLECreditBasedConnectionResponse = 0x15
 
 // LECreditBasedConnectionResponse implements LE Credit Based Connection Response (0x15) [Vol 3, Part A, 4.23].
 type LECreditBasedConnectionResponse struct {
-	DestinationCID    uint16
-	MTU               uint16
-	MPS               uint16
-	InitialCreditsCID uint16
-	Result            uint16
+	DestinationCID uint16
+	MTU            uint16
+	MPS            uint16
+	InitialCredits uint16
+	Result         uint16
 }
 
 // Code returns the event code of the command.
@@ -171,13 +236,33 @@ func (s LECreditBasedConnectionResponse) Code() int { return 0x15 }
 // Marshal serializes the command parameters into binary form.
 func (s *LECreditBasedConnectionResponse) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.DestinationCID)
+	binary.Write(buf, binary.LittleEndian, s.MTU)
+	binary.Write(buf, binary.LittleEndian, s.MPS)
+	binary.Write(buf, binary.LittleEndian, s.InitialCredits)
+	binary.Write(buf, binary.LittleEndian, s.Result)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *LECreditBasedConnectionResponse) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.DestinationCID); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MTU); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MPS); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.InitialCredits); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.Result); err != nil {
+		return err
+	}
+	return nil
 }
 
 // SignalLEFlowControlCredit is the code of LE Flow Control Credit signaling packet.
@@ -195,11 +280,180 @@ func (s LEFlowControlCredit) Code() int { return 0x16 }
 // Marshal serializes the command parameters into binary form.
 func (s *LEFlowControlCredit) Marshal() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, s)
+	binary.Write(buf, binary.LittleEndian, s.CID)
+	binary.Write(buf, binary.LittleEndian, s.Credits)
 	return buf.Bytes()
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
 func (s *LEFlowControlCredit) Unmarshal(b []byte) error {
-	return binary.Read(bytes.NewBuffer(b), binary.LittleEndian, s)
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.CID); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.Credits); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SignalCreditBasedConnectionRequest is the code of Credit Based Connection Request signaling packet.
+const SignalCreditBasedConnectionRequest = 0x17
+
+// CreditBasedConnectionRequest implements Credit Based Connection Request (0x17) [Vol 3, Part A, 4.25].
+type CreditBasedConnectionRequest struct {
+	SPSM           uint16
+	MTU            uint16
+	MPS            uint16
+	InitialCredits uint16
+	SourceCID      []uint16
+}
+
+// Code returns the event code of the command.
+func (s CreditBasedConnectionRequest) Code() int { return 0x17 }
+
+// Marshal serializes the command parameters into binary form.
+func (s *CreditBasedConnectionRequest) Marshal() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	binary.Write(buf, binary.LittleEndian, s.SPSM)
+	binary.Write(buf, binary.LittleEndian, s.MTU)
+	binary.Write(buf, binary.LittleEndian, s.MPS)
+	binary.Write(buf, binary.LittleEndian, s.InitialCredits)
+	binary.Write(buf, binary.LittleEndian, s.SourceCID)
+	return buf.Bytes()
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (s *CreditBasedConnectionRequest) Unmarshal(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.SPSM); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MTU); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MPS); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.InitialCredits); err != nil {
+		return err
+	}
+	s.SourceCID = make([]uint16, buf.Len()/2)
+	if err := binary.Read(buf, binary.LittleEndian, &s.SourceCID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SignalCreditBasedConnectionResponse is the code of Credit Based Connection Response signaling packet.
+const SignalCreditBasedConnectionResponse = 0x18
+
+// CreditBasedConnectionResponse implements Credit Based Connection Response (0x18) [Vol 3, Part A, 4.26].
+type CreditBasedConnectionResponse struct {
+	MTU            uint16
+	MPS            uint16
+	InitialCredits uint16
+	Result         uint16
+	DestinationCID []uint16
+}
+
+// Code returns the event code of the command.
+func (s CreditBasedConnectionResponse) Code() int { return 0x18 }
+
+// Marshal serializes the command parameters into binary form.
+func (s *CreditBasedConnectionResponse) Marshal() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	binary.Write(buf, binary.LittleEndian, s.MTU)
+	binary.Write(buf, binary.LittleEndian, s.MPS)
+	binary.Write(buf, binary.LittleEndian, s.InitialCredits)
+	binary.Write(buf, binary.LittleEndian, s.Result)
+	binary.Write(buf, binary.LittleEndian, s.DestinationCID)
+	return buf.Bytes()
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (s *CreditBasedConnectionResponse) Unmarshal(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.MTU); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MPS); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.InitialCredits); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.Result); err != nil {
+		return err
+	}
+	s.DestinationCID = make([]uint16, buf.Len()/2)
+	if err := binary.Read(buf, binary.LittleEndian, &s.DestinationCID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SignalCreditBasedReconfigureRequest is the code of Credit Based Reconfigure Request signaling packet.
+const SignalCreditBasedReconfigureRequest = 0x19
+
+// CreditBasedReconfigureRequest implements Credit Based Reconfigure Request (0x19) [Vol 3, Part A, 4.27].
+type CreditBasedReconfigureRequest struct {
+	MTU            uint16
+	MPS            uint16
+	DestinationCID []uint16
+}
+
+// Code returns the event code of the command.
+func (s CreditBasedReconfigureRequest) Code() int { return 0x19 }
+
+// Marshal serializes the command parameters into binary form.
+func (s *CreditBasedReconfigureRequest) Marshal() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	binary.Write(buf, binary.LittleEndian, s.MTU)
+	binary.Write(buf, binary.LittleEndian, s.MPS)
+	binary.Write(buf, binary.LittleEndian, s.DestinationCID)
+	return buf.Bytes()
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (s *CreditBasedReconfigureRequest) Unmarshal(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.MTU); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.MPS); err != nil {
+		return err
+	}
+	s.DestinationCID = make([]uint16, buf.Len()/2)
+	if err := binary.Read(buf, binary.LittleEndian, &s.DestinationCID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SignalCreditBasedReconfigureResponse is the code of Credit Based Reconfigure Response signaling packet.
+const SignalCreditBasedReconfigureResponse = 0x1A
+
+// CreditBasedReconfigureResponse implements Credit Based Reconfigure Response (0x1A) [Vol 3, Part A, 4.28].
+type CreditBasedReconfigureResponse struct {
+	Result uint16
+}
+
+// Code returns the event code of the command.
+func (s CreditBasedReconfigureResponse) Code() int { return 0x1A }
+
+// Marshal serializes the command parameters into binary form.
+func (s *CreditBasedReconfigureResponse) Marshal() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	binary.Write(buf, binary.LittleEndian, s.Result)
+	return buf.Bytes()
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (s *CreditBasedReconfigureResponse) Unmarshal(b []byte) error {
+	buf := bytes.NewBuffer(b)
+	if err := binary.Read(buf, binary.LittleEndian, &s.Result); err != nil {
+		return err
+	}
+	return nil
 }
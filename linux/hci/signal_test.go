@@ -0,0 +1,182 @@
+package hci
+
+import (
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// expectSig registers an outstanding request of the id on c, as if it had been
+// sent, and returns the channel receiving its response.
+func expectSig(c *Conn, id uint8) chan sigCmd {
+	t := &sigTxn{rsp: make(chan sigCmd, 1)}
+	c.sigMu.Lock()
+	defer c.sigMu.Unlock()
+	c.sigPending[id] = t
+	return t.rsp
+}
+
+func TestSignalMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		pkt      string  // Payload of the L2CAP packet sent to the slave.
+		rejected []uint8 // Identifiers of the requests rejected, out of 0x07 to 0x09.
+	}{
+		{name: "single octet", pkt: "06"},
+		{name: "truncated header", pkt: "06 07 02", rejected: []uint8{0x07}},
+		{name: "length beyond packet", pkt: "06 07 04 00 40 00", rejected: []uint8{0x07}},
+		{name: "truncated response", pkt: "07 07 04 00 40 00"},
+		{name: "invalid disconnect request", pkt: "06 07 02 00 40 00", rejected: []uint8{0x07}},
+		{name: "unknown command", pkt: "20 07 00 00", rejected: []uint8{0x07}},
+		{name: "response without request", pkt: "13 07 02 00 00 00"},
+		{name: "malformed second command", pkt: "20 08 00 00 06 09 08 00 40 00 40 00", rejected: []uint8{0x08, 0x09}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newSMPPipe(t, nil, nil, nil)
+			rsps := map[uint8]chan sigCmd{}
+			for id := uint8(0x07); id <= 0x09; id++ {
+				rsps[id] = expectSig(p.mc, id)
+			}
+			b, err := hex.DecodeString(strings.Replace(tc.pkt, " ", "", -1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.mc.writePDU(cidLESignal, b); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range tc.rejected {
+				select {
+				case s := <-rsps[id]:
+					if s.code() != SignalCommandReject || !strings.HasPrefix(hex.EncodeToString(s.data()), "0000") {
+						t.Errorf("request 0x%02X: got [%X], want a Command Reject", id, []byte(s))
+					}
+				case <-time.After(time.Second):
+					t.Errorf("request 0x%02X not rejected", id)
+				}
+				delete(rsps, id)
+			}
+			time.Sleep(50 * time.Millisecond)
+			for id, ch := range rsps {
+				select {
+				case s := <-ch:
+					t.Errorf("request 0x%02X: got [%X], want none", id, []byte(s))
+				default:
+				}
+			}
+		})
+	}
+}
+
+func TestSignalReject(t *testing.T) {
+	p := newSMPPipe(t, nil, nil, nil)
+
+	// Only the slave sends the Connection Parameter Update Request, which the
+	// slave doesn't understand. [Vol 3, Part A, 4.20]
+	err := p.mc.Signal(&ConnectionParameterUpdateRequest{
+		IntervalMin:       0x0018,
+		IntervalMax:       0x0028,
+		TimeoutMultiplier: 0x0048,
+	}, &ConnectionParameterUpdateResponse{})
+	if e, ok := err.(*RejectError); !ok || e.Reason != RejectNotUnderstood {
+		t.Fatalf("err = %v, want a rejection of reason %s", err, RejectNotUnderstood)
+	}
+
+	// The Disconnect Request of an unknown channel is rejected with its CIDs.
+	err = p.mc.Signal(&DisconnectRequest{DestinationCID: 0x0070, SourceCID: 0x0071}, &DisconnectResponse{})
+	if e, ok := err.(*RejectError); !ok || e.Reason != RejectInvalidCID || hex.EncodeToString(e.Data) != "70007100" {
+		t.Fatalf("err = %v, want a rejection of reason %s", err, RejectInvalidCID)
+	}
+}
+
+func TestSignalTimeout(t *testing.T) {
+	p := newSMPPipe(t, nil, nil, nil)
+
+	// The LE Flow Control Credit has no response, so the RTX timer expires.
+	p.mc.hci.sigRTX = 50 * time.Millisecond
+	if err := p.mc.signal(context.Background(), &LEFlowControlCredit{CID: 0x0070}, nil); err != ErrSignalTimeout {
+		t.Fatalf("err = %v, want %v", err, ErrSignalTimeout)
+	}
+
+	// The context is done before the RTX timer expires.
+	p.mc.hci.sigRTX = sigRTXMax
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.mc.signal(ctx, &LEFlowControlCredit{CID: 0x0070}, nil); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	p.mc.sigMu.Lock()
+	n := len(p.mc.sigPending)
+	p.mc.sigMu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d requests outstanding", n)
+	}
+}
+
+func TestSignalID(t *testing.T) {
+	p := newSMPPipe(t, nil, nil, nil)
+	c := p.mc
+	c.sigMu.Lock()
+	defer c.sigMu.Unlock()
+
+	// The identifiers wrap around without using 0x00, and skip the ones of
+	// the outstanding requests.
+	c.sigID = 0xFD
+	c.sigPending[0xFF] = &sigTxn{}
+	for _, want := range []uint8{0xFE, 0x01} {
+		id, err := c.nextSigID()
+		if err != nil || id != want {
+			t.Fatalf("id = 0x%02X, %v, want 0x%02X", id, err, want)
+		}
+		c.sigPending[id] = &sigTxn{}
+	}
+	for id := 0x02; id < 0xFD; id++ {
+		c.sigPending[uint8(id)] = &sigTxn{}
+	}
+	if id, err := c.nextSigID(); err != nil || id != 0xFD {
+		t.Fatalf("id = 0x%02X, %v, want 0xFD", id, err)
+	}
+	c.sigPending[0xFD] = &sigTxn{}
+	if id, err := c.nextSigID(); err == nil {
+		t.Fatalf("id 0x%02X allocated with all outstanding", id)
+	}
+}
+
+func TestSignalConcurrent(t *testing.T) {
+	p := newSMPPipe(t, nil, nil, nil)
+
+	// Each request gets the response of its own, whichever is sent first.
+	var wg sync.WaitGroup
+	errc := make(chan error, 16)
+	for i := 0; i < cap(errc); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				err := p.mc.Signal(&ConnectionParameterUpdateRequest{}, &ConnectionParameterUpdateResponse{})
+				if _, ok := err.(*RejectError); !ok {
+					errc <- errors.Errorf("err = %v, want a rejection", err)
+				}
+				return
+			}
+			rsp := &DisconnectResponse{}
+			if err := p.mc.Signal(&DisconnectRequest{DestinationCID: cidLEAtt, SourceCID: cidLEAtt}, rsp); err != nil {
+				errc <- err
+				return
+			}
+			if rsp.DestinationCID != cidLEAtt || rsp.SourceCID != cidLEAtt {
+				errc <- errors.Errorf("mismatched response: %+v", rsp)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+}
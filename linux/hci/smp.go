@@ -1,9 +1,16 @@
 package hci
 
 import (
-	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"time"
+
+	"github.com/currantlabs/ble"
+	"github.com/currantlabs/ble/linux/hci/cmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -23,39 +30,1389 @@ const (
 	pairingKeypress          = 0x0E // Pairing Keypress Notification LE-U
 )
 
+// smpPDULen are the lengths of the SMP commands, including the Code.
+var smpPDULen = map[uint8]int{
+	pairingRequest:           7,
+	pairingResponse:          7,
+	pairingConfirm:           17,
+	pairingRandom:            17,
+	pairingFailed:            2,
+	encryptionInformation:    17,
+	masterIdentification:     11,
+	identiInformation:        17,
+	identityAddreInformation: 8,
+	signingInformation:       17,
+	securityRequest:          2,
+	pairingPublicKey:         65,
+	pairingDHKeyCheck:        17,
+	pairingKeypress:          2,
+}
+
+// IO Capabilities [Vol 3, Part H, 3.5.1]
+const (
+	ioDisplayOnly       = 0x00
+	ioDisplayYesNo      = 0x01
+	ioKeyboardOnly      = 0x02
+	ioNoInputNoOutput   = 0x03
+	ioKeyboardDisplay   = 0x04
+	ioCapabilityInvalid = 0x05
+)
+
+// AuthReq flags [Vol 3, Part H, 3.5.1]
+const (
+	authReqBonding  = 0x01
+	authReqMITM     = 0x04
+	authReqSC       = 0x08
+	authReqKeypress = 0x10
+)
+
+// Key Distribution flags [Vol 3, Part H, 3.6.1]
+const (
+	keyDistEnc  = 0x01 // LTK, EDIV and Rand
+	keyDistID   = 0x02 // IRK and Identity Address
+	keyDistSign = 0x04 // CSRK
+)
+
+const (
+	smpMinKeySize = 7
+	smpMaxKeySize = 16
+
+	// smpTimeout is the SMP timer, which stops the pairing if no command is
+	// queued or received for 30 seconds. [Vol 3, Part H, 3.4]
+	smpTimeout = 30 * time.Second
+)
+
+// Pairing methods [Vol 3, Part H, 2.3.5.1]
+const (
+	justWorks         = iota
+	passkeyInitInputs // The responder displays, and the initiator inputs.
+	passkeyRespInputs // The initiator displays, and the responder inputs.
+	passkeyBothInput
+	numericComparison // LE Secure Connections only.
+	oob               // Out of Band
+)
+
+// passkeyRounds is the number of rounds of Passkey Entry in LE Secure
+// Connections, one for each bit of the passkey. [Vol 3, Part H, 2.3.5.6.3]
+const passkeyRounds = 20
+
+// legacyMethods maps the IO capabilities of the initiator and the responder to
+// the pairing method. [Vol 3, Part H, 2.3.5.1]
+var legacyMethods = [5][5]int{
+	ioDisplayOnly:     {justWorks, justWorks, passkeyRespInputs, justWorks, passkeyRespInputs},
+	ioDisplayYesNo:    {justWorks, justWorks, passkeyRespInputs, justWorks, passkeyRespInputs},
+	ioKeyboardOnly:    {passkeyInitInputs, passkeyInitInputs, passkeyBothInput, justWorks, passkeyInitInputs},
+	ioNoInputNoOutput: {justWorks, justWorks, justWorks, justWorks, justWorks},
+	ioKeyboardDisplay: {passkeyInitInputs, passkeyInitInputs, passkeyRespInputs, justWorks, passkeyRespInputs},
+}
+
+// scMethods is the counterpart of legacyMethods for LE Secure Connections.
+var scMethods = [5][5]int{
+	ioDisplayOnly:     {justWorks, justWorks, passkeyRespInputs, justWorks, passkeyRespInputs},
+	ioDisplayYesNo:    {justWorks, numericComparison, passkeyRespInputs, justWorks, numericComparison},
+	ioKeyboardOnly:    {passkeyInitInputs, passkeyInitInputs, passkeyBothInput, justWorks, passkeyInitInputs},
+	ioNoInputNoOutput: {justWorks, justWorks, justWorks, justWorks, justWorks},
+	ioKeyboardDisplay: {passkeyInitInputs, numericComparison, passkeyRespInputs, justWorks, numericComparison},
+}
+
+// KeypressType is the type of a Pairing Keypress Notification, which the device
+// entering a passkey sends in LE Secure Connections. [Vol 3, Part H, 3.5.8]
+type KeypressType uint8
+
+// Keypress Notification types [Vol 3, Part H, 3.5.8]
+const (
+	KeypressEntryStarted   KeypressType = 0x00
+	KeypressDigitEntered   KeypressType = 0x01
+	KeypressDigitErased    KeypressType = 0x02
+	KeypressCleared        KeypressType = 0x03
+	KeypressEntryCompleted KeypressType = 0x04
+)
+
+// States of a pairing, other than expecting an SMP command. The zero value
+// expects nothing, so a pairing is in none of them until it gets there.
+const (
+	expectUser           = 0xFC // Waiting for the user to authorize the pairing.
+	expectEncryption     = 0xFD // Waiting for the link to be encrypted with the STK.
+	expectBondEncryption = 0xFE // Waiting for the link to be encrypted with the LTK of a bonding.
+	expectKeys           = 0xFF // Waiting for the keys distributed by the remote device.
+)
+
+// smpConfig is the pairing configuration of the local device.
+type smpConfig struct {
+	ioCap      uint8
+	authReq    uint8
+	needMITM   bool // Refuse pairing without MITM protection.
+	scOnly     bool // Refuse LE legacy pairing, and keys shorter than 16 octets.
+	maxKeySize int
+	keyDist    uint8    // Keys distributed and requested in bonding.
+	passkey    int      // Static passkey, or -1 if there isn't one.
+	irk        [16]byte // Generated at start, until a bond has stored one.
+	agent      Agent
+	keyStore   KeyStore
+	oob        oobConfig
+}
+
+func (s *smpConfig) init() error {
+	s.ioCap = ioNoInputNoOutput
+	s.authReq = authReqBonding | authReqSC
+	s.maxKeySize = smpMaxKeySize
+	s.keyDist = keyDistEnc | keyDistID | keyDistSign
+	s.passkey = -1
+	irk, err := randKey()
+	if err != nil {
+		return errors.Wrap(err, "can't generate IRK")
+	}
+	s.irk = irk
+	return nil
+}
+
+// pairing is the state of an ongoing pairing on a connection.
+type pairing struct {
+	initiator bool
+
+	// authorized is set if the local device requested the pairing, or the
+	// agent has accepted it. noBond is set if the agent refused bonding.
+	authorized bool
+	noBond     bool
+
+	// userWait is set while the agent is waiting for the user, and held is
+	// the SMP command received meanwhile, which is handled after the user
+	// responds.
+	userWait bool
+	held     []byte
+
+	// expect is the code of the next SMP command expected, or one of the states
+	// expectUser, expectEncryption, expectBondEncryption and expectKeys.
+	expect uint8
+
+	// auth is the AuthReq flags required in addition to the configured ones.
+	auth uint8
+
+	// preq and pres are the Pairing Request and Pairing Response commands.
+	preq []byte
+	pres []byte
+
+	// sc is set for LE Secure Connections, and keypress if both devices
+	// support Keypress Notifications. input is set if the local device
+	// enters the passkey.
+	sc       bool
+	keypress bool
+	input    bool
+
+	// enter is set if the passkey is yet to be entered by the user.
+	enter bool
+
+	method   int
+	initDist uint8 // Keys distributed by the initiator.
+	respDist uint8 // Keys distributed by the responder.
+	recvDist uint8 // Keys yet to be received from the remote device.
+
+	tk       [16]byte
+	lrand    [16]byte // Random value of the local device.
+	rrand    [16]byte // Random value of the remote device.
+	rconfirm [16]byte // Confirm value of the remote device.
+
+	// stk is the STK, or the LTK in LE Secure Connections, which encrypts the
+	// link at the end of the pairing.
+	stk [16]byte
+
+	// LE Secure Connections. passkey is the one in Passkey Entry, and round
+	// is the current round. lpk and rpk are the public keys of the local and
+	// remote devices.
+	priv    *ecdh.PrivateKey
+	lpk     [64]byte
+	rpk     [64]byte
+	dhkey   [32]byte
+	macKey  [16]byte
+	passkey uint32
+	round   int
+
+	// LE Secure Connections Out of Band. oobLocal and oobRemote are the random
+	// values of the OOB data of the local and remote devices, which are 0 if
+	// the other device doesn't have them, and oobConfirm is the confirm value
+	// of the remote device, if the local device has its OOB data.
+	oobLocal   [16]byte
+	oobRemote  [16]byte
+	oobConfirm *[16]byte
+
+	keys Keys
+
+	timer *time.Timer
+	done  chan struct{}
+	err   error
+}
+
+// newPairing starts a pairing. The caller holds smpMu.
+func (c *Conn) newPairing(initiator bool) *pairing {
+	p := &pairing{
+		initiator: initiator,
+		done:      make(chan struct{}),
+	}
+	p.timer = time.AfterFunc(smpTimeout, func() {
+		c.smpMu.Lock()
+		defer c.smpMu.Unlock()
+		if c.pairing != p {
+			return
+		}
+		// No further SMP commands are sent or accepted on the link after a
+		// timeout. [Vol 3, Part H, 3.4]
+		c.smpTimedOut = true
+		c.finishPairing(p, ErrPairingTimeout)
+	})
+	c.pairing = p
+	return p
+}
+
+// Pair pairs with the remote device, and returns when the link is encrypted
+// with the keys generated, or the pairing fails. [Vol 3, Part H, 2.1]
+// A master sends a Pairing Request, while a slave sends a Security Request, which
+// asks the master to pair or encrypt the link with the keys of a previous bonding.
+func (c *Conn) Pair(ctx context.Context) error {
+	return c.secure(ctx, ble.SecurityNone, false)
+}
+
+// SecurityLevel returns the security level of the link.
+func (c *Conn) SecurityLevel() ble.SecurityLevel {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	return c.securityLevel()
+}
+
+// KeySize returns the size in octets of the key the link is encrypted with,
+// or 0 if it isn't encrypted.
+func (c *Conn) KeySize() int {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	if !c.encrypted || c.encKeys == nil {
+		return 0
+	}
+	return c.encKeys.KeySize
+}
+
+// RaiseSecurity raises the security level of the link to at least level. A
+// master encrypts the link with the LTK of a previous bonding, if it provides
+// the level, and pairs otherwise. A slave sends a Security Request.
+// [Vol 3, Part C, 10.3.2]
+func (c *Conn) RaiseSecurity(ctx context.Context, level ble.SecurityLevel) error {
+	if c.SecurityLevel() >= level {
+		return nil
+	}
+	if err := c.secure(ctx, level, true); err != nil {
+		return err
+	}
+	if l := c.SecurityLevel(); l < level {
+		return errors.Wrapf(ErrSecurityLevel, "%s, want %s", l, level)
+	}
+	return nil
+}
+
+// secure pairs, or encrypts the link with the LTK of a bonding if bond is
+// set, requiring the AuthReq flags of the level. It returns when the link is
+// encrypted, or the pairing fails.
+func (c *Conn) secure(ctx context.Context, level ble.SecurityLevel, bond bool) error {
+	c.smpMu.Lock()
+	if c.smpTimedOut {
+		c.smpMu.Unlock()
+		return ErrPairingTimeout
+	}
+	p := c.pairing
+	if p == nil {
+		var auth uint8
+		if level >= ble.SecurityAuthenticated {
+			auth |= authReqMITM
+		}
+		if level >= ble.SecuritySecureConnections {
+			auth |= authReqSC
+		}
+		var err error
+		switch ltk := c.bondLTK(level); {
+		case c.param.Role() != roleMaster:
+			p, err = c.requestSecurity(auth)
+		case bond && ltk != nil:
+			p, err = c.encryptBond(ltk, auth)
+		default:
+			p, err = c.requestPairing(auth)
+		}
+		if err != nil {
+			c.smpMu.Unlock()
+			return err
+		}
+	}
+	c.smpMu.Unlock()
+
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.chDone:
+		return errors.Wrap(c.reason, "disconnected")
+	}
+}
+
+// Keys returns a copy of the keys of the last pairing, or nil if the
+// connection hasn't been paired.
+func (c *Conn) Keys() *Keys {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	if c.keys == nil {
+		return nil
+	}
+	k := *c.keys
+	return &k
+}
+
+// requestPairing sends a Pairing Request as the master. The caller holds smpMu.
+func (c *Conn) requestPairing(auth uint8) (*pairing, error) {
+	p := c.newPairing(true)
+	p.authorized, p.auth = true, auth
+	if err := c.sendPairingRequest(p); err != nil {
+		return nil, errors.Wrap(err, "can't send pairing request")
+	}
+	return p, nil
+}
+
+// sendPairingRequest sends the Pairing Request of the pairing.
+func (c *Conn) sendPairingRequest(p *pairing) error {
+	cfg := &c.hci.smp
+	auth, dist := cfg.authReq|p.auth, cfg.keyDist
+	if auth&authReqBonding == 0 || p.noBond {
+		auth &^= authReqBonding
+		dist = 0
+	}
+	p.preq = []byte{pairingRequest, cfg.ioCap, c.oobFlag(), auth, uint8(cfg.maxKeySize), dist, dist}
+	p.expect = pairingResponse
+	if err := c.sendPairing(p, p.preq); err != nil {
+		c.finishPairing(p, err)
+		return err
+	}
+	return nil
+}
+
+// requestSecurity sends a Security Request as the slave. The caller holds smpMu.
+func (c *Conn) requestSecurity(auth uint8) (*pairing, error) {
+	p := c.newPairing(false)
+	p.authorized, p.auth = true, auth
+	p.expect = pairingRequest
+	if err := c.sendPairing(p, []byte{securityRequest, c.hci.smp.authReq | auth}); err != nil {
+		c.finishPairing(p, err)
+		return nil, errors.Wrap(err, "can't send security request")
+	}
+	return p, nil
+}
+
 func (c *Conn) sendSMP(p pdu) error {
-	buf := bytes.NewBuffer(make([]byte, 0))
-	binary.Write(buf, binary.LittleEndian, uint16(4+len(p)))
-	binary.Write(buf, binary.LittleEndian, cidSMP)
-	binary.Write(buf, binary.LittleEndian, p)
-	_, err := c.writePDU(buf.Bytes())
-	logger.Debug("smp", "send", fmt.Sprintf("[%X]", buf.Bytes()))
+	logger.Debug("smp", "send", fmt.Sprintf("[%X]", []byte(p)))
+	_, err := c.writePDU(cidSMP, p)
+	return err
+}
+
+// sendPairing sends an SMP command of the pairing, and restarts its timer.
+func (c *Conn) sendPairing(p *pairing, b []byte) error {
+	p.timer.Reset(smpTimeout)
+	return c.sendSMP(b)
+}
+
+// finishPairing ends the pairing. The caller holds smpMu.
+func (c *Conn) finishPairing(p *pairing, err error) {
+	p.timer.Stop()
+	p.err = err
+	switch {
+	case err == nil && p.expect == expectKeys:
+		k := p.keys
+		c.keys = &k
+		c.saveBond(p)
+		logger.Info("smp", "paired", c.RemoteAddr(), "authenticated", k.Authenticated, "keysize", k.KeySize)
+	case err != nil:
+		logger.Info("smp", "pairing failed", c.RemoteAddr(), "err", err)
+	}
+	if a := c.hci.smp.agent; a != nil {
+		level := c.securityLevel()
+		c.hci.spawn(func() { a.PairingComplete(c, level, err) })
+	}
+	close(p.done)
+	c.pairing = nil
+}
+
+// failPairing sends a Pairing Failed, and ends the ongoing pairing, if any.
+// The caller holds smpMu. [Vol 3, Part H, 3.5.5]
+func (c *Conn) failPairing(reason PairingError) error {
+	err := c.sendSMP([]byte{pairingFailed, uint8(reason)})
+	if p := c.pairing; p != nil {
+		c.finishPairing(p, reason)
+	}
 	return err
 }
 
 func (c *Conn) handleSMP(p pdu) error {
-	logger.Debug("smp", "recv", fmt.Sprintf("[%X]", p))
-	code := p[0]
-	switch code {
+	b := p.payload()
+	logger.Debug("smp", "recv", fmt.Sprintf("[%X]", b))
+	if len(b) == 0 {
+		return nil
+	}
+	code := b[0]
+	n, ok := smpPDULen[code]
+	if !ok {
+		// If a packet is received with a reserved Code it shall be ignored. [Vol 3, Part H, 3.3]
+		return nil
+	}
+
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	if c.smpTimedOut {
+		return nil
+	}
+	if len(b) != n {
+		return c.failPairing(ErrPairingInvalidParams)
+	}
+	if p := c.pairing; p != nil {
+		p.timer.Reset(smpTimeout)
+		if p.userWait && code != pairingFailed && code != pairingKeypress {
+			// Only the next command of the remote device can arrive while
+			// the user is responding.
+			if p.held != nil {
+				return c.failPairing(ErrPairingUnspecified)
+			}
+			p.held = append([]byte(nil), b...)
+			return nil
+		}
+	}
+	return c.dispatchSMP(b)
+}
+
+// waitUser holds the pairing while ask interacts with the user through the
+// agent on a goroutine of its own. The function returned by ask then continues
+// the pairing with smpMu held, unless the pairing has ended meanwhile.
+// The caller holds smpMu.
+func (c *Conn) waitUser(p *pairing, ask func() func() error) {
+	p.userWait = true
+	c.hci.spawn(func() {
+		next := ask()
+		c.smpMu.Lock()
+		defer c.smpMu.Unlock()
+		if c.pairing != p {
+			return
+		}
+		p.userWait = false
+		if err := next(); err != nil {
+			logger.Warn("smp", "pairing", c.RemoteAddr(), "err", err)
+			return
+		}
+		if b := p.held; b != nil && c.pairing == p && !p.userWait {
+			p.held = nil
+			if err := c.dispatchSMP(b); err != nil {
+				logger.Warn("smp", "pairing", c.RemoteAddr(), "err", err)
+			}
+		}
+	})
+}
+
+// dispatchSMP handles an SMP command. The caller holds smpMu.
+func (c *Conn) dispatchSMP(b []byte) error {
+	switch b[0] {
 	case pairingRequest:
+		return c.handlePairingRequest(b)
 	case pairingResponse:
+		return c.handlePairingResponse(b)
 	case pairingConfirm:
+		return c.handlePairingConfirm(b)
 	case pairingRandom:
+		return c.handlePairingRandom(b)
 	case pairingFailed:
-	case encryptionInformation:
-	case masterIdentification:
-	case identiInformation:
-	case identityAddreInformation:
-	case signingInformation:
+		if p := c.pairing; p != nil {
+			c.finishPairing(p, PairingError(b[1]))
+		}
+		return nil
+	case encryptionInformation, masterIdentification, identiInformation, identityAddreInformation, signingInformation:
+		return c.handleKeyDist(b)
 	case securityRequest:
+		return c.handleSecurityRequest(b)
 	case pairingPublicKey:
+		return c.handlePublicKey(b)
 	case pairingDHKeyCheck:
+		return c.handleDHKeyCheck(b)
 	case pairingKeypress:
+		return c.handleKeypress(b)
+	}
+	return nil
+}
+
+// handlePairingRequest responds to the Pairing Request of the master.
+func (c *Conn) handlePairingRequest(b []byte) error {
+	if c.param.Role() != roleSlave {
+		return c.failPairing(ErrPairingCommandNotSupported)
+	}
+	p := c.pairing
+	if p != nil && p.expect != pairingRequest {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	if p == nil {
+		p = c.newPairing(false)
+	}
+	p.preq = append([]byte(nil), b...)
+	if b[1] >= ioCapabilityInvalid || b[4] < smpMinKeySize || b[4] > smpMaxKeySize {
+		return c.failPairing(ErrPairingInvalidParams)
+	}
+	a := c.hci.smp.agent
+	if a == nil || p.authorized {
+		return c.respondPairing(p)
+	}
+	bonding := b[3]&c.hci.smp.authReq&authReqBonding != 0
+	p.expect = expectUser
+	c.waitUser(p, func() func() error {
+		accept, bond := a.AuthorizePairing(c, bonding)
+		return func() error {
+			if !accept {
+				return c.failPairing(ErrPairingNotSupported)
+			}
+			p.authorized, p.noBond = true, !bond
+			return c.respondPairing(p)
+		}
+	})
+	return nil
+}
+
+// respondPairing sends the Pairing Response to the Pairing Request.
+func (c *Conn) respondPairing(p *pairing) error {
+	ioCap, authReq, maxKeySize, initDist, respDist := p.preq[1], p.preq[3], int(p.preq[4]), p.preq[5], p.preq[6]
+
+	// Keys are distributed only if both devices are bonding. [Vol 3, Part H, 3.6.1]
+	cfg := &c.hci.smp
+	auth := cfg.authReq | p.auth
+	if authReq&authReqBonding == 0 || auth&authReqBonding == 0 || p.noBond {
+		auth &^= authReqBonding
+		initDist, respDist = 0, 0
+	}
+	p.initDist, p.respDist = initDist&cfg.keyDist, respDist&cfg.keyDist
+	p.pres = []byte{pairingResponse, cfg.ioCap, c.oobFlag(), auth, uint8(cfg.maxKeySize), p.initDist, p.respDist}
+	if reason := c.setupPairing(p, ioCap, cfg.ioCap, authReq, maxKeySize); reason != 0 {
+		return c.failPairing(reason)
+	}
+	p.expect = pairingConfirm
+	if p.sc {
+		p.expect = pairingPublicKey
+	}
+	if err := c.sendPairing(p, p.pres); err != nil {
+		return err
+	}
+	return c.enterPasskey(p, func() error { return nil })
+}
+
+// handlePairingResponse sends the confirm value of the master, once the slave
+// has responded to the Pairing Request.
+func (c *Conn) handlePairingResponse(b []byte) error {
+	p := c.pairing
+	if p == nil || !p.initiator || p.expect != pairingResponse {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	p.pres = append([]byte(nil), b...)
+	ioCap, authReq, maxKeySize, initDist, respDist := b[1], b[3], int(b[4]), b[5], b[6]
+	if ioCap >= ioCapabilityInvalid || maxKeySize < smpMinKeySize || maxKeySize > smpMaxKeySize ||
+		initDist&^p.preq[5] != 0 || respDist&^p.preq[6] != 0 {
+		return c.failPairing(ErrPairingInvalidParams)
+	}
+	p.initDist, p.respDist = initDist, respDist
+	if reason := c.setupPairing(p, c.hci.smp.ioCap, ioCap, authReq, maxKeySize); reason != 0 {
+		return c.failPairing(reason)
+	}
+	return c.enterPasskey(p, func() error { return c.startPairing(p) })
+}
+
+// startPairing sends the public key in LE Secure Connections, or the confirm
+// value in LE legacy pairing, as the initiator.
+func (c *Conn) startPairing(p *pairing) error {
+	if p.sc {
+		// The initiator sends its public key first. [Vol 3, Part H, 2.3.5.6.1]
+		if err := p.keyPair(); err != nil {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+		p.expect = pairingPublicKey
+		return c.sendPairing(p, append([]byte{pairingPublicKey}, p.lpk[:]...))
+	}
+	confirm, err := c.confirm(p)
+	if err != nil {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	p.expect = pairingConfirm
+	return c.sendPairing(p, append([]byte{pairingConfirm}, confirm[:]...))
+}
+
+// setupPairing selects the encryption key size and the pairing method, and
+// sets the TK. The authReq and maxKeySize are the ones of the remote device.
+func (c *Conn) setupPairing(p *pairing, initIO, respIO, authReq uint8, maxKeySize int) PairingError {
+	cfg := &c.hci.smp
+	local := cfg.authReq | p.auth
+	p.keys.KeySize = maxKeySize
+	if cfg.maxKeySize < maxKeySize {
+		p.keys.KeySize = cfg.maxKeySize
+	}
+
+	// LE Secure Connections is used if both devices support it, in which case
+	// the LTK is generated instead of being distributed. [Vol 3, Part H, 3.6.1]
+	p.sc = authReq&local&authReqSC != 0
+	if cfg.scOnly && !p.sc {
+		return ErrPairingAuthRequirements
+	}
+	if cfg.scOnly && p.keys.KeySize < smpMaxKeySize {
+		return ErrPairingEncKeySize
+	}
+	if p.sc {
+		p.initDist &^= keyDistEnc
+		p.respDist &^= keyDistEnc
+	}
+
+	// Out of Band is used if both devices have the OOB data of each other in
+	// LE legacy pairing, or either one in LE Secure Connections. Otherwise,
+	// Just Works is used if neither device requires MITM protection.
+	// [Vol 3, Part H, 2.3.5.1]
+	p.method = justWorks
+	switch {
+	case p.sc && p.preq[2]|p.pres[2] != 0, !p.sc && p.preq[2]&p.pres[2] != 0:
+		p.method = oob
+	case (authReq|local)&authReqMITM != 0:
+		p.method = legacyMethods[initIO][respIO]
+		if p.sc {
+			p.method = scMethods[initIO][respIO]
+		}
+	}
+	if p.method == justWorks && cfg.needMITM {
+		return ErrPairingAuthRequirements
+	}
+	p.keys.Authenticated = p.method != justWorks
+	p.keys.SecureConnections = p.sc
+
+	p.input = p.method == passkeyBothInput ||
+		p.method == passkeyInitInputs && p.initiator ||
+		p.method == passkeyRespInputs && !p.initiator
+	p.keypress = p.sc && authReq&local&authReqKeypress != 0
+
+	display := p.method == passkeyRespInputs && p.initiator ||
+		p.method == passkeyInitInputs && !p.initiator
+	var passkey uint32
+	switch {
+	case p.method == oob:
+		return c.setupOOB(p)
+	case !p.passkeyEntry():
+		return 0
+	case cfg.passkey >= 0:
+		passkey = uint32(cfg.passkey)
+	case display:
+		var r [4]byte
+		if _, err := rand.Read(r[:]); err != nil {
+			return ErrPairingUnspecified
+		}
+		passkey = binary.LittleEndian.Uint32(r[:]) % 1000000
+	case cfg.agent != nil:
+		// The passkey is entered by the user, once the pairing features
+		// are exchanged.
+		p.enter = true
+		return 0
 	default:
-		// If a packet is received with a reserved Code it shall be ignored. [Vol 3, Part H, 3.3]
+		// There's no way to input a passkey.
+		return ErrPairingPasskeyEntry
+	}
+	if display {
+		if a := cfg.agent; a != nil {
+			c.hci.spawn(func() { a.DisplayPasskey(c, passkey) })
+		} else {
+			logger.Info("smp", "passkey", fmt.Sprintf("%06d", passkey), "peer", c.RemoteAddr())
+		}
+	}
+	p.setPasskey(passkey)
+	return 0
+}
+
+// setupOOB sets the TK shared through the OOB data in LE legacy pairing. In LE
+// Secure Connections, it sets the random values of the OOB data, and the key
+// pair the confirm value of the local data is computed with, if the remote
+// device has the local data. [Vol 3, Part H, 2.3.5.6.4]
+func (c *Conn) setupOOB(p *pairing) PairingError {
+	peer := c.peerOOBData()
+	if !p.sc {
+		tk := c.oobTK(peer)
+		if tk == nil {
+			return ErrPairingOOBNotAvailable
+		}
+		p.tk = *tk
+		return 0
+	}
+	if peer != nil && peer.Confirm != nil && peer.Random != nil {
+		p.oobConfirm, p.oobRemote = peer.Confirm, *peer.Random
+	}
+	remote := p.pres[2]
+	if !p.initiator {
+		remote = p.preq[2]
+	}
+	if remote != 0 {
+		d, priv, pk := c.localOOBData()
+		if d == nil || d.Random == nil {
+			return ErrPairingOOBNotAvailable
+		}
+		p.oobLocal, p.priv, p.lpk = *d.Random, priv, pk
+	}
+	return 0
+}
+
+// passkeyEntry reports whether the pairing method is Passkey Entry.
+func (p *pairing) passkeyEntry() bool {
+	return p.method == passkeyInitInputs || p.method == passkeyRespInputs || p.method == passkeyBothInput
+}
+
+func (p *pairing) setPasskey(passkey uint32) {
+	p.passkey = passkey
+	binary.LittleEndian.PutUint32(p.tk[:], passkey)
+}
+
+// enterPasskey has the user enter the passkey through the agent, if needed,
+// before continuing the pairing with next.
+func (c *Conn) enterPasskey(p *pairing, next func() error) error {
+	if !p.enter {
+		return next()
+	}
+	a := c.hci.smp.agent
+	c.waitUser(p, func() func() error {
+		passkey, ok := a.RequestPasskey(c)
+		return func() error {
+			if !ok || passkey > 999999 {
+				return c.failPairing(ErrPairingPasskeyEntry)
+			}
+			p.enter = false
+			p.setPasskey(passkey)
+			return next()
+		}
+	})
+	return nil
+}
+
+// smpAddrs returns the address types and addresses of the initiator and the
+// responder, in the order used by the SMP.
+func (c *Conn) smpAddrs() (iat uint8, ia [6]byte, rat uint8, ra [6]byte) {
+	lt, local := c.localAddr()
+	peer, pt := c.param.PeerAddress(), c.param.PeerAddressType()&0x01
+	if c.param.Role() == roleMaster {
+		return lt, local, pt, peer
+	}
+	return pt, peer, lt, local
+}
+
+// confirm generates the random value of the local device, and returns its
+// confirm value.
+func (c *Conn) confirm(p *pairing) ([16]byte, error) {
+	r, err := randKey()
+	if err != nil {
+		return r, err
+	}
+	p.lrand = r
+	iat, ia, rat, ra := c.smpAddrs()
+	return smpC1(p.tk, p.lrand, p.preq, p.pres, iat, rat, ia, ra), nil
+}
+
+func (c *Conn) handlePairingConfirm(b []byte) error {
+	p := c.pairing
+	if p == nil || p.expect != pairingConfirm {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	copy(p.rconfirm[:], b[1:])
+	p.expect = pairingRandom
+	if p.sc {
+		return c.handleSCConfirm(p)
+	}
+	if p.initiator {
+		return c.sendPairing(p, append([]byte{pairingRandom}, p.lrand[:]...))
+	}
+	confirm, err := c.confirm(p)
+	if err != nil {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	return c.sendPairing(p, append([]byte{pairingConfirm}, confirm[:]...))
+}
+
+// handlePairingRandom verifies the confirm value of the remote device, and
+// generates the STK. The master then encrypts the link with it. [Vol 3, Part H, 2.3.5.5]
+func (c *Conn) handlePairingRandom(b []byte) error {
+	p := c.pairing
+	if p == nil || p.expect != pairingRandom {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	copy(p.rrand[:], b[1:])
+	if p.sc {
+		return c.handleSCRandom(p)
+	}
+	iat, ia, rat, ra := c.smpAddrs()
+	if smpC1(p.tk, p.rrand, p.preq, p.pres, iat, rat, ia, ra) != p.rconfirm {
+		return c.failPairing(ErrPairingConfirmValue)
+	}
+	p.expect = expectEncryption
+	if !p.initiator {
+		p.stk = truncKey(smpS1(p.tk, p.lrand, p.rrand), p.keys.KeySize)
+		return c.sendPairing(p, append([]byte{pairingRandom}, p.lrand[:]...))
+	}
+	p.stk = truncKey(smpS1(p.tk, p.rrand, p.lrand), p.keys.KeySize)
+	return c.startEncryption(p)
+}
+
+// startEncryption encrypts the link with the STK, or the LTK generated in LE
+// Secure Connections, as the master.
+func (c *Conn) startEncryption(p *pairing) error {
+	p.expect = expectEncryption
+	c.encPending = &p.keys
+	if err := c.hci.Send(&cmd.LEStartEncryption{
+		ConnectionHandle: c.param.ConnectionHandle(),
+		LongTermKey:      p.stk,
+	}, nil); err != nil {
+		c.finishPairing(p, errors.Wrap(err, "can't start encryption"))
+	}
+	return nil
+}
+
+// encryptBond encrypts the link with the LTK of a previous bonding as the
+// master. The caller holds smpMu. [Vol 3, Part H, 2.4.4.1]
+func (c *Conn) encryptBond(ltk *LTK, auth uint8) (*pairing, error) {
+	p := c.newPairing(true)
+	p.timer.Stop()
+	p.authorized, p.auth = true, auth
+	p.expect = expectBondEncryption
+	c.encPending = c.keys
+	if err := c.hci.Send(&cmd.LEStartEncryption{
+		ConnectionHandle:     c.param.ConnectionHandle(),
+		RandomNumber:         ltk.Rand,
+		EncryptedDiversifier: ltk.EDIV,
+		LongTermKey:          ltk.Key,
+	}, nil); err != nil {
+		err = errors.Wrap(err, "can't start encryption")
+		c.finishPairing(p, err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// handlePublicKey receives the public key of the remote device, and computes
+// the DHKey. The responder then sends its own public key. [Vol 3, Part H, 2.3.5.6.1]
+func (c *Conn) handlePublicKey(b []byte) error {
+	p := c.pairing
+	if p == nil || !p.sc || p.expect != pairingPublicKey {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	copy(p.rpk[:], b[1:])
+	if !p.initiator {
+		if err := p.keyPair(); err != nil {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+	}
+
+	// A remote device reflecting our public key, or sending an invalid one,
+	// is an attacker. [Vol 3, Part H, 2.3.5.6.1]
+	if p.rpk == p.lpk {
+		return c.failPairing(ErrPairingDHKeyCheck)
+	}
+	dhkey, err := smpDHKey(p.priv, p.rpk)
+	if err != nil {
+		return c.failPairing(ErrPairingDHKeyCheck)
+	}
+	p.dhkey = dhkey
+
+	// The confirm value of the OOB data proves the public key is the one of
+	// the device the data is received from. [Vol 3, Part H, 2.3.5.6.4]
+	if p.oobConfirm != nil && smpF4(p.rpk[:32], p.rpk[:32], p.oobRemote, 0) != *p.oobConfirm {
+		return c.failPairing(ErrPairingConfirmValue)
+	}
+
+	if !p.initiator {
+		if err := c.sendPairing(p, append([]byte{pairingPublicKey}, p.lpk[:]...)); err != nil {
+			return err
+		}
+	}
+
+	// In Just Works and Numeric Comparison, only the responder sends a confirm
+	// value. In Passkey Entry, the initiator starts each round. In Out of Band,
+	// the devices only exchange their random values, the initiator first.
+	// [Vol 3, Part H, 2.3.5.6.2 - 2.3.5.6.4]
+	switch {
+	case p.method == oob:
+		r, err := randKey()
+		if err != nil {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+		p.lrand = r
+		p.expect = pairingRandom
+		if p.initiator {
+			return c.sendPairing(p, append([]byte{pairingRandom}, p.lrand[:]...))
+		}
+	case p.passkeyEntry():
+		if p.initiator {
+			return c.sendSCConfirm(p)
+		}
+		p.expect = pairingConfirm
+	case p.initiator:
+		p.expect = pairingConfirm
+	default:
+		return c.sendSCConfirm(p)
+	}
+	return nil
+}
+
+// keyPair generates the key pair of the local device, unless it's the one the
+// confirm value of the local OOB data is computed with.
+func (p *pairing) keyPair() error {
+	if p.priv != nil {
 		return nil
 	}
-	// FIXME: work aound to the lack of SMP implementation - always return non-supported.
-	// C.5.1 Pairing Not Supported by Slave
-	return c.sendSMP([]byte{pairingFailed, 0x05})
+	priv, pk, err := smpKeyPair()
+	if err != nil {
+		return err
+	}
+	p.priv, p.lpk = priv, pk
+	return nil
+}
+
+// z returns the value of the current round of Passkey Entry, which is a bit of
+// the passkey, or 0 in the other methods.
+func (p *pairing) z() uint8 {
+	if !p.passkeyEntry() {
+		return 0
+	}
+	return 0x80 | uint8(p.passkey>>uint(p.round)&0x01)
+}
+
+// sendSCConfirm generates the random value of the local device, and sends its
+// confirm value.
+func (c *Conn) sendSCConfirm(p *pairing) error {
+	r, err := randKey()
+	if err != nil {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	p.lrand = r
+	confirm := smpF4(p.lpk[:32], p.rpk[:32], p.lrand, p.z())
+	p.expect = pairingConfirm
+	if !p.initiator {
+		p.expect = pairingRandom
+	}
+	return c.sendPairing(p, append([]byte{pairingConfirm}, confirm[:]...))
+}
+
+// handleSCConfirm receives the confirm value of the remote device, which the
+// initiator answers with its random value, and the responder with its own
+// confirm value in Passkey Entry.
+func (c *Conn) handleSCConfirm(p *pairing) error {
+	if p.initiator {
+		if !p.passkeyEntry() {
+			r, err := randKey()
+			if err != nil {
+				return c.failPairing(ErrPairingUnspecified)
+			}
+			p.lrand = r
+		}
+		return c.sendPairing(p, append([]byte{pairingRandom}, p.lrand[:]...))
+	}
+	if !p.passkeyEntry() {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	return c.sendSCConfirm(p)
+}
+
+// handleSCRandom verifies the confirm value of the remote device. Once the
+// authentication stage 1 completes, the initiator sends its DHKey check value.
+// [Vol 3, Part H, 2.3.5.6.2, 2.3.5.6.3]
+func (c *Conn) handleSCRandom(p *pairing) error {
+	// In Just Works and Numeric Comparison, the initiator doesn't send a
+	// confirm value, and in Out of Band, neither device does.
+	if p.method != oob && (p.initiator || p.passkeyEntry()) {
+		if smpF4(p.rpk[:32], p.lpk[:32], p.rrand, p.z()) != p.rconfirm {
+			return c.failPairing(ErrPairingConfirmValue)
+		}
+	}
+	if !p.initiator {
+		if err := c.sendPairing(p, append([]byte{pairingRandom}, p.lrand[:]...)); err != nil {
+			return err
+		}
+	}
+	if p.passkeyEntry() {
+		if p.round++; p.round < passkeyRounds {
+			if p.initiator {
+				return c.sendSCConfirm(p)
+			}
+			p.expect = pairingConfirm
+			return nil
+		}
+	}
+	if p.method == numericComparison {
+		na, nb, pka, pkb := p.lrand, p.rrand, p.lpk[:32], p.rpk[:32]
+		if !p.initiator {
+			na, nb, pka, pkb = nb, na, pkb, pka
+		}
+		v := smpG2(pka, pkb, na, nb) % 1000000
+		a := c.hci.smp.agent
+		if a == nil {
+			return c.failPairing(ErrPairingNumericComparison)
+		}
+		// The DHKey checks aren't sent before the user confirms the value.
+		// [Vol 3, Part H, 2.3.5.6.5]
+		c.waitUser(p, func() func() error {
+			ok := a.ConfirmNumeric(c, v)
+			return func() error {
+				if !ok {
+					return c.failPairing(ErrPairingNumericComparison)
+				}
+				return c.startDHKeyCheck(p)
+			}
+		})
+		return nil
+	}
+	return c.startDHKeyCheck(p)
+}
+
+// startDHKeyCheck starts the authentication stage 2, where the initiator sends
+// its DHKey check value first. [Vol 3, Part H, 2.3.5.6.5]
+func (c *Conn) startDHKeyCheck(p *pairing) error {
+	c.scKeys(p)
+	p.expect = pairingDHKeyCheck
+	if p.initiator {
+		check := c.scCheck(p, true)
+		return c.sendPairing(p, append([]byte{pairingDHKeyCheck}, check[:]...))
+	}
+	return nil
+}
+
+// scAddrs returns the 56-bit addresses of the local and remote devices, which
+// are the address types followed by the addresses.
+func (c *Conn) scAddrs() (local, remote [7]byte) {
+	iat, ia, rat, ra := c.smpAddrs()
+	a := [7]byte{ia[0], ia[1], ia[2], ia[3], ia[4], ia[5], iat}
+	b := [7]byte{ra[0], ra[1], ra[2], ra[3], ra[4], ra[5], rat}
+	if c.param.Role() == roleMaster {
+		return a, b
+	}
+	return b, a
+}
+
+// scKeys generates the MacKey and LTK from the DHKey. [Vol 3, Part H, 2.3.5.6.5]
+func (c *Conn) scKeys(p *pairing) {
+	local, remote := c.scAddrs()
+	na, nb, a, b := p.lrand, p.rrand, local, remote
+	if !p.initiator {
+		na, nb, a, b = nb, na, b, a
+	}
+	macKey, ltk := smpF5(p.dhkey, na, nb, a, b)
+	p.macKey = macKey
+	p.stk = truncKey(ltk, p.keys.KeySize)
+	key := &LTK{Key: p.stk}
+	p.keys.LocalLTK, p.keys.PeerLTK = key, key
+}
+
+// scCheck returns the DHKey check value of the local device, or the one
+// expected from the remote device. [Vol 3, Part H, 2.3.5.6.5]
+func (c *Conn) scCheck(p *pairing, local bool) [16]byte {
+	// The value r is the passkey of both devices in Passkey Entry, and the
+	// random value of the OOB data of the other device in Out of Band.
+	var lr, rr [16]byte
+	switch {
+	case p.passkeyEntry():
+		binary.LittleEndian.PutUint32(lr[:], p.passkey)
+		rr = lr
+	case p.method == oob:
+		lr, rr = p.oobRemote, p.oobLocal
+	}
+	lio, rio := p.preq, p.pres
+	if !p.initiator {
+		lio, rio = rio, lio
+	}
+	var ioCap [3]byte
+	la, ra := c.scAddrs()
+	if local {
+		copy(ioCap[:], lio[1:4])
+		return smpF6(p.macKey, p.lrand, p.rrand, lr, ioCap, la, ra)
+	}
+	copy(ioCap[:], rio[1:4])
+	return smpF6(p.macKey, p.rrand, p.lrand, rr, ioCap, ra, la)
+}
+
+// handleDHKeyCheck verifies the DHKey check value of the remote device. The
+// initiator then encrypts the link with the LTK, and the responder sends its
+// own check value. [Vol 3, Part H, 2.3.5.6.5]
+func (c *Conn) handleDHKeyCheck(b []byte) error {
+	p := c.pairing
+	if p == nil || !p.sc || p.expect != pairingDHKeyCheck {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	var check [16]byte
+	copy(check[:], b[1:])
+	if check != c.scCheck(p, false) {
+		return c.failPairing(ErrPairingDHKeyCheck)
+	}
+	if p.initiator {
+		return c.startEncryption(p)
+	}
+	p.expect = expectEncryption
+	check = c.scCheck(p, true)
+	return c.sendPairing(p, append([]byte{pairingDHKeyCheck}, check[:]...))
+}
+
+// handleKeypress receives a Keypress Notification of the remote device, which
+// is entering the passkey.
+func (c *Conn) handleKeypress(b []byte) error {
+	p := c.pairing
+	if p == nil || !p.keypress {
+		return nil
+	}
+	logger.Debug("smp", "keypress", KeypressType(b[1]), "peer", c.RemoteAddr())
+	return nil
+}
+
+// NotifyKeypress sends a Keypress Notification to the remote device, while the
+// local device is entering the passkey, if both devices support it.
+// [Vol 3, Part H, 3.5.8]
+func (c *Conn) NotifyKeypress(t KeypressType) error {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	p := c.pairing
+	if p == nil || !p.keypress || !p.input {
+		return errors.New("keypress notifications not in use")
+	}
+	return c.sendPairing(p, []byte{pairingKeypress, uint8(t)})
+}
+
+// handleSecurityRequest starts pairing as the master, when requested by the slave.
+// [Vol 3, Part H, 2.4.6]
+func (c *Conn) handleSecurityRequest(b []byte) error {
+	if c.param.Role() != roleMaster {
+		return c.failPairing(ErrPairingCommandNotSupported)
+	}
+	if c.pairing != nil {
+		return nil
+	}
+
+	// The link is encrypted with the LTK of a previous bonding, if it meets
+	// the security requirements of the slave.
+	level := ble.SecurityUnauthenticated
+	if b[1]&authReqMITM != 0 {
+		level = ble.SecurityAuthenticated
+	}
+	if ltk := c.bondLTK(level); ltk != nil && !c.encrypted {
+		_, err := c.encryptBond(ltk, 0)
+		return err
+	}
+
+	a := c.hci.smp.agent
+	if a == nil {
+		_, err := c.requestPairing(0)
+		return err
+	}
+	p := c.newPairing(true)
+	bonding := b[1]&c.hci.smp.authReq&authReqBonding != 0
+	c.waitUser(p, func() func() error {
+		accept, bond := a.AuthorizePairing(c, bonding)
+		return func() error {
+			if !accept {
+				return c.failPairing(ErrPairingNotSupported)
+			}
+			p.authorized, p.noBond = true, !bond
+			return c.sendPairingRequest(p)
+		}
+	})
+	return nil
+}
+
+// handleKeyDist receives the keys distributed by the remote device.
+// [Vol 3, Part H, 3.6]
+func (c *Conn) handleKeyDist(b []byte) error {
+	p := c.pairing
+	if p == nil {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+	// The slave may distribute its keys before the Encryption Change has been
+	// handled on the master.
+	if p.expect == expectEncryption && p.initiator {
+		c.encrypted, c.encKeys = true, c.encPending
+		if err := c.startKeyDist(p); err != nil {
+			return err
+		}
+	}
+	if p.expect != expectKeys {
+		return c.failPairing(ErrPairingUnspecified)
+	}
+
+	k := &p.keys
+	switch b[0] {
+	case encryptionInformation:
+		if p.recvDist&keyDistEnc == 0 || k.PeerLTK != nil {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+		k.PeerLTK = &LTK{}
+		copy(k.PeerLTK.Key[:], b[1:])
+	case masterIdentification:
+		if p.recvDist&keyDistEnc == 0 || k.PeerLTK == nil {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+		k.PeerLTK.EDIV = binary.LittleEndian.Uint16(b[1:])
+		k.PeerLTK.Rand = binary.LittleEndian.Uint64(b[3:])
+		p.recvDist &^= keyDistEnc
+	case identiInformation:
+		if p.recvDist&keyDistID == 0 || k.PeerIRK != nil {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+		var irk [16]byte
+		copy(irk[:], b[1:])
+		k.PeerIRK = &irk
+	case identityAddreInformation:
+		if p.recvDist&keyDistID == 0 || k.PeerIRK == nil {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+		k.PeerIdentity = &IdentityAddr{Type: b[1]}
+		copy(k.PeerIdentity.Addr[:], b[2:])
+		p.recvDist &^= keyDistID
+	case signingInformation:
+		if p.recvDist&keyDistSign == 0 {
+			return c.failPairing(ErrPairingUnspecified)
+		}
+		var csrk [16]byte
+		copy(csrk[:], b[1:])
+		k.PeerCSRK = &csrk
+		p.recvDist &^= keyDistSign
+	}
+	return c.checkKeyDist(p)
+}
+
+// startKeyDist starts the key distribution once the link is encrypted with the
+// STK. The slave distributes its keys first. [Vol 3, Part H, 3.6.1]
+func (c *Conn) startKeyDist(p *pairing) error {
+	p.expect = expectKeys
+	if p.initiator {
+		p.recvDist = p.respDist
+		return c.checkKeyDist(p)
+	}
+	p.recvDist = p.initDist
+	if err := c.sendKeys(p, p.respDist); err != nil {
+		c.finishPairing(p, errors.Wrap(err, "can't distribute keys"))
+		return err
+	}
+	return c.checkKeyDist(p)
+}
+
+// checkKeyDist completes the pairing once all the keys of the remote device
+// are received. The master distributes its keys at this point.
+func (c *Conn) checkKeyDist(p *pairing) error {
+	if p.recvDist != 0 {
+		return nil
+	}
+	if p.initiator {
+		if err := c.sendKeys(p, p.initDist); err != nil {
+			c.finishPairing(p, errors.Wrap(err, "can't distribute keys"))
+			return err
+		}
+	}
+	c.finishPairing(p, nil)
+	return nil
+}
+
+// sendKeys generates and distributes the keys of the local device.
+func (c *Conn) sendKeys(p *pairing, dist uint8) error {
+	if dist&keyDistEnc != 0 {
+		key, err := randKey()
+		if err != nil {
+			return err
+		}
+		var id [10]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return err
+		}
+		ltk := &LTK{
+			Key:  truncKey(key, p.keys.KeySize),
+			EDIV: binary.LittleEndian.Uint16(id[0:]),
+			Rand: binary.LittleEndian.Uint64(id[2:]),
+		}
+		p.keys.LocalLTK = ltk
+		if err := c.sendPairing(p, append([]byte{encryptionInformation}, ltk.Key[:]...)); err != nil {
+			return err
+		}
+		if err := c.sendPairing(p, append([]byte{masterIdentification}, id[:]...)); err != nil {
+			return err
+		}
+	}
+	if dist&keyDistID != 0 {
+		irk := c.hci.localIRK()
+		p.keys.LocalIRK = &irk
+		if err := c.sendPairing(p, append([]byte{identiInformation}, irk[:]...)); err != nil {
+			return err
+		}
+		typ, addr := c.localAddr()
+		if err := c.sendPairing(p, append([]byte{identityAddreInformation, typ}, addr[:]...)); err != nil {
+			return err
+		}
+	}
+	if dist&keyDistSign != 0 {
+		csrk, err := randKey()
+		if err != nil {
+			return err
+		}
+		p.keys.LocalCSRK = &csrk
+		if err := c.sendPairing(p, append([]byte{signingInformation}, csrk[:]...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleEncryptionChange starts the key distribution, once the link is
+// encrypted with the STK, or ends the encryption with the LTK of a bonding.
+func (c *Conn) handleEncryptionChange(status uint8, enabled bool) {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	c.encrypted = status == 0x00 && enabled
+	c.encKeys = nil
+	if c.encrypted {
+		c.encKeys = c.encPending
+	}
+	c.encPending = nil
+	p := c.pairing
+	if p == nil {
+		return
+	}
+	switch {
+	case p.expect == pairingRequest && !p.initiator && c.encrypted:
+		// The master encrypted the link with the keys of a previous bonding,
+		// in response to our Security Request.
+		c.finishPairing(p, nil)
+	case p.expect == expectBondEncryption && ErrCommand(status) == ErrPINMissing:
+		// The slave has lost the keys of the bonding, so pair again.
+		logger.Info("smp", "bond missing on", c.RemoteAddr())
+		c.sendPairingRequest(p)
+	case p.expect == expectBondEncryption && c.encrypted:
+		c.finishPairing(p, nil)
+	case p.expect != expectEncryption && p.expect != expectBondEncryption:
+	case status != 0x00:
+		c.finishPairing(p, errors.Wrap(ErrCommand(status), "can't encrypt"))
+	case !enabled:
+		c.finishPairing(p, errors.New("encryption disabled"))
+	default:
+		c.startKeyDist(p)
+	}
+}
+
+// handleLTKRequest replies to the LE Long Term Key Request with the STK of the
+// ongoing pairing, once it has been generated, or the LTK distributed by the
+// local device, which may be loaded from the key store. [Vol 3, Part H, 2.4.4]
+func (c *Conn) handleLTKRequest(ediv uint16, rand uint64) {
+	c.smpMu.Lock()
+	c.loadBond()
+	var key *[16]byte
+	c.encPending = nil
+	if p := c.pairing; p != nil && p.expect == expectEncryption && ediv == 0 && rand == 0 {
+		k := p.stk
+		key, c.encPending = &k, &p.keys
+	} else if c.keys != nil && c.keys.LocalLTK != nil && c.keys.LocalLTK.EDIV == ediv && c.keys.LocalLTK.Rand == rand {
+		k := c.keys.LocalLTK.Key
+		key, c.encPending = &k, c.keys
+	}
+	c.smpMu.Unlock()
+
+	handle := c.param.ConnectionHandle()
+	var err error
+	if key == nil {
+		err = c.hci.Send(&cmd.LELongTermKeyRequestNegativeReply{ConnectionHandle: handle}, nil)
+	} else {
+		err = c.hci.Send(&cmd.LELongTermKeyRequestReply{ConnectionHandle: handle, LongTermKey: *key}, nil)
+	}
+	if err != nil {
+		logger.Warn("can't reply to LTK request", "handle", handle, "err", err)
+	}
 }
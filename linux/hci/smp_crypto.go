@@ -0,0 +1,235 @@
+package hci
+
+import (
+	"crypto/aes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// The values of the SMP PDUs and cryptographic functions are little-endian,
+// while the security function e takes and returns the most significant octet
+// first. [Vol 3, Part H, 2.2]
+
+// smpE is the security function e, which encrypts plaintextData with key using
+// AES-128. [Vol 3, Part H, 2.2.1]
+func smpE(key, plaintextData [16]byte) [16]byte {
+	k, p := swap16(key), swap16(plaintextData)
+	b, _ := aes.NewCipher(k[:])
+	var out [16]byte
+	b.Encrypt(out[:], p[:])
+	return swap16(out)
+}
+
+// smpC1 is the confirm value generation function for LE legacy pairing.
+// [Vol 3, Part H, 2.2.3]
+func smpC1(k, r [16]byte, preq, pres []byte, iat, rat uint8, ia, ra [6]byte) [16]byte {
+	// p1 = pres || preq || rat' || iat'
+	var p1 [16]byte
+	p1[0], p1[1] = iat, rat
+	copy(p1[2:9], preq)
+	copy(p1[9:16], pres)
+
+	// p2 = padding || ia || ra
+	var p2 [16]byte
+	copy(p2[0:6], ra[:])
+	copy(p2[6:12], ia[:])
+
+	return smpE(k, xor16(smpE(k, xor16(r, p1)), p2))
+}
+
+// smpS1 is the key generation function for the STK in LE legacy pairing.
+// [Vol 3, Part H, 2.2.4]
+func smpS1(k, r1, r2 [16]byte) [16]byte {
+	// r' = r1' || r2', the least significant 64 bits of r1 and r2.
+	var r [16]byte
+	copy(r[0:8], r2[0:8])
+	copy(r[8:16], r1[0:8])
+	return smpE(k, r)
+}
+
+// smpAh is the random address hash function, which generates and resolves
+// resolvable private addresses. [Vol 3, Part H, 2.2.2]
+func smpAh(k [16]byte, r [3]byte) [3]byte {
+	// r' = padding || r
+	var rp [16]byte
+	copy(rp[:], r[:])
+	e := smpE(k, rp)
+	return [3]byte{e[0], e[1], e[2]}
+}
+
+// aesCMAC is the message authentication code AES-CMAC, with the key and the
+// message taking the most significant octet first. [Vol 3, Part H, 2.2.5]
+// It follows the algorithm of RFC 4493.
+func aesCMAC(key [16]byte, m []byte) [16]byte {
+	b, _ := aes.NewCipher(key[:])
+	var l [16]byte
+	b.Encrypt(l[:], l[:])
+	k1 := cmacSubkey(l)
+	k2 := cmacSubkey(k1)
+
+	n := (len(m) + 15) / 16
+	var last [16]byte
+	if n > 0 && len(m)%16 == 0 {
+		copy(last[:], m[16*(n-1):])
+		last = xor16(last, k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := copy(last[:], m[16*(n-1):])
+		last[rest] = 0x80
+		last = xor16(last, k2)
+	}
+
+	var x, y [16]byte
+	for i := 0; i < n-1; i++ {
+		copy(y[:], m[16*i:])
+		x = xor16(x, y)
+		b.Encrypt(x[:], x[:])
+	}
+	x = xor16(x, last)
+	b.Encrypt(x[:], x[:])
+	return x
+}
+
+func cmacSubkey(l [16]byte) [16]byte {
+	var k [16]byte
+	for i := 0; i < 15; i++ {
+		k[i] = l[i]<<1 | l[i+1]>>7
+	}
+	k[15] = l[15] << 1
+	if l[0]&0x80 != 0 {
+		k[15] ^= 0x87
+	}
+	return k
+}
+
+// smpMAC returns AES-CMAC of the message with a little-endian key, as a
+// little-endian value.
+func smpMAC(key [16]byte, m []byte) [16]byte {
+	return swap16(aesCMAC(swap16(key), m))
+}
+
+// msbFirst concatenates the little-endian values, with the most significant
+// octet of the first one leading.
+func msbFirst(vals ...[]byte) []byte {
+	var m []byte
+	for _, v := range vals {
+		for i := len(v) - 1; i >= 0; i-- {
+			m = append(m, v[i])
+		}
+	}
+	return m
+}
+
+// smpF4 is the confirm value generation function for LE Secure Connections,
+// which takes the X coordinates of the public keys u and v. [Vol 3, Part H, 2.2.6]
+func smpF4(u, v []byte, x [16]byte, z uint8) [16]byte {
+	return smpMAC(x, msbFirst(u, v, []byte{z}))
+}
+
+// smpF5 is the key generation function for LE Secure Connections, which
+// derives the MacKey and the LTK from the DHKey w. [Vol 3, Part H, 2.2.7]
+func smpF5(w [32]byte, n1, n2 [16]byte, a1, a2 [7]byte) (macKey, ltk [16]byte) {
+	salt := [16]byte{
+		0x6C, 0x88, 0x83, 0x91, 0xAA, 0xF5, 0xA5, 0x38,
+		0x60, 0x37, 0x0B, 0xDB, 0x5A, 0x60, 0x83, 0xBE,
+	}
+	t := aesCMAC(salt, msbFirst(w[:]))
+
+	// Counter || keyID "btle" || N1 || N2 || A1 || A2 || Length of 256 bits
+	m := append([]byte{0x00, 0x62, 0x74, 0x6C, 0x65}, msbFirst(n1[:], n2[:], a1[:], a2[:])...)
+	m = append(m, 0x01, 0x00)
+	macKey = swap16(aesCMAC(t, m))
+	m[0] = 0x01
+	ltk = swap16(aesCMAC(t, m))
+	return macKey, ltk
+}
+
+// smpF6 is the check value generation function for LE Secure Connections.
+// [Vol 3, Part H, 2.2.8]
+func smpF6(w, n1, n2, r [16]byte, ioCap [3]byte, a1, a2 [7]byte) [16]byte {
+	return smpMAC(w, msbFirst(n1[:], n2[:], r[:], ioCap[:], a1[:], a2[:]))
+}
+
+// smpG2 is the numeric comparison value generation function for LE Secure
+// Connections, which takes the X coordinates of the public keys u and v.
+// [Vol 3, Part H, 2.2.9]
+func smpG2(u, v []byte, x, y [16]byte) uint32 {
+	mac := aesCMAC(swap16(x), msbFirst(u, v, y[:]))
+	return binary.BigEndian.Uint32(mac[12:])
+}
+
+// smpSign returns the signature of the data m, which is the SignCounter
+// followed by the 64 most significant bits of the MAC generated with the CSRK.
+// [Vol 3, Part H, 2.4.5] & [Vol 3, Part C, 10.4.1]
+func smpSign(csrk [16]byte, m []byte, counter uint32) [12]byte {
+	var c [4]byte
+	binary.LittleEndian.PutUint32(c[:], counter)
+	mac := smpMAC(csrk, msbFirst(c[:], m))
+	var sig [12]byte
+	copy(sig[0:4], c[:])
+	copy(sig[4:12], mac[8:16])
+	return sig
+}
+
+// smpKeyPair generates a P-256 key pair, and returns the public key in the
+// format of the Pairing Public Key command. [Vol 3, Part H, 3.5.6]
+func smpKeyPair() (*ecdh.PrivateKey, [64]byte, error) {
+	var pk [64]byte
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, pk, err
+	}
+	// The uncompressed point is 0x04 || X || Y, with X and Y big-endian.
+	b := priv.PublicKey().Bytes()
+	copy(pk[:], msbFirst(b[1:33], b[33:65]))
+	return priv, pk, nil
+}
+
+// smpDHKey validates the public key of the remote device, and computes the
+// DHKey, which is the X coordinate of the shared point. [Vol 3, Part H, 2.3.5.6.1]
+func smpDHKey(priv *ecdh.PrivateKey, pk [64]byte) ([32]byte, error) {
+	var dhkey [32]byte
+	b := append([]byte{0x04}, msbFirst(pk[0:32], pk[32:64])...)
+	pub, err := ecdh.P256().NewPublicKey(b)
+	if err != nil {
+		return dhkey, err
+	}
+	s, err := priv.ECDH(pub)
+	if err != nil {
+		return dhkey, err
+	}
+	copy(dhkey[:], msbFirst(s))
+	return dhkey, nil
+}
+
+func xor16(a, b [16]byte) [16]byte {
+	for i := range a {
+		a[i] ^= b[i]
+	}
+	return a
+}
+
+func swap16(a [16]byte) [16]byte {
+	for i := 0; i < 8; i++ {
+		a[i], a[15-i] = a[15-i], a[i]
+	}
+	return a
+}
+
+// truncKey reduces the key to the encryption key size by masking its most
+// significant octets. [Vol 3, Part H, 2.3.4]
+func truncKey(k [16]byte, size int) [16]byte {
+	for i := size; i < len(k); i++ {
+		k[i] = 0
+	}
+	return k
+}
+
+func randKey() ([16]byte, error) {
+	var k [16]byte
+	_, err := rand.Read(k[:])
+	return k, err
+}
@@ -0,0 +1,189 @@
+package hci
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// The sample data of the specification take the most significant octet first,
+// while the SMP functions take little-endian values. [Vol 3, Part H, Appendix D]
+
+// le returns the little-endian value of the hex string.
+func le(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+func le16(s string) (k [16]byte) {
+	copy(k[:], le(s))
+	return k
+}
+
+func le7(s string) (a [7]byte) {
+	copy(a[:], le(s))
+	return a
+}
+
+func TestAESCMAC(t *testing.T) {
+	// RFC 4493, 4. Test Vectors
+	var key [16]byte
+	k, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	copy(key[:], k)
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	for _, tc := range []struct {
+		n   int
+		mac string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		mac := aesCMAC(key, msg[:tc.n])
+		if got := hex.EncodeToString(mac[:]); got != tc.mac {
+			t.Errorf("AES-CMAC of %d octets = %s, want %s", tc.n, got, tc.mac)
+		}
+	}
+}
+
+func TestSMPC1(t *testing.T) {
+	// [Vol 3, Part H, 2.2.3]
+	var ia, ra [6]byte
+	copy(ia[:], le("a1a2a3a4a5a6"))
+	copy(ra[:], le("b1b2b3b4b5b6"))
+	r := le16("5783d52156ad6f0e6388274ec6702ee0")
+	got := smpC1([16]byte{}, r, le("07071000000101"), le("05000800000302"), 0x01, 0x00, ia, ra)
+	if want := le16("1e1e3fef878988ead2a74dc5bef13b86"); got != want {
+		t.Errorf("c1 = %x, want %x", swap16(got), swap16(want))
+	}
+}
+
+func TestSMPS1(t *testing.T) {
+	// [Vol 3, Part H, 2.2.4]
+	r1 := le16("000f0e0d0c0b0a091122334455667788")
+	r2 := le16("010203040506070899aabbccddeeff00")
+	got := smpS1([16]byte{}, r1, r2)
+	if want := le16("9a1fe1f0e8b0f49b5b4216ae796da062"); got != want {
+		t.Errorf("s1 = %x, want %x", swap16(got), swap16(want))
+	}
+}
+
+func TestSMPAh(t *testing.T) {
+	// [Vol 3, Part H, D.7]
+	var r [3]byte
+	copy(r[:], le("708194"))
+	got := smpAh(le16("ec0234a357c8ad05341010a60a397d9b"), r)
+	if want := le("0dfbaa"); !bytes.Equal(got[:], want) {
+		t.Errorf("ah = %x, want %x", got, want)
+	}
+}
+
+func TestSMPSign(t *testing.T) {
+	// The signature is the SignCounter followed by the 64 most significant
+	// bits of the MAC of the message and the SignCounter. [Vol 3, Part H, 2.4.5]
+	// The MACs are computed independently with OpenSSL, over the SignCounter
+	// and the message, most significant octet first:
+	//	openssl mac -cipher AES-128-CBC -macopt hexkey:2b7e151628aed2a6abf7158809cf4f3c CMAC
+	csrk := le16("2b7e151628aed2a6abf7158809cf4f3c")
+	for _, tc := range []struct {
+		m       string // Little-endian.
+		counter uint32
+		sig     string
+	}{
+		{"", 0, "00000000b3a8594127ebc2c0"},
+		{"6bc1bee22e409f96e93d7e117393172a", 0, "00000000273974f4392a232a"},
+		{"6bc1bee22e409f96e93d7e117393172a", 1, "010000006eb411ef5187e9e2"},
+		{"00", 0x01020304, "04030201d40d6ed2c16cb2f4"},
+	} {
+		m, _ := hex.DecodeString(tc.m)
+		sig := smpSign(csrk, m, tc.counter)
+		if got := hex.EncodeToString(sig[:]); got != tc.sig {
+			t.Errorf("signature of %q with counter %d = %s, want %s", tc.m, tc.counter, got, tc.sig)
+		}
+	}
+}
+
+// Sample data of the LE Secure Connections functions. [Vol 3, Part H, D.1 - D.5]
+var (
+	scU  = le("20b003d2f297be2c5e2c83a7e9f9a5b9eff49111acf4fddbcc0301480e359de6")
+	scV  = le("55188b3d32f6bb9a900afcfbeed4e72a59cb9ac2f19d7cfb6b4fdd49f47fc5fd")
+	scX  = le16("d5cb8454d177733effffb2ec712baeab")
+	scY  = le16("a6e8e7cc25a75f6e216583f7ff3dc4cf")
+	scA1 = le7("56123737bfce")
+	scA2 = le7("a713702dcfc1")
+)
+
+func TestSMPF4(t *testing.T) {
+	got := smpF4(scU, scV, scX, 0x00)
+	if want := le16("f2c916f107a9bd1cf1eda1bea974872d"); got != want {
+		t.Errorf("f4 = %x, want %x", swap16(got), swap16(want))
+	}
+}
+
+func TestSMPF5(t *testing.T) {
+	var w [32]byte
+	copy(w[:], le("ec0234a357c8ad05341010a60a397d9b99796b13b4f866f1868d34f373bfa698"))
+	macKey, ltk := smpF5(w, scX, scY, scA1, scA2)
+	if want := le16("2965f176a1084a02fd3f6a20ce636e20"); macKey != want {
+		t.Errorf("f5 MacKey = %x, want %x", swap16(macKey), swap16(want))
+	}
+	if want := le16("6986791169d7cd23980522b594750a38"); ltk != want {
+		t.Errorf("f5 LTK = %x, want %x", swap16(ltk), swap16(want))
+	}
+}
+
+func TestSMPF6(t *testing.T) {
+	w := le16("2965f176a1084a02fd3f6a20ce636e20")
+	r := le16("12a3343bb453bb5408da42d20c2d0fc8")
+	var ioCap [3]byte
+	copy(ioCap[:], le("010102"))
+	got := smpF6(w, scX, scY, r, ioCap, scA1, scA2)
+	if want := le16("e3c473989cd0e8c5d26c0b09da958f61"); got != want {
+		t.Errorf("f6 = %x, want %x", swap16(got), swap16(want))
+	}
+}
+
+func TestSMPG2(t *testing.T) {
+	if got, want := smpG2(scU, scV, scX, scY), uint32(0x2f9ed5ba); got != want {
+		t.Errorf("g2 = %08x, want %08x", got, want)
+	}
+}
+
+func TestSMPDHKey(t *testing.T) {
+	privA, pkA, err := smpKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privB, pkB, err := smpKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhA, err := smpDHKey(privA, pkB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhB, err := smpDHKey(privB, pkA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dhA != dhB {
+		t.Errorf("DHKeys differ: %x, %x", dhA, dhB)
+	}
+
+	// A point not on the curve is rejected.
+	pkB[0] ^= 0x01
+	if _, err := smpDHKey(privA, pkB); err == nil {
+		t.Error("invalid public key accepted")
+	}
+	if bytes.Equal(pkA[:], pkB[:]) {
+		t.Error("same public keys generated")
+	}
+}
@@ -0,0 +1,444 @@
+package hci
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/currantlabs/ble"
+	"github.com/currantlabs/ble/linux/hci/evt"
+	"golang.org/x/net/context"
+)
+
+// pipeSkt is the HCI transport of a device in an smpPipe. Reads return the
+// packets queued on in by the fake controller, and writes are queued on out.
+type pipeSkt struct {
+	memSkt
+	out chan []byte
+}
+
+func (s *pipeSkt) Write(b []byte) (int, error) {
+	s.out <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+const pipeHandle = 0x0040
+
+// smpPipe connects a master and a slave through a fake controller, which
+// passes the ACL data between them, and encrypts the link with the STK or LTK
+// they agree on. [Vol 2, Part E, 7.8.24, 7.8.25]
+type smpPipe struct {
+	t      *testing.T
+	m, s   *pipeSkt
+	mc, sc *Conn
+
+	// tamper, if set, replaces each SMP command sent by the device of the
+	// role before it's delivered to the other one.
+	tamper func(role uint8, b []byte) []byte
+
+	// ltkReplies receives the opcodes of the replies of the slave to the LE
+	// Long Term Key Requests.
+	ltkReplies chan uint16
+
+	stop chan struct{}
+}
+
+func newSMPPipe(t *testing.T, mopts, sopts []Option, tamper func(uint8, []byte) []byte) *smpPipe {
+	p := &smpPipe{t: t, tamper: tamper, ltkReplies: make(chan uint16, 4), stop: make(chan struct{})}
+	p.m, p.mc = newPipeConn(t, roleMaster, mopts)
+	p.s, p.sc = newPipeConn(t, roleSlave, sopts)
+	go p.run()
+	t.Cleanup(func() { close(p.stop) })
+	return p
+}
+
+// newPipeConn returns the connection of a device without a controller. The
+// master has the public address 0A:00:00:00:00:01, and the slave 0A:00:00:00:00:02.
+func newPipeConn(t *testing.T, role uint8, opts []Option) (*pipeSkt, *Conn) {
+	h, err := NewHCI(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skt := &pipeSkt{memSkt{in: make(chan []byte, 64)}, make(chan []byte, 64)}
+	h.skt = skt
+	h.bufSize, h.bufCnt = 27, 8
+	h.pool = NewPool(1+4+h.bufSize, h.bufCnt)
+	h.addr = net.HardwareAddr{0x0A, 0, 0, 0, 0, 1 + role}
+	h.evth[0x3E] = h.handleLEMeta
+	h.evth[evt.CommandCompleteCode] = h.handleCommandComplete
+	h.evth[evt.CommandStatusCode] = h.handleCommandStatus
+	h.evth[evt.NumberOfCompletedPacketsCode] = h.handleNumberOfCompletedPackets
+	h.evth[evt.EncryptionChangeCode] = h.handleEncryptionChange
+	h.subh[evt.LELongTermKeyRequestSubCode] = h.handleLELongTermKeyRequest
+	h.chCmdBufs <- make([]byte, cmdBufSize)
+	h.spawn(h.sktLoop)
+
+	e := evt.LEConnectionComplete{
+		evt.LEConnectionCompleteSubCode,
+		0x00,                               // Status
+		pipeHandle & 0xff, pipeHandle >> 8, // Connection Handle
+		role,
+		0x00,                       // Peer Address Type
+		2 - role, 0, 0, 0, 0, 0x0A, // Peer Address
+		0x18, 0x00, // Conn Interval
+		0x00, 0x00, // Conn Latency
+		0x48, 0x00, // Supervision Timeout
+		0x00, // Master Clock Accuracy
+	}
+	c := h.addConn(e)
+	return skt, c
+}
+
+func (p *smpPipe) run() {
+	var ltk []byte
+	for {
+		select {
+		case b := <-p.m.out:
+			switch op := opcode(b); {
+			case b[0] == pktTypeACLData:
+				p.forward(roleMaster, b, p.s, p.m)
+			case op == 0x2019: // LE Start Encryption
+				p.m.in <- []byte{pktTypeEvent, evt.CommandStatusCode, 4, 0x00, 1, b[1], b[2]}
+				ltk = append([]byte(nil), b[16:32]...)
+				e := []byte{pktTypeEvent, 0x3E, 13, evt.LELongTermKeyRequestSubCode, pipeHandle & 0xff, pipeHandle >> 8}
+				p.s.in <- append(e, b[6:16]...) // Random Number and EDIV
+			default:
+				p.m.in <- []byte{pktTypeEvent, evt.CommandCompleteCode, 4, 1, b[1], b[2], 0x00}
+			}
+		case b := <-p.s.out:
+			switch op := opcode(b); {
+			case b[0] == pktTypeACLData:
+				p.forward(roleSlave, b, p.m, p.s)
+			case op == 0x201A || op == 0x201B: // LE Long Term Key Request (Negative) Reply
+				p.s.in <- []byte{pktTypeEvent, evt.CommandCompleteCode, 6, 1, b[1], b[2], 0x00, pipeHandle & 0xff, pipeHandle >> 8}
+				p.ltkReplies <- op
+				if op == 0x201A && bytes.Equal(b[6:22], ltk) {
+					for _, s := range []*pipeSkt{p.m, p.s} {
+						s.in <- []byte{pktTypeEvent, evt.EncryptionChangeCode, 4, 0x00, pipeHandle & 0xff, pipeHandle >> 8, 0x01}
+					}
+					continue
+				}
+				// The master fails to encrypt the link without the key.
+				p.m.in <- []byte{pktTypeEvent, evt.EncryptionChangeCode, 4, uint8(ErrPINMissing), pipeHandle & 0xff, pipeHandle >> 8, 0x00}
+			default:
+				p.s.in <- []byte{pktTypeEvent, evt.CommandCompleteCode, 4, 1, b[1], b[2], 0x00}
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// opcode returns the opcode of an HCI command packet, or 0.
+func opcode(b []byte) uint16 {
+	if b[0] != pktTypeCommand {
+		return 0
+	}
+	return uint16(b[1]) | uint16(b[2])<<8
+}
+
+// forward delivers an ACL data packet sent by the device of the role, and
+// completes it.
+func (p *smpPipe) forward(role uint8, b []byte, to, from *pipeSkt) {
+	pbf := b[2] >> 4 & 0x03
+	if pbf == pbfHostToControllerStart {
+		pbf = pbfControllerToHostStart
+		// Only the SMP commands in a single fragment are tampered with,
+		// which are all but the Pairing Public Key.
+		if p.tamper != nil && pdu(b[5:]).cid() == cidSMP && len(b) == 9+pdu(b[5:]).dlen() {
+			cmd := p.tamper(role, append([]byte(nil), b[9:]...))
+			hdr := []byte{pktTypeACLData, b[1], b[2], uint8(4 + len(cmd)), 0, uint8(len(cmd)), 0, uint8(cidSMP), 0}
+			b = append(hdr, cmd...)
+		}
+	}
+	q := append([]byte(nil), b...)
+	q[2] = q[2]&0x0f | pbf<<4
+	to.in <- q
+	from.in <- []byte{pktTypeEvent, evt.NumberOfCompletedPacketsCode, 5, 1, pipeHandle & 0xff, pipeHandle >> 8, 1, 0}
+}
+
+// pairingDone returns a channel, which is closed once the ongoing pairing of
+// the connection, if any, has ended.
+func pairingDone(c *Conn) <-chan struct{} {
+	c.smpMu.Lock()
+	defer c.smpMu.Unlock()
+	if p := c.pairing; p != nil {
+		return p.done
+	}
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// testAgent is an Agent, which accepts the pairings, unless authorize is set.
+// The passkey displayed on one device is entered on the other one through
+// passkeys, which is shared by the agents of both.
+type testAgent struct {
+	io       IOCapability
+	passkeys chan uint32
+	wrong    bool // Enter a wrong passkey.
+
+	// If authorize is set, the pairing is authorized with the value received
+	// from it, once the request has been signaled on asked.
+	asked     chan struct{}
+	authorize chan bool
+
+	mu      sync.Mutex
+	numeric uint32 // Value of the numeric comparison.
+}
+
+func (a *testAgent) IOCapability() IOCapability { return a.io }
+
+func (a *testAgent) AuthorizePairing(c *Conn, bonding bool) (bool, bool) {
+	if a.authorize == nil {
+		return true, true
+	}
+	a.asked <- struct{}{}
+	accept := <-a.authorize
+	return accept, accept
+}
+
+func (a *testAgent) DisplayPasskey(c *Conn, passkey uint32) { a.passkeys <- passkey }
+
+func (a *testAgent) RequestPasskey(c *Conn) (uint32, bool) {
+	select {
+	case pk := <-a.passkeys:
+		if a.wrong {
+			pk = (pk + 1) % 1000000
+		}
+		return pk, true
+	case <-time.After(time.Second):
+		return 0, false
+	}
+}
+
+func (a *testAgent) ConfirmNumeric(c *Conn, value uint32) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.numeric = value
+	return true
+}
+
+func (a *testAgent) PairingComplete(c *Conn, level ble.SecurityLevel, err error) {}
+
+func (a *testAgent) numericValue() uint32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.numeric
+}
+
+// optLegacy makes the device not support LE Secure Connections.
+func optLegacy(h *HCI) error {
+	h.smp.authReq &^= authReqSC
+	return nil
+}
+
+// agents returns the agents of the master and the slave with the IO
+// capabilities, which share a passkey channel.
+func agents(m, s IOCapability, wrong bool) (ma, sa *testAgent) {
+	passkeys := make(chan uint32, 1)
+	return &testAgent{io: m, passkeys: passkeys, wrong: wrong}, &testAgent{io: s, passkeys: passkeys, wrong: wrong}
+}
+
+// flip returns a tamper function, which flips a bit of the value of the SMP
+// commands of the code sent by the device of the role.
+func flip(code uint8, role uint8) func(uint8, []byte) []byte {
+	return func(r uint8, b []byte) []byte {
+		if r == role && b[0] == code {
+			b[1] ^= 0x01
+		}
+		return b
+	}
+}
+
+// fail returns a tamper function, which replaces the SMP commands of the code
+// sent by the device of the role with a Pairing Failed.
+func fail(code uint8, role uint8) func(uint8, []byte) []byte {
+	return func(r uint8, b []byte) []byte {
+		if r == role && b[0] == code {
+			return []byte{pairingFailed, uint8(ErrPairingUnspecified)}
+		}
+		return b
+	}
+}
+
+func TestPairing(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mio, sio IOCapability // IO capabilities of the agents of the master and the slave.
+		legacy   bool
+		wrong    bool
+
+		// tamper is applied to the SMP commands, or failPeer replaces the
+		// Pairing Random of the device not initiating with a Pairing Failed.
+		tamper   func(uint8, []byte) []byte
+		failPeer bool
+
+		level ble.SecurityLevel
+		err   error
+	}{
+		{name: "just works", mio: NoInputNoOutput, sio: NoInputNoOutput, level: ble.SecurityUnauthenticated},
+		{name: "legacy just works", mio: NoInputNoOutput, sio: NoInputNoOutput, legacy: true, level: ble.SecurityUnauthenticated},
+		{name: "passkey master enters", mio: KeyboardOnly, sio: DisplayOnly, level: ble.SecuritySecureConnections},
+		{name: "passkey slave enters", mio: DisplayOnly, sio: KeyboardOnly, level: ble.SecuritySecureConnections},
+		{name: "legacy passkey master enters", mio: KeyboardOnly, sio: DisplayOnly, legacy: true, level: ble.SecurityAuthenticated},
+		{name: "legacy passkey slave enters", mio: DisplayOnly, sio: KeyboardOnly, legacy: true, level: ble.SecurityAuthenticated},
+		{name: "numeric comparison", mio: DisplayYesNo, sio: KeyboardDisplay, level: ble.SecuritySecureConnections},
+
+		{name: "wrong passkey", mio: KeyboardOnly, sio: DisplayOnly, wrong: true, err: ErrPairingConfirmValue},
+		{name: "legacy wrong passkey", mio: DisplayOnly, sio: KeyboardOnly, legacy: true, wrong: true, err: ErrPairingConfirmValue},
+		{name: "wrong confirm", mio: NoInputNoOutput, sio: NoInputNoOutput, legacy: true, tamper: flip(pairingConfirm, roleSlave), err: ErrPairingConfirmValue},
+		{name: "wrong DHKey check", mio: NoInputNoOutput, sio: NoInputNoOutput, tamper: flip(pairingDHKeyCheck, roleMaster), err: ErrPairingDHKeyCheck},
+		{name: "pairing failed", mio: NoInputNoOutput, sio: NoInputNoOutput, failPeer: true, err: ErrPairingUnspecified},
+	} {
+		for _, initiator := range []uint8{roleMaster, roleSlave} {
+			name := tc.name + " by master"
+			peer := uint8(roleSlave)
+			if initiator == roleSlave {
+				name, peer = tc.name+" by slave", roleMaster
+			}
+			t.Run(name, func(t *testing.T) {
+				ma, sa := agents(tc.mio, tc.sio, tc.wrong)
+				mopts, sopts := []Option{OptAgent(ma)}, []Option{OptAgent(sa)}
+				if tc.legacy {
+					mopts = append(mopts, optLegacy)
+				}
+				tamper := tc.tamper
+				if tc.failPeer {
+					tamper = fail(pairingRandom, peer)
+				}
+				p := newSMPPipe(t, mopts, sopts, tamper)
+
+				// The device initiating receives the Pairing Failed, if it
+				// doesn't send it.
+				c, other := p.mc, p.sc
+				if initiator == roleSlave {
+					c, other = p.sc, p.mc
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := c.Pair(ctx)
+				if err != tc.err {
+					t.Fatalf("err = %v, want %v", err, tc.err)
+				}
+				if err != nil {
+					if l := c.SecurityLevel(); l != ble.SecurityNone {
+						t.Errorf("level = %s after failed pairing", l)
+					}
+					return
+				}
+				select {
+				case <-pairingDone(other):
+				case <-ctx.Done():
+					t.Fatal("pairing not done on the other device")
+				}
+				for _, c := range []*Conn{p.mc, p.sc} {
+					if l := c.SecurityLevel(); l != tc.level {
+						t.Errorf("level of role %d = %s, want %s", c.param.Role(), l, tc.level)
+					}
+				}
+				mk, sk := p.mc.Keys(), p.sc.Keys()
+				if mk.PeerLTK == nil || sk.LocalLTK == nil || mk.PeerLTK.Key != sk.LocalLTK.Key {
+					t.Error("LTKs distributed by the slave differ")
+				}
+				if ma.numericValue() != sa.numericValue() {
+					t.Errorf("numeric values %d and %d differ", ma.numericValue(), sa.numericValue())
+				}
+			})
+		}
+	}
+}
+
+func TestPairingLTKRequestDuringAuthorization(t *testing.T) {
+	ks, err := NewFileKeyStore(filepath.Join(t.TempDir(), "bonds.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa := &testAgent{io: NoInputNoOutput, asked: make(chan struct{}, 1), authorize: make(chan bool, 1)}
+	p := newSMPPipe(t, nil, []Option{OptAgent(sa), OptKeyStore(ks)}, nil)
+	errc := make(chan error, 1)
+	go func() { errc <- p.mc.Pair(context.Background()) }()
+	select {
+	case <-sa.asked:
+	case <-time.After(time.Second):
+		t.Fatal("pairing not authorized")
+	}
+
+	// The master starts the encryption with an STK, which the slave hasn't
+	// generated while the user is authorizing the pairing.
+	p.s.in <- []byte{pktTypeEvent, 0x3E, 13, evt.LELongTermKeyRequestSubCode, pipeHandle & 0xff, pipeHandle >> 8,
+		0, 0, 0, 0, 0, 0, 0, 0, // Random Number
+		0, 0, // EDIV
+	}
+	select {
+	case op := <-p.ltkReplies:
+		if op != 0x201B {
+			t.Fatalf("LTK request replied with 0x%04X, want a negative reply", op)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LTK request not replied")
+	}
+	if l := p.sc.SecurityLevel(); l != ble.SecurityNone {
+		t.Errorf("level = %s before the pairing is authorized", l)
+	}
+
+	sa.authorize <- false
+	select {
+	case err := <-errc:
+		if err != ErrPairingNotSupported {
+			t.Errorf("err = %v, want %v", err, ErrPairingNotSupported)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pairing not failed")
+	}
+	if bonds, _ := ks.Bonds(); len(bonds) != 0 {
+		t.Errorf("%d bonds saved", len(bonds))
+	}
+}
+
+func TestPairingLegacyOOB(t *testing.T) {
+	p := newSMPPipe(t, []Option{optLegacy}, []Option{optLegacy}, nil)
+
+	// Each device generates its own TK, and passes the OOB data to the other.
+	md, err := p.mc.hci.LocalOOBData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := p.sc.hci.LocalOOBData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *md.TK == *sd.TK {
+		t.Fatal("same TKs generated")
+	}
+	for _, d := range []*OOBData{md, sd} {
+		b := d.Bytes()
+		pd, err := ParseOOBData(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pd.Addr != d.Addr || *pd.TK != *d.TK {
+			t.Fatalf("OOB data % X not parsed back", b)
+		}
+		if d == md {
+			p.sc.hci.SetPeerOOBData(pd)
+		} else {
+			p.mc.hci.SetPeerOOBData(pd)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.mc.Pair(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-pairingDone(p.sc)
+	for _, c := range []*Conn{p.mc, p.sc} {
+		if l := c.SecurityLevel(); l != ble.SecurityAuthenticated {
+			t.Errorf("level of role %d = %s, want %s", c.param.Role(), l, ble.SecurityAuthenticated)
+		}
+	}
+}
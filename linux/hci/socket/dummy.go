@@ -8,3 +8,8 @@ import "io"
 func NewSocket(id int) (io.ReadWriteCloser, error) {
 	return nil, nil
 }
+
+// NewMgmtSocket is a dummy function for non-Linux platform.
+func NewMgmtSocket(id int) (io.ReadWriteCloser, error) {
+	return nil, nil
+}
@@ -0,0 +1,7 @@
+package socket
+
+import "errors"
+
+// ErrUserChannelInUse is returned when another process holds the HCI User
+// Channel of the device.
+var ErrUserChannelInUse = errors.New("hci user channel is in use by another process")
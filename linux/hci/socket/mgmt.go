@@ -0,0 +1,148 @@
+// +build linux
+
+package socket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Bluetooth Management API of the Linux kernel. [BlueZ doc/mgmt-api.txt]
+const (
+	hciDevNone = 0xFFFF // Binds to no particular device.
+
+	mgmtOpReadInfo   = 0x0004 // Read Controller Information Command
+	mgmtOpSetPowered = 0x0005 // Set Powered Command
+
+	mgmtEvtCmdComplete = 0x0001 // Command Complete Event
+	mgmtEvtCmdStatus   = 0x0002 // Command Status Event
+
+	mgmtSettingPowered = 0x00000001
+
+	mgmtTimeout = 2 * time.Second
+)
+
+// mgmtError is the status returned by a failed management command.
+type mgmtError uint8
+
+const (
+	errMgmtBusy         mgmtError = 0x0A
+	errMgmtInvalidIndex mgmtError = 0x11
+)
+
+func (e mgmtError) Error() string {
+	switch e {
+	case errMgmtBusy:
+		return "mgmt: busy"
+	case errMgmtInvalidIndex:
+		return "mgmt: invalid index"
+	}
+	return fmt.Sprintf("mgmt: status 0x%02X", uint8(e))
+}
+
+// mgmt is a socket of the management channel (HCI_CHANNEL_CONTROL).
+type mgmt struct {
+	fd  int
+	buf []byte
+}
+
+func newMgmt() (*mgmt, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create mgmt socket")
+	}
+	sa := unix.SockaddrHCI{Dev: hciDevNone, Channel: unix.HCI_CHANNEL_CONTROL}
+	if err := unix.Bind(fd, &sa); err != nil {
+		unix.Close(fd)
+		return nil, errors.Wrap(err, "can't bind socket to hci control channel")
+	}
+	return &mgmt{fd: fd, buf: make([]byte, 1024)}, nil
+}
+
+func (m *mgmt) close() error {
+	return unix.Close(m.fd)
+}
+
+// send sends a command to the controller index, and returns the return
+// parameters of its Command Complete Event.
+func (m *mgmt) send(op uint16, index uint16, param []byte) ([]byte, error) {
+	b := make([]byte, 6+len(param))
+	binary.LittleEndian.PutUint16(b[0:], op)
+	binary.LittleEndian.PutUint16(b[2:], index)
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(param)))
+	copy(b[6:], param)
+	if _, err := unix.Write(m.fd, b); err != nil {
+		return nil, errors.Wrap(err, "can't write mgmt socket")
+	}
+
+	// Skip the events unrelated to the command.
+	deadline := time.Now().Add(mgmtTimeout)
+	for {
+		tmo := time.Until(deadline)
+		if tmo <= 0 {
+			return nil, errors.Errorf("mgmt: command 0x%04X timed out", op)
+		}
+		pfds := []unix.PollFd{{Fd: int32(m.fd), Events: unix.POLLIN}}
+		if n, err := unix.Poll(pfds, int(tmo/time.Millisecond)+1); err != nil || n == 0 {
+			continue
+		}
+		n, err := unix.Read(m.fd, m.buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't read mgmt socket")
+		}
+		e := m.buf[:n]
+		if len(e) < 9 {
+			continue
+		}
+		code := binary.LittleEndian.Uint16(e[0:])
+		if (code != mgmtEvtCmdComplete && code != mgmtEvtCmdStatus) ||
+			binary.LittleEndian.Uint16(e[2:]) != index ||
+			binary.LittleEndian.Uint16(e[6:]) != op {
+			continue
+		}
+		if status := e[8]; status != 0x00 {
+			return nil, mgmtError(status)
+		}
+		return append([]byte(nil), e[9:]...), nil
+	}
+}
+
+// powered reports whether the controller index is powered.
+func (m *mgmt) powered(index uint16) (bool, error) {
+	rp, err := m.send(mgmtOpReadInfo, index, nil)
+	if err != nil {
+		return false, err
+	}
+	// Address (6), Version (1), Manufacturer (2), Supported Settings (4),
+	// Current Settings (4), ...
+	if len(rp) < 17 {
+		return false, errors.New("mgmt: invalid controller information")
+	}
+	return binary.LittleEndian.Uint32(rp[13:])&mgmtSettingPowered != 0, nil
+}
+
+func (m *mgmt) setPowered(index uint16, on bool) error {
+	p := []byte{0x00}
+	if on {
+		p[0] = 0x01
+	}
+	_, err := m.send(mgmtOpSetPowered, index, p)
+	return err
+}
+
+// restorePower powers on the controller index once the kernel has taken it
+// back from the user channel, which happens asynchronously.
+func (m *mgmt) restorePower(index uint16) error {
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = m.setPowered(index, true); err != errMgmtInvalidIndex && err != errMgmtBusy {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return err
+}
@@ -31,6 +31,10 @@ const (
 	ioctlSize     = 4
 	hciMaxDevices = 16
 	typHCI        = 72 // 'H'
+
+	// readPollTimeout is the interval in ms a Read checks whether the Socket
+	// has been closed, while the controller sends nothing.
+	readPollTimeout = 100
 )
 
 var (
@@ -55,6 +59,12 @@ type Socket struct {
 	closed chan struct{}
 	rmu    sync.Mutex
 	wmu    sync.Mutex
+
+	// mgmt is set if the Socket was opened through the kernel's management
+	// interface, and powered is the power state to restore on Close.
+	mgmt    *mgmt
+	id      int
+	powered bool
 }
 
 // NewSocket returns a HCI User Channel of specified device id.
@@ -71,12 +81,12 @@ func NewSocket(id int) (*Socket, error) {
 		return open(fd, id)
 	}
 
-	req := devListRequest{devNum: hciMaxDevices}
-	if err = ioctl(uintptr(fd), hciGetDeviceList, uintptr(unsafe.Pointer(&req))); err != nil {
-		return nil, errors.Wrap(err, "can't get device list")
+	ids, err := devices(fd)
+	if err != nil {
+		return nil, err
 	}
 	var msg string
-	for id := 0; id < int(req.devNum); id++ {
+	for _, id := range ids {
 		s, err := open(fd, id)
 		if err == nil {
 			return s, nil
@@ -86,24 +96,122 @@ func NewSocket(id int) (*Socket, error) {
 	return nil, errors.Errorf("no devices available: %s", msg)
 }
 
-func open(fd, id int) (*Socket, error) {
-	// Reset the device in case previous session didn't cleanup properly.
-	if err := ioctl(uintptr(fd), hciDownDevice, uintptr(id)); err != nil {
-		return nil, errors.Wrap(err, "can't down device")
+// NewMgmtSocket returns a HCI User Channel of specified device id, which is
+// taken in coordination with the kernel's Bluetooth management interface, so it
+// coexists with BlueZ. The device is powered off through the management
+// interface before the user channel is taken, and is handed back to the kernel
+// in its previous power state when the Socket is closed.
+// If id is -1, the first available HCI device is returned.
+func NewMgmtSocket(id int) (*Socket, error) {
+	m, err := newMgmt()
+	if err != nil {
+		return nil, err
 	}
-	if err := ioctl(uintptr(fd), hciUpDevice, uintptr(id)); err != nil {
-		return nil, errors.Wrap(err, "can't up device")
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, unix.BTPROTO_HCI)
+	if err != nil {
+		m.close()
+		return nil, errors.Wrap(err, "can't create socket")
+	}
+
+	s, err := func() (*Socket, error) {
+		if id != -1 {
+			return openMgmt(m, fd, id)
+		}
+		ids, err := devices(fd)
+		if err != nil {
+			return nil, err
+		}
+		var msg string
+		for _, id := range ids {
+			s, err := openMgmt(m, fd, id)
+			if err == nil {
+				return s, nil
+			}
+			msg = msg + fmt.Sprintf("(hci%d: %s)", id, err)
+		}
+		return nil, errors.Errorf("no devices available: %s", msg)
+	}()
+	if err != nil {
+		unix.Close(fd)
+		m.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// devices returns the ids of the HCI devices.
+func devices(fd int) ([]int, error) {
+	req := devListRequest{devNum: hciMaxDevices}
+	if err := ioctl(uintptr(fd), hciGetDeviceList, uintptr(unsafe.Pointer(&req))); err != nil {
+		return nil, errors.Wrap(err, "can't get device list")
+	}
+	var ids []int
+	for i := 0; i < int(req.devNum); i++ {
+		ids = append(ids, int(req.devRequest[i].id))
+	}
+	return ids, nil
+}
+
+func openMgmt(m *mgmt, fd, id int) (*Socket, error) {
+	powered, err := m.powered(uint16(id))
+	if err == errMgmtInvalidIndex {
+		// The kernel hides a device from the management interface while its
+		// user channel is taken.
+		if exists(fd, id) {
+			return nil, ErrUserChannelInUse
+		}
+		return nil, errors.Errorf("no such device: hci%d", id)
+	}
+	if err != nil {
+		return nil, err
 	}
+	if powered {
+		if err := m.setPowered(uint16(id), false); err != nil {
+			return nil, errors.Wrap(err, "can't power off device")
+		}
+	}
+	// The device is down once powered off, so it is bound without the reset
+	// of open, which would power it on again.
+	s, err := bind(fd, id)
+	if err != nil {
+		if powered {
+			m.setPowered(uint16(id), true)
+		}
+		return nil, err
+	}
+	s.mgmt, s.id, s.powered = m, id, powered
+	return s, nil
+}
+
+// exists reports whether the HCI device id exists.
+func exists(fd, id int) bool {
+	var di [128]byte // struct hci_dev_info
+	di[0], di[1] = byte(id), byte(id>>8)
+	return ioctl(uintptr(fd), hciGetDeviceInfo, uintptr(unsafe.Pointer(&di))) == nil
+}
 
+func open(fd, id int) (*Socket, error) {
+	// Reset the device in case previous session didn't cleanup properly.
 	// HCI User Channel requires exclusive access to the device.
 	// The device has to be down at the time of binding.
-	if err := ioctl(uintptr(fd), hciDownDevice, uintptr(id)); err != nil {
-		return nil, errors.Wrap(err, "can't down device")
+	if err := reset(fd, id); err != nil {
+		// The device can't be brought down while another process holds its
+		// user channel.
+		if errors.Cause(err) == unix.EBUSY {
+			return nil, ErrUserChannelInUse
+		}
+		return nil, err
 	}
+	return bind(fd, id)
+}
 
-	// Bind the RAW socket to HCI User Channel
+// bind binds the socket to the HCI User Channel of the device, which is down.
+func bind(fd, id int) (*Socket, error) {
 	sa := unix.SockaddrHCI{Dev: uint16(id), Channel: unix.HCI_CHANNEL_USER}
-	if err := unix.Bind(fd, &sa); err != nil {
+	switch err := unix.Bind(fd, &sa); {
+	case err == unix.EUSERS:
+		return nil, ErrUserChannelInUse
+	case err != nil:
 		return nil, errors.Wrap(err, "can't bind socket to hci user channel")
 	}
 
@@ -118,14 +226,44 @@ func open(fd, id int) (*Socket, error) {
 	return &Socket{fd: fd, closed: make(chan struct{})}, nil
 }
 
-func (s *Socket) Read(p []byte) (int, error) {
-	select {
-	case <-s.closed:
-		return 0, io.EOF
-	default:
+// reset brings the device down, up, and down again.
+func reset(fd, id int) error {
+	if err := ioctl(uintptr(fd), hciDownDevice, uintptr(id)); err != nil {
+		return errors.Wrap(err, "can't down device")
+	}
+	if err := ioctl(uintptr(fd), hciUpDevice, uintptr(id)); err != nil {
+		return errors.Wrap(err, "can't up device")
+	}
+	if err := ioctl(uintptr(fd), hciDownDevice, uintptr(id)); err != nil {
+		return errors.Wrap(err, "can't down device")
 	}
+	return nil
+}
+
+func (s *Socket) Read(p []byte) (int, error) {
 	s.rmu.Lock()
 	defer s.rmu.Unlock()
+
+	// Close takes rmu, so Read doesn't block in the read, and returns once
+	// the Socket is closed, even if the controller doesn't respond.
+	for {
+		select {
+		case <-s.closed:
+			return 0, io.EOF
+		default:
+		}
+		pfds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(pfds, readPollTimeout)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "can't poll hci socket")
+		}
+		if n > 0 {
+			break
+		}
+	}
 	n, err := unix.Read(s.fd, p)
 	return n, errors.Wrap(err, "can't read hci socket")
 }
@@ -142,5 +280,16 @@ func (s *Socket) Close() error {
 	s.Write([]byte{0x01, 0x09, 0x10, 0x00})
 	s.rmu.Lock()
 	defer s.rmu.Unlock()
-	return errors.Wrap(unix.Close(s.fd), "can't close hci socket")
+	if err := unix.Close(s.fd); err != nil {
+		return errors.Wrap(err, "can't close hci socket")
+	}
+	if s.mgmt == nil {
+		return nil
+	}
+	// Hand the device back to the kernel.
+	defer s.mgmt.close()
+	if !s.powered {
+		return nil
+	}
+	return errors.Wrap(s.mgmt.restorePower(uint16(s.id)), "can't power on device")
 }
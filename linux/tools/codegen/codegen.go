@@ -93,6 +93,9 @@ var funcMap = template.FuncMap{
 		case "uint8":
 			s = fmt.Sprintf("func (r %s) %s () %s { return r[%d]}\n", n, k, v, cnt)
 			cnt++
+		case "int8":
+			s = fmt.Sprintf("func (r %s) %s () %s { return int8(r[%d])}\n", n, k, v, cnt)
+			cnt++
 		case "uint16":
 			s = fmt.Sprintf("func (r %s) %s () %s { return binary.LittleEndian.Uint16(r[%d:])}\n", n, k, v, cnt)
 			cnt += 2
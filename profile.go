@@ -30,6 +30,20 @@ const (
 	CharExtended    Property = 0x80 // supports extended properties
 )
 
+// Permission is the security requirements of accessing an attribute.
+// [Vol 3, Part F, 3.2.5]
+type Permission int
+
+// Attribute permission flags [Vol 3, Part F, 3.2.5]
+const (
+	PermReadEncrypted      Permission = 0x01 // may be read only on an encrypted link
+	PermReadAuthenticated  Permission = 0x02 // may be read only on a link encrypted with an authenticated key
+	PermReadAuthorized     Permission = 0x04 // may be read only if authorized by the AuthorizeHandler
+	PermWriteEncrypted     Permission = 0x10 // may be written only on an encrypted link
+	PermWriteAuthenticated Permission = 0x20 // may be written only on a link encrypted with an authenticated key
+	PermWriteAuthorized    Permission = 0x40 // may be written only if authorized by the AuthorizeHandler
+)
+
 // A Profile is composed of one or more services necessary to fulfill a use case.
 type Profile struct {
 	Services []*Service
@@ -98,16 +112,23 @@ func (s *Service) NewCharacteristic(u UUID) *Characteristic {
 type Characteristic struct {
 	UUID        UUID
 	Property    Property
-	Secure      Property // FIXME
+	Secure      Property // Deprecated: use Permission.
 	Descriptors []*Descriptor
 	CCCD        *Descriptor
 
+	// Permission and MinKeySize are the security requirements of accessing
+	// the value. A MinKeySize other than 0 requires the link to be encrypted
+	// with a key of at least MinKeySize octets.
+	Permission Permission
+	MinKeySize int
+
 	Value []byte
 
-	ReadHandler     ReadHandler
-	WriteHandler    WriteHandler
-	NotifyHandler   NotifyHandler
-	IndicateHandler NotifyHandler
+	ReadHandler      ReadHandler
+	WriteHandler     WriteHandler
+	NotifyHandler    NotifyHandler
+	IndicateHandler  NotifyHandler
+	AuthorizeHandler AuthorizeHandler
 
 	Handle      uint16
 	ValueHandle uint16
@@ -182,11 +203,17 @@ type Descriptor struct {
 	UUID     UUID
 	Property Property
 
+	// Permission and MinKeySize are the security requirements of accessing
+	// the descriptor, as the ones of a characteristic value.
+	Permission Permission
+	MinKeySize int
+
 	Handle uint16
 	Value  []byte
 
-	ReadHandler  ReadHandler
-	WriteHandler WriteHandler
+	ReadHandler      ReadHandler
+	WriteHandler     WriteHandler
+	AuthorizeHandler AuthorizeHandler
 }
 
 // SetValue makes the descriptor support read requests, and returns a static value.